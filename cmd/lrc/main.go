@@ -41,6 +41,7 @@ func main() {
 
 	app.Commands = []cli.Command{
 		accountCommands(),
+		trendCommands(),
 	}
 
 	sort.Sort(cli.CommandsByName(app.Commands))
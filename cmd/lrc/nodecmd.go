@@ -77,79 +77,86 @@ func startNode(ctx *cli.Context) error {
 }
 
 func unlockAccount(ctx *cli.Context, globalConfig *config.GlobalConfig) {
-	if "full" == globalConfig.Mode || "miner" == globalConfig.Mode {
-		unlockAccs := []accounts.Account{}
-		minerAccs := []string{}
-		if ctx.IsSet(utils.UnlockFlag.Name) {
-			unlocks := strings.Split(ctx.String(utils.UnlockFlag.Name), ",")
-			for _, acc := range unlocks {
-				if common.IsHexAddress(acc) {
-					unlockAccs = append(unlockAccs, accounts.Account{Address: common.HexToAddress(acc)})
-				} else {
-					utils.ExitWithErr(ctx.App.Writer, errors.New(acc+" is not a HexAddress"))
-				}
+	unlockAccs := []accounts.Account{}
+	requiredAccs := []string{}
+	if ctx.IsSet(utils.UnlockFlag.Name) {
+		unlocks := strings.Split(ctx.String(utils.UnlockFlag.Name), ",")
+		for _, acc := range unlocks {
+			if common.IsHexAddress(acc) {
+				unlockAccs = append(unlockAccs, accounts.Account{Address: common.HexToAddress(acc)})
+			} else {
+				utils.ExitWithErr(ctx.App.Writer, errors.New(acc+" is not a HexAddress"))
 			}
 		}
+	}
+	if "full" == globalConfig.Mode || "miner" == globalConfig.Mode {
 		for _, addr := range globalConfig.Miner.NormalMiners {
-			minerAccs = append(minerAccs, addr.Address)
+			requiredAccs = append(requiredAccs, addr.Address)
 		}
 		for _, addr := range globalConfig.Miner.PercentMiners {
-			minerAccs = append(minerAccs, addr.Address)
+			requiredAccs = append(requiredAccs, addr.Address)
 		}
 		//todo:it should not appear here, move it.
-		if len(minerAccs) <= 0 {
+		if len(requiredAccs) <= 0 {
 			utils.ExitWithErr(ctx.App.Writer, fmt.Errorf("require a address as miner to sign and submit ring when running as miner"))
 		}
-		for _, addr := range minerAccs {
-			unlocked := false
-			for _, unlockAcc := range unlockAccs {
-				if strings.ToLower(unlockAcc.Address.Hex()) == strings.ToLower(addr) {
-					unlocked = true
-				}
-			}
-			if !unlocked {
-				utils.ExitWithErr(ctx.App.Writer, fmt.Errorf("the address:%s used to mine ring must be unlocked ", addr))
+	}
+	// relay用该地址签名广播的消息
+	if "" != globalConfig.Ipfs.RelayAccount {
+		requiredAccs = append(requiredAccs, globalConfig.Ipfs.RelayAccount)
+	}
+	if len(requiredAccs) <= 0 {
+		return
+	}
+	for _, addr := range requiredAccs {
+		unlocked := false
+		for _, unlockAcc := range unlockAccs {
+			if strings.ToLower(unlockAcc.Address.Hex()) == strings.ToLower(addr) {
+				unlocked = true
 			}
 		}
+		if !unlocked {
+			utils.ExitWithErr(ctx.App.Writer, fmt.Errorf("the address:%s used to mine ring or sign broadcast must be unlocked ", addr))
+		}
+	}
 
-		var passwords []string
+	var passwords []string
+	if ctx.IsSet(utils.PasswordsFlag.Name) {
+		passwords = strings.Split(ctx.String(utils.PasswordsFlag.Name), ",")
+		if len(passwords) != len(unlockAccs) {
+			utils.ExitWithErr(ctx.App.Writer, errors.New("the count of passwords and unlocks not match "))
+		}
+	}
+	for idx, acc := range unlockAccs {
+		var passphrase string
 		if ctx.IsSet(utils.PasswordsFlag.Name) {
-			passwords = strings.Split(ctx.String(utils.PasswordsFlag.Name), ",")
-			if len(passwords) != len(unlockAccs) {
-				utils.ExitWithErr(ctx.App.Writer, errors.New("the count of passwords and unlocks not match "))
+			passphrase = passwords[idx]
+			if err := crypto.UnlockAccount(acc, passphrase); nil != err {
+				if keystore.ErrNoMatch == err {
+					log.Fatalf("err:", err.Error())
+				} else {
+					utils.ExitWithErr(ctx.App.Writer, errors.New("failed to unlock address:"+acc.Address.Hex()))
+				}
 			}
-		}
-		for idx, acc := range unlockAccs {
-			var passphrase string
-			if ctx.IsSet(utils.PasswordsFlag.Name) {
-				passphrase = passwords[idx]
+		} else {
+			unlocked := false
+			for trials := 1; trials < 4; trials++ {
+				fmt.Fprintf(ctx.App.Writer, "Unlocking account %s | Attempt %d/%d \n", acc.Address.Hex(), trials, 3)
+				passphrase, _ = getPassphraseFromTeminal(false, ctx.App.Writer)
 				if err := crypto.UnlockAccount(acc, passphrase); nil != err {
 					if keystore.ErrNoMatch == err {
 						log.Fatalf("err:", err.Error())
 					} else {
-						utils.ExitWithErr(ctx.App.Writer, errors.New("failed to unlock address:"+acc.Address.Hex()))
+						log.Infof("failed to unlock, try again")
 					}
+				} else {
+					unlocked = true
+					log.Infof("Unlocked address:%s", acc.Address.Hex())
+					break
 				}
-			} else {
-				unlocked := false
-				for trials := 1; trials < 4; trials++ {
-					fmt.Fprintf(ctx.App.Writer, "Unlocking account %s | Attempt %d/%d \n", acc.Address.Hex(), trials, 3)
-					passphrase, _ = getPassphraseFromTeminal(false, ctx.App.Writer)
-					if err := crypto.UnlockAccount(acc, passphrase); nil != err {
-						if keystore.ErrNoMatch == err {
-							log.Fatalf("err:", err.Error())
-						} else {
-							log.Infof("failed to unlock, try again")
-						}
-					} else {
-						unlocked = true
-						log.Infof("Unlocked address:%s", acc.Address.Hex())
-						break
-					}
-				}
-				if !unlocked {
-					utils.ExitWithErr(ctx.App.Writer, errors.New("3 incorrect passphrase attempts when unlocking address:"+acc.Address.Hex()))
-				}
+			}
+			if !unlocked {
+				utils.ExitWithErr(ctx.App.Writer, errors.New("3 incorrect passphrase attempts when unlocking address:"+acc.Address.Hex()))
 			}
 		}
 	}
@@ -0,0 +1,104 @@
+/*
+
+  Copyright 2017 Loopring Project Ltd (Loopring Foundation).
+
+  Licensed under the Apache License, Version 2.0 (the "License");
+  you may not use this file except in compliance with the License.
+  You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+  Unless required by applicable law or agreed to in writing, software
+  distributed under the License is distributed on an "AS IS" BASIS,
+  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+  See the License for the specific language governing permissions and
+  limitations under the License.
+
+*/
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/Loopring/relay/cmd/utils"
+	"github.com/Loopring/relay/config"
+	"github.com/Loopring/relay/dao"
+	"github.com/Loopring/relay/market"
+	"github.com/Loopring/relay/market/util"
+	"gopkg.in/urfave/cli.v1"
+)
+
+func trendCommands() cli.Command {
+	c := cli.Command{
+		Name:     "trend",
+		Usage:    "manage market trends",
+		Category: "trend commands:",
+		Subcommands: []cli.Command{
+			cli.Command{
+				Name:   "rebuild",
+				Usage:  "recompute trends of a market from fills and replace them week by week, rerun from the failed week to resume",
+				Action: rebuildTrend,
+				Flags: []cli.Flag{
+					cli.StringFlag{
+						Name:  "market",
+						Usage: "the market such as LRC-WETH, all markets if empty",
+					},
+					cli.Int64Flag{
+						Name:  "from",
+						Usage: "unix seconds, trends from the interval containing it are rebuilt",
+					},
+					cli.Int64Flag{
+						Name:  "to",
+						Usage: "unix seconds, trends until the interval containing it are rebuilt, default is now",
+					},
+				},
+			},
+		},
+	}
+	return c
+}
+
+func rebuildTrend(ctx *cli.Context) {
+	from := ctx.Int64("from")
+	to := ctx.Int64("to")
+	if to == 0 {
+		to = time.Now().Unix()
+	}
+	if from <= 0 || from > to {
+		utils.ExitWithErr(ctx.App.Writer, errors.New("from must be positive and not after to"))
+	}
+
+	globalConfig := config.LoadConfig(ctx.GlobalString("config"))
+	rds := dao.NewRdsService(globalConfig.Mysql)
+	rds.Prepare()
+	util.Initialize(rds, globalConfig.Common.ProtocolImpl.Address)
+
+	markets := util.AllMarkets
+	if mkt := ctx.String("market"); mkt != "" {
+		mkt = strings.ToUpper(mkt)
+		if !containsMarket(util.AllMarkets, mkt) {
+			utils.ExitWithErr(ctx.App.Writer, fmt.Errorf("market %s is not supported, supported markets:%s", mkt, strings.Join(util.AllMarkets, ",")))
+		}
+		markets = []string{mkt}
+	}
+
+	for _, mkt := range markets {
+		if err := market.RebuildTrends(rds, mkt, from, to); err != nil {
+			utils.ExitWithErr(ctx.App.Writer, err)
+		}
+		fmt.Fprintf(ctx.App.Writer, "rebuild trends of %s from %d to %d \n", mkt, from, to)
+	}
+}
+
+func containsMarket(markets []string, market string) bool {
+	for _, m := range markets {
+		if m == market {
+			return true
+		}
+	}
+	return false
+}
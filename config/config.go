@@ -84,7 +84,21 @@ type GlobalConfig struct {
 }
 
 type JsonrpcOptions struct {
-	Port int
+	Host                string
+	Port                int
+	WsPort              int
+	AllowedOrigins      []string
+	VirtualHosts        []string
+	MaxBodySize         int64
+	ReadTimeout         int64
+	WriteTimeout        int64
+	TlsCertFile         string
+	TlsKeyFile          string
+	EthMethods          []string // public eth namespace methods, empty means all forwarded methods
+	EthCacheTtl         int64    // seconds eth read results are cached within a block, 0 disables cache
+	EthTxDropTimeout    int64    // seconds after which a pending tx unknown to the node is dropped
+	FeeQuoteRingGas     int64    // gas of a two-order ring used by loopring_getFeeQuote
+	FeeQuoteMarginSplit uint8    // marginSplitPercentage returned by loopring_getFeeQuote, 1-100
 }
 
 func (c *GlobalConfig) defaultConfig() {
@@ -95,6 +109,7 @@ type OrderManagerOptions struct {
 	CutoffCacheExpireTime int64
 	CutoffCacheCleanTime  int64
 	DustOrderValue        int64
+	ExpireSweepInterval   int64
 }
 
 type IpfsOptions struct {
@@ -102,6 +117,22 @@ type IpfsOptions struct {
 	Port            int
 	ListenTopics    []string
 	BroadcastTopics []string
+	Transport       string   // ipfs(default), loopback or tcp
+	TcpListen       string   // listen address of tcp transport
+	TcpPeers        []string // addresses of other relays in the cluster of tcp transport
+	MarketTopic     string   // topic of orders per market, {version} and {market} are replaced, empty publishes to BroadcastTopics[0]
+	Markets         []string // markets subscribed through MarketTopic, empty means all markets
+
+	RelayAccount      string  // unlocked address signing envelopes of broadcast orders, empty broadcasts raw orders
+	RequireEnvelope   bool    // drop messages not wrapped in a signed envelope
+	EnvelopeMaxAge    int64   // seconds an envelope is valid after its timestamp
+	PeerMuteScore     float64 // peers whose score falls below are muted
+	PeerMuteTime      int64   // seconds a peer is muted
+	PeerScoreRecovery float64 // score a peer recovers per minute
+
+	SyncTopic     string // topic of order book snapshot sync, empty disables it
+	SyncTimeout   int64  // seconds waiting for snapshots of peers before fetching missing orders
+	SyncBatchSize int    // max orders in a sync message
 }
 
 func (opts IpfsOptions) Url() string {
@@ -186,15 +217,44 @@ type MarketCapOptions struct {
 }
 
 type GatewayFiltersOptions struct {
+	Enabled    []string
 	BaseFilter struct {
 		MinLrcFee int64
 		MaxPrice  int64
 	}
+	TokenFilter struct {
+		AllowTokens  []string
+		DeniedTokens []string
+	}
+	BalanceFilter struct {
+		Mode string
+	}
+	QuotaFilter struct {
+		MaxOpenOrders          int
+		WhiteListMaxOpenOrders int
+	}
+	PriceFilter struct {
+		Mode             string
+		MaxDeviation     float64
+		MarketDeviations map[string]float64
+	}
+	Params map[string]map[string]string
 }
 
 type GateWayOptions struct {
-	IsBroadcast      bool
-	MaxBroadcastTime int
+	IsBroadcast        bool
+	MaxBroadcastTime   int
+	BroadcastInterval  int64 // seconds before the second broadcast of an order, doubled after each broadcast
+	RateLimit          RateLimitOptions
+	WhiteListRateLimit RateLimitOptions
+}
+
+// RateLimitOptions rate is orders per second, 0 means unlimited
+type RateLimitOptions struct {
+	OwnerRate  float64
+	OwnerBurst int
+	IpRate     float64
+	IpBurst    int
 }
 
 type MysqlOptions struct {
@@ -74,12 +74,17 @@ func (s *RdsServiceImpl) Prepare() {
 	tables = append(tables, &Token{})
 	tables = append(tables, &EventLog{})
 	tables = append(tables, &FilledOrder{})
+	tables = append(tables, &OrderEvent{})
+	tables = append(tables, &Transaction{})
 
 	for _, t := range tables {
 		if ok := s.db.HasTable(t); !ok {
 			if err := s.db.CreateTable(t).Error; err != nil {
 				log.Fatalf("create mysql table error:%s", err.Error())
 			}
+		} else if err := s.db.AutoMigrate(t).Error; err != nil {
+			// 已有的表只补充新增的列和索引，不修改已有的列
+			log.Fatalf("migrate mysql table error:%s", err.Error())
 		}
 	}
 }
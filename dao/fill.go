@@ -22,6 +22,7 @@ import (
 	"fmt"
 	"github.com/Loopring/relay/types"
 	"github.com/ethereum/go-ethereum/common"
+	"github.com/jinzhu/gorm"
 )
 
 type FillEvent struct {
@@ -124,6 +125,31 @@ func (s *RdsServiceImpl) QueryRecentFills(market, owner string, start int64, end
 	return
 }
 
+// QueryFillsByTime 按(create_time, id)升序分页返回成交,用于聚合k线
+// 返回create_time等于start且id大于afterId,或create_time在(start,end]之间的成交,
+// 下一页以上一页最后一条的create_time和id作为start和afterId
+func (s *RdsServiceImpl) QueryFillsByTime(market string, start int64, afterId int, end int64, limit int) (fills []FillEvent, err error) {
+	err = s.db.Where("market = ? and ((create_time = ? and id > ?) or create_time > ?) and create_time <= ?", market, start, afterId, start, end).
+		Order("create_time asc, id asc").
+		Limit(limit).
+		Find(&fills).Error
+	return
+}
+
+func (s *RdsServiceImpl) QueryFillsAfterID(id, limit int) (fills []FillEvent, err error) {
+	err = s.db.Where("id > ?", id).Order("id asc").Limit(limit).Find(&fills).Error
+	return
+}
+
+func (s *RdsServiceImpl) GetMaxFillID() (int, error) {
+	var fill FillEvent
+	err := s.db.Order("id desc").First(&fill).Error
+	if err == gorm.ErrRecordNotFound {
+		return 0, nil
+	}
+	return fill.ID, err
+}
+
 func buildTimeQueryString(start, end int64) string {
 	rst := ""
 	if start != 0 && end == 0 {
@@ -47,10 +47,14 @@ type RdsService interface {
 	GetOrdersForMiner(protocol, tokenS, tokenB string, length int, filterStatus []types.OrderStatus, startBlockNumber, endBlockNumber int64) ([]*Order, error)
 	GetOrdersWithBlockNumberRange(from, to int64) ([]Order, error)
 	GetCutoffOrders(cutoffTime int64) ([]Order, error)
-	SetCutOff(owner common.Address, cutoffTime *big.Int) error
+	SetCutOff(owner common.Address, cutoffTime *big.Int) ([]Order, error)
+	SetExpiredOrders(now int64, limit int) ([]Order, error)
+	GetOpenOrderCount(owner common.Address, market string) (int, error)
+	GetOpenOrderHashes(protocol common.Address, market string) ([]string, error)
 	CheckOrderCutoff(orderhash string, cutoff int64) bool
-	GetOrderBook(protocol, tokenS, tokenB common.Address, length int) ([]Order, error)
+	GetOrderBook(protocol, tokenS, tokenB common.Address, offset, length int) ([]Order, error)
 	OrderPageQuery(query map[string]interface{}, pageIndex, pageSize int) (PageResult, error)
+	GetOrdersForBroadcast(dueBefore []int64, length int) ([]Order, error)
 	UpdateBroadcastTimeByHash(hash string, bt int) error
 	UpdateOrderWhileFill(hash common.Hash, status types.OrderStatus, dealtAmountS, dealtAmountB, splitAmountS, splitAmountB, blockNumber *big.Int) error
 	UpdateOrderWhileCancel(hash common.Hash, status types.OrderStatus, cancelledAmountS, cancelledAmountB, blockNumber *big.Int) error
@@ -67,6 +71,9 @@ type RdsService interface {
 	// fill event table
 	FindFillEventByRinghashAndOrderhash(ringhash, orderhash common.Hash) (*FillEvent, error)
 	QueryRecentFills(mkt, owner string, start int64, end int64) (fills []FillEvent, err error)
+	QueryFillsByTime(market string, start int64, afterId int, end int64, limit int) (fills []FillEvent, err error)
+	QueryFillsAfterID(id, limit int) (fills []FillEvent, err error)
+	GetMaxFillID() (int, error)
 	RollBackFill(from, to int64) error
 	FillsPageQuery(query map[string]interface{}, pageIndex, pageSize int) (res PageResult, err error)
 
@@ -80,9 +87,16 @@ type RdsService interface {
 	UpdateCutoffByProtocolAndOwner(protocol, owner common.Address, txhash common.Hash, blockNumber, cutoff, createTime *big.Int) error
 	RollBackCutoff(from, to int64) error
 
+	// order event table
+	GetOrderEvents(orderhash common.Hash) ([]OrderEvent, error)
+
 	// trend table
 	TrendPageQuery(query Trend, pageIndex, pageSize int) (pageResult PageResult, err error)
 	TrendQueryByTime(intervals, market string, start, end int64) (trends []Trend, err error)
+	TrendQueryByRange(intervals, market string, start, end int64) (trends []Trend, err error)
+	GetLatestTrend(intervals, market string) (*Trend, error)
+	ReplaceTrends(market string, ranges []TrendRange, trends []Trend) error
+	RenameTrendIntervals(from, to string) error
 
 	// white list
 	GetWhiteList() ([]WhiteList, error)
@@ -98,6 +112,11 @@ type RdsService interface {
 	GetRingHashesByTxHash(txHash common.Hash) ([]common.Hash, error)
 	RingMinedPageQuery(query map[string]interface{}, pageIndex, pageSize int) (res PageResult, err error)
 
+	// transaction table
+	GetPendingTransactions() ([]Transaction, error)
+	TransactionPageQuery(owner common.Address, statusSet []types.TxStatus, pageIndex, pageSize int) (res PageResult, err error)
+	UpdateTransactionStatus(txHash string, status types.TxStatus, blockNumber int64, gasUsed string, updateTime int64) error
+
 	// token
 	FindUnDeniedTokens() ([]Token, error)
 	FindDeniedTokens() ([]Token, error)
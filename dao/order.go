@@ -58,10 +58,11 @@ type Order struct {
 	CancelledAmountB      string  `gorm:"column:cancelled_amount_b;type:varchar(30)"`
 	SplitAmountS          string  `gorm:"column:split_amount_s;type:varchar(30)"`
 	SplitAmountB          string  `gorm:"column:split_amount_b;type:varchar(30)"`
-	Status                uint8   `gorm:"column:status;type:tinyint(4)"`
+	Status                uint8   `gorm:"column:status;type:tinyint(4);index"`
 	MinerBlockMark        int64   `gorm:"column:miner_block_mark;type:bigint"`
 	BroadcastTime         int     `gorm:"column:broadcast_time;type:bigint"`
 	Market                string  `gorm:"column:market;type:varchar(40)"`
+	IsLocal               bool    `gorm:"column:is_local;default:false"`
 }
 
 // convert types/orderState to dao/order
@@ -102,6 +103,7 @@ func (o *Order) ConvertDown(state *types.OrderState) error {
 	o.S = src.S.Hex()
 	o.R = src.R.Hex()
 	o.BroadcastTime = state.BroadcastTime
+	o.IsLocal = state.IsLocal
 
 	return nil
 }
@@ -140,6 +142,7 @@ func (o *Order) ConvertUp(state *types.OrderState) error {
 	state.UpdatedBlock = big.NewInt(o.UpdatedBlock)
 	state.Status = types.OrderStatus(o.Status)
 	state.BroadcastTime = o.BroadcastTime
+	state.IsLocal = o.IsLocal
 
 	return nil
 }
@@ -246,13 +249,55 @@ func (s *RdsServiceImpl) CheckOrderCutoff(orderhash string, cutoff int64) bool {
 	return true
 }
 
-func (s *RdsServiceImpl) SetCutOff(owner common.Address, cutoffTime *big.Int) error {
+// SetCutOff 将owner在cutoff之前创建的NEW/PARTIAL订单置为ORDER_CUTOFF,返回受影响的订单
+func (s *RdsServiceImpl) SetCutOff(owner common.Address, cutoffTime *big.Int) ([]Order, error) {
+	return s.setOpenOrdersStatus(types.ORDER_CUTOFF, 0, "valid_time < ? and owner = ?", cutoffTime.Int64(), owner.Hex())
+}
+
+// SetExpiredOrders 将最多limit个已过期的NEW/PARTIAL订单置为ORDER_EXPIRED,返回受影响的订单
+func (s *RdsServiceImpl) SetExpiredOrders(now int64, limit int) ([]Order, error) {
+	return s.setOpenOrdersStatus(types.ORDER_EXPIRED, limit, "valid_time + ttl <= ?", now)
+}
+
+// setOpenOrdersStatus 在事务中锁定符合条件的NEW/PARTIAL订单并修改状态，limit不大于0时不限数量。
+// 查询和更新之间成交或取消的订单不会被覆盖
+func (s *RdsServiceImpl) setOpenOrdersStatus(status types.OrderStatus, limit int, query string, args ...interface{}) ([]Order, error) {
+	var list []Order
 	filterStatus := []types.OrderStatus{types.ORDER_PARTIAL, types.ORDER_NEW}
-	err := s.db.Model(&Order{}).Where("valid_time < ? and owner = ? and status in (?)", cutoffTime.Int64(), owner.Hex(), filterStatus).Update("status", types.ORDER_CUTOFF).Error
-	return err
+
+	tx := s.db.Begin()
+	if err := tx.Error; err != nil {
+		return list, err
+	}
+
+	q := tx.Set("gorm:query_option", "FOR UPDATE").Where(query, args...).Where("status in (?)", filterStatus)
+	if limit > 0 {
+		q = q.Order("id asc").Limit(limit)
+	}
+	if err := q.Find(&list).Error; err != nil {
+		tx.Rollback()
+		return nil, err
+	}
+	if len(list) == 0 {
+		return list, tx.Commit().Error
+	}
+
+	if err := tx.Model(&Order{}).Where("id in (?) and status in (?)", orderIds(list), filterStatus).Update("status", status).Error; err != nil {
+		tx.Rollback()
+		return nil, err
+	}
+	return list, tx.Commit().Error
+}
+
+func orderIds(list []Order) []int {
+	var ids []int
+	for _, v := range list {
+		ids = append(ids, v.ID)
+	}
+	return ids
 }
 
-func (s *RdsServiceImpl) GetOrderBook(protocol, tokenS, tokenB common.Address, length int) ([]Order, error) {
+func (s *RdsServiceImpl) GetOrderBook(protocol, tokenS, tokenB common.Address, offset, length int) ([]Order, error) {
 	var (
 		list []Order
 		err  error
@@ -264,7 +309,10 @@ func (s *RdsServiceImpl) GetOrderBook(protocol, tokenS, tokenB common.Address, l
 		Where("token_s = ? and token_b = ?", tokenS.Hex(), tokenB.Hex()).
 		Where("status in (?)", filterStatus).
 		Where("valid_time < ?", nowtime).
+		Where("valid_time + ttl > ?", nowtime).
 		Order("price desc").
+		Order("id asc").
+		Offset(offset).
 		Limit(length).
 		Find(&list).Error
 
@@ -305,6 +353,34 @@ func (s *RdsServiceImpl) OrderPageQuery(query map[string]interface{}, pageIndex,
 	return pageResult, err
 }
 
+// GetOrdersForBroadcast 返回本relay接收的需要再次广播的NEW/PARTIAL订单，
+// 已广播n次的订单创建时间不晚于dueBefore[n]时到期，广播次数达到len(dueBefore)后不再返回
+func (s *RdsServiceImpl) GetOrdersForBroadcast(dueBefore []int64, length int) ([]Order, error) {
+	var list []Order
+	if len(dueBefore) == 0 {
+		return list, nil
+	}
+
+	var conds []string
+	var args []interface{}
+	for n, t := range dueBefore {
+		conds = append(conds, "(broadcast_time = ? and create_time <= ?)")
+		args = append(args, n, t)
+	}
+
+	filterStatus := []types.OrderStatus{types.ORDER_NEW, types.ORDER_PARTIAL}
+	nowtime := time.Now().Unix()
+	err := s.db.Where("is_local = ?", true).
+		Where("status in (?)", filterStatus).
+		Where("valid_time < ?", nowtime).
+		Where("valid_time + ttl > ?", nowtime).
+		Where(strings.Join(conds, " or "), args...).
+		Order("id asc").
+		Limit(length).
+		Find(&list).Error
+	return list, err
+}
+
 func (s *RdsServiceImpl) UpdateBroadcastTimeByHash(hash string, bt int) error {
 	return s.db.Model(&Order{}).Where("order_hash = ?", hash).Update("broadcast_time", bt).Error
 }
@@ -344,6 +420,30 @@ func (s *RdsServiceImpl) GetFrozenAmount(owner common.Address, token common.Addr
 	return list, err
 }
 
+func (s *RdsServiceImpl) GetOpenOrderCount(owner common.Address, market string) (int, error) {
+	var count int
+	filterStatus := []types.OrderStatus{types.ORDER_NEW, types.ORDER_PARTIAL}
+	err := s.db.Model(&Order{}).
+		Where("owner = ? and market = ? and status in (?)", owner.Hex(), market, filterStatus).
+		Where("valid_time + ttl > ?", time.Now().Unix()).
+		Count(&count).Error
+	return count, err
+}
+
+// GetOpenOrderHashes 返回协议下某市场所有未成交且在有效期内的订单hash
+func (s *RdsServiceImpl) GetOpenOrderHashes(protocol common.Address, market string) ([]string, error) {
+	var hashes []string
+	filterStatus := []types.OrderStatus{types.ORDER_NEW, types.ORDER_PARTIAL}
+	nowtime := time.Now().Unix()
+	err := s.db.Model(&Order{}).
+		Where("protocol = ? and market = ?", protocol.Hex(), market).
+		Where("status in (?)", filterStatus).
+		Where("valid_time < ?", nowtime).
+		Where("valid_time + ttl > ?", nowtime).
+		Pluck("order_hash", &hashes).Error
+	return hashes, err
+}
+
 func (s *RdsServiceImpl) GetFrozenLrcFee(owner common.Address, statusSet []types.OrderStatus) ([]Order, error) {
 	var (
 		list []Order
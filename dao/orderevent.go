@@ -0,0 +1,75 @@
+/*
+
+  Copyright 2017 Loopring Project Ltd (Loopring Foundation).
+
+  Licensed under the Apache License, Version 2.0 (the "License");
+  you may not use this file except in compliance with the License.
+  You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+  Unless required by applicable law or agreed to in writing, software
+  distributed under the License is distributed on an "AS IS" BASIS,
+  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+  See the License for the specific language governing permissions and
+  limitations under the License.
+
+*/
+
+package dao
+
+import (
+	"github.com/ethereum/go-ethereum/common"
+)
+
+// order event type
+const (
+	OrderEventNew          = "new"
+	OrderEventFill         = "fill"
+	OrderEventCancel       = "cancel"
+	OrderEventCutoff       = "cutoff"
+	OrderEventForkRollback = "fork_rollback"
+	OrderEventExpired      = "expired"
+)
+
+// OrderEvent 订单生命周期流水,只追加不修改
+type OrderEvent struct {
+	ID               int    `gorm:"column:id;primary_key;"`
+	OrderHash        string `gorm:"column:order_hash;type:varchar(82);index"`
+	Owner            string `gorm:"column:owner;type:varchar(42)"`
+	Event            string `gorm:"column:event;type:varchar(20)"`
+	Status           uint8  `gorm:"column:status;type:tinyint(4)"`
+	DealtAmountS     string `gorm:"column:dealt_amount_s;type:varchar(30)"`
+	DealtAmountB     string `gorm:"column:dealt_amount_b;type:varchar(30)"`
+	CancelledAmountS string `gorm:"column:cancelled_amount_s;type:varchar(30)"`
+	CancelledAmountB string `gorm:"column:cancelled_amount_b;type:varchar(30)"`
+	RingHash         string `gorm:"column:ring_hash;type:varchar(82)"`
+	TxHash           string `gorm:"column:tx_hash;type:varchar(82)"`
+	BlockNumber      int64  `gorm:"column:block_number"`
+	CreateTime       int64  `gorm:"column:create_time"`
+}
+
+// convert dao/Order snapshot to dao/OrderEvent
+func (e *OrderEvent) ConvertDown(event string, order *Order) error {
+	e.Event = event
+	e.OrderHash = order.OrderHash
+	e.Owner = order.Owner
+	e.Status = order.Status
+	e.DealtAmountS = order.DealtAmountS
+	e.DealtAmountB = order.DealtAmountB
+	e.CancelledAmountS = order.CancelledAmountS
+	e.CancelledAmountB = order.CancelledAmountB
+
+	return nil
+}
+
+func (s *RdsServiceImpl) GetOrderEvents(orderhash common.Hash) ([]OrderEvent, error) {
+	var (
+		list []OrderEvent
+		err  error
+	)
+
+	err = s.db.Where("order_hash = ?", orderhash.Hex()).Order("id asc").Find(&list).Error
+
+	return list, err
+}
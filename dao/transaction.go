@@ -0,0 +1,86 @@
+/*
+
+  Copyright 2017 Loopring Project Ltd (Loopring Foundation).
+
+  Licensed under the Apache License, Version 2.0 (the "License");
+  you may not use this file except in compliance with the License.
+  You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+  Unless required by applicable law or agreed to in writing, software
+  distributed under the License is distributed on an "AS IS" BASIS,
+  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+  See the License for the specific language governing permissions and
+  limitations under the License.
+
+*/
+
+package dao
+
+import (
+	"github.com/Loopring/relay/types"
+	"github.com/ethereum/go-ethereum/common"
+)
+
+// Transaction 通过relay提交的raw transaction
+type Transaction struct {
+	ID          int    `gorm:"column:id;primary_key;"`
+	TxHash      string `gorm:"column:tx_hash;type:varchar(82);unique_index"`
+	Owner       string `gorm:"column:owner;type:varchar(42);index"`
+	To          string `gorm:"column:to_address;type:varchar(42)"`
+	Method      string `gorm:"column:method;type:varchar(40)"`
+	Content     string `gorm:"column:content;type:text"`
+	Value       string `gorm:"column:value;type:varchar(40)"`
+	Nonce       int64  `gorm:"column:nonce;type:bigint"`
+	GasPrice    string `gorm:"column:gas_price;type:varchar(40)"`
+	GasLimit    string `gorm:"column:gas_limit;type:varchar(40)"`
+	GasUsed     string `gorm:"column:gas_used;type:varchar(40)"`
+	Status      uint8  `gorm:"column:status;type:tinyint(4)"`
+	BlockNumber int64  `gorm:"column:block_number;type:bigint"`
+	CreateTime  int64  `gorm:"column:create_time;type:bigint"`
+	UpdateTime  int64  `gorm:"column:update_time;type:bigint"`
+}
+
+func (s *RdsServiceImpl) GetPendingTransactions() ([]Transaction, error) {
+	var (
+		list []Transaction
+		err  error
+	)
+
+	err = s.db.Where("status = ?", types.TX_STATUS_PENDING).Order("id asc").Find(&list).Error
+
+	return list, err
+}
+
+func (s *RdsServiceImpl) TransactionPageQuery(owner common.Address, statusSet []types.TxStatus, pageIndex, pageSize int) (res PageResult, err error) {
+	txs := make([]Transaction, 0)
+	res = PageResult{PageIndex: pageIndex, PageSize: pageSize, Data: make([]interface{}, 0)}
+
+	query := s.db.Model(&Transaction{}).Where("owner = ?", owner.Hex())
+	if len(statusSet) > 0 {
+		query = query.Where("status in (?)", statusSet)
+	}
+
+	if err = query.Order("id desc").Offset((pageIndex - 1) * pageSize).Limit(pageSize).Find(&txs).Error; err != nil {
+		return res, err
+	}
+	if err = query.Count(&res.Total).Error; err != nil {
+		return res, err
+	}
+
+	for _, tx := range txs {
+		res.Data = append(res.Data, tx)
+	}
+	return
+}
+
+func (s *RdsServiceImpl) UpdateTransactionStatus(txHash string, status types.TxStatus, blockNumber int64, gasUsed string, updateTime int64) error {
+	items := map[string]interface{}{
+		"status":       uint8(status),
+		"block_number": blockNumber,
+		"gas_used":     gasUsed,
+		"update_time":  updateTime,
+	}
+	return s.db.Model(&Transaction{}).Where("tx_hash = ?", txHash).Updates(items).Error
+}
@@ -66,3 +66,71 @@ func (s *RdsServiceImpl) TrendQueryByTime(intervals, market string, start, end i
 	err = s.db.Where("intervals = ? and market = ? and start = ? and end = ?", intervals, market, start, end).Order("start desc").Find(&trends).Error
 	return
 }
+
+// TrendRange 某一周期下start在[Start,End]之间的k线
+type TrendRange struct {
+	Intervals string
+	Start     int64
+	End       int64
+}
+
+func (s *RdsServiceImpl) TrendQueryByRange(intervals, market string, start, end int64) (trends []Trend, err error) {
+	err = s.db.Where("intervals = ? and market = ? and start >= ? and start <= ?", intervals, market, start, end).Order("start asc").Find(&trends).Error
+	return
+}
+
+func (s *RdsServiceImpl) GetLatestTrend(intervals, market string) (*Trend, error) {
+	var trend Trend
+	err := s.db.Where("intervals = ? and market = ?", intervals, market).Order("start desc").First(&trend).Error
+	return &trend, err
+}
+
+// RenameTrendIntervals 将旧的周期名from迁移为to，已经存在to周期的k线时旧数据直接删除
+func (s *RdsServiceImpl) RenameTrendIntervals(from, to string) error {
+	tx := s.db.Begin()
+	if err := tx.Error; err != nil {
+		return err
+	}
+
+	var count int
+	if err := tx.Model(&Trend{}).Where("intervals = ?", to).Count(&count).Error; err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	var err error
+	if count == 0 {
+		err = tx.Model(&Trend{}).Where("intervals = ?", from).UpdateColumn("intervals", to).Error
+	} else {
+		err = tx.Where("intervals = ?", from).Delete(&Trend{}).Error
+	}
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	return tx.Commit().Error
+}
+
+// ReplaceTrends 在同一事务中删除ranges内的k线并写入trends
+func (s *RdsServiceImpl) ReplaceTrends(market string, ranges []TrendRange, trends []Trend) error {
+	tx := s.db.Begin()
+	if err := tx.Error; err != nil {
+		return err
+	}
+
+	for _, r := range ranges {
+		if err := tx.Where("intervals = ? and market = ? and start >= ? and start <= ?", r.Intervals, market, r.Start, r.End).Delete(&Trend{}).Error; err != nil {
+			tx.Rollback()
+			return err
+		}
+	}
+	for i := range trends {
+		if err := tx.Create(&trends[i]).Error; err != nil {
+			tx.Rollback()
+			return err
+		}
+	}
+
+	return tx.Commit().Error
+}
@@ -145,6 +145,28 @@ func (accessor *EthNodeAccessor) EstimateGas(callData []byte, to common.Address)
 	return
 }
 
+// EstimateTransactionGas 按发送者和value估算交易的gas，合约中依赖msg.sender的方法需要指定from
+func (accessor *EthNodeAccessor) EstimateTransactionGas(from, to common.Address, value *big.Int, callData []byte) (gas, gasPrice *big.Int, err error) {
+	var gasBig, gasPriceBig types.Big
+	if err = accessor.RetryCall(2, &gasPriceBig, "eth_gasPrice"); nil != err {
+		return
+	}
+	callArg := &CallArg{}
+	callArg.From = from
+	callArg.To = to
+	callArg.Data = common.ToHex(callData)
+	callArg.GasPrice = gasPriceBig
+	if nil != value {
+		callArg.Value = *types.NewBigPtr(value)
+	}
+	if err = accessor.RetryCall(2, &gasBig, "eth_estimateGas", callArg); nil != err {
+		return
+	}
+	gasPrice = gasPriceBig.BigInt()
+	gas = gasBig.BigInt()
+	return
+}
+
 func (accessor *EthNodeAccessor) ContractCallMethod(a *abi.ABI, contractAddress common.Address) func(result interface{}, methodName, blockParameter string, args ...interface{}) error {
 	return func(result interface{}, methodName string, blockParameter string, args ...interface{}) error {
 		if callData, err := a.Pack(methodName, args...); nil != err {
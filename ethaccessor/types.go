@@ -107,6 +107,7 @@ type TransactionReceipt struct {
 	To                string    `json:"to"`
 	TransactionHash   string    `json:"transactionHash"`
 	TransactionIndex  types.Big `json:"transactionIndex"`
+	Status            string    `json:"status"` // 0x1 success, 0x0 failure, empty before byzantium
 }
 
 type BlockIterator struct {
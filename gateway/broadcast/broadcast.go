@@ -0,0 +1,137 @@
+/*
+
+  Copyright 2017 Loopring Project Ltd (Loopring Foundation).
+
+  Licensed under the Apache License, Version 2.0 (the "License");
+  you may not use this file except in compliance with the License.
+  You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+  Unless required by applicable law or agreed to in writing, software
+  distributed under the License is distributed on an "AS IS" BASIS,
+  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+  See the License for the specific language governing permissions and
+  limitations under the License.
+
+*/
+
+// Package broadcast 是relay之间广播订单的传输层，与消息内容无关
+package broadcast
+
+import (
+	"fmt"
+	"sync"
+
+	"github.com/Loopring/relay/config"
+)
+
+// transports selected by IpfsOptions.Transport
+const (
+	TransportIpfs     = "ipfs"
+	TransportLoopback = "loopback"
+	TransportTcp      = "tcp"
+)
+
+// Message is a message received from a topic, From identifies the sender on the transport
+type Message struct {
+	Topic string
+	From  string
+	Data  []byte
+}
+
+type MessageHandler func(msg *Message)
+
+type Broadcaster interface {
+	Publish(topic string, data []byte) error
+
+	// Stop releases connections and goroutines of the broadcaster, Publish fails after Stop
+	Stop()
+}
+
+type Subscriber interface {
+
+	// Register subscribe topic, messages are handled after Start
+	Register(topic string) error
+
+	// Unregister unsubscribe topic
+	Unregister(topic string) error
+
+	// Start start receiving messages of registered topics
+	Start()
+
+	// Stop
+	Stop()
+}
+
+func NewBroadcaster(options *config.IpfsOptions) (Broadcaster, error) {
+	switch options.Transport {
+	case "", TransportIpfs:
+		return NewIpfsBroadcaster(options.Url()), nil
+	case TransportLoopback:
+		return DefaultLoopbackBus.Broadcaster(TransportLoopback), nil
+	case TransportTcp:
+		return NewTcpBroadcaster(options.TcpPeers), nil
+	}
+	return nil, fmt.Errorf("broadcast,unsupported transport %s", options.Transport)
+}
+
+// NewSubscriber creates the subscriber of transport, topics in ListenTopics are registered
+func NewSubscriber(options *config.IpfsOptions, handler MessageHandler) (sub Subscriber, err error) {
+	switch options.Transport {
+	case "", TransportIpfs:
+		sub = NewIpfsSubscriber(options.Url(), handler)
+	case TransportLoopback:
+		sub = DefaultLoopbackBus.Subscriber(handler)
+	case TransportTcp:
+		if options.TcpListen == "" {
+			return nil, fmt.Errorf("broadcast,tcp transport requires tcp_listen")
+		}
+		sub = NewTcpSubscriber(options.TcpListen, handler)
+	default:
+		return nil, fmt.Errorf("broadcast,unsupported transport %s", options.Transport)
+	}
+
+	for _, topic := range options.ListenTopics {
+		if err := sub.Register(topic); err != nil {
+			return nil, err
+		}
+	}
+	return sub, nil
+}
+
+// topicSet 记录loopback和tcp订阅的topic，这两种传输收到全部消息后在本地按topic过滤
+type topicSet struct {
+	topics map[string]bool
+	mtx    sync.RWMutex
+}
+
+func newTopicSet() *topicSet {
+	return &topicSet{topics: make(map[string]bool)}
+}
+
+func (s *topicSet) register(topic string) error {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	if s.topics[topic] {
+		return fmt.Errorf("broadcast,topic %s already exist", topic)
+	}
+	s.topics[topic] = true
+	return nil
+}
+
+func (s *topicSet) unregister(topic string) error {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	if !s.topics[topic] {
+		return fmt.Errorf("broadcast,topic %s do not exist", topic)
+	}
+	delete(s.topics, topic)
+	return nil
+}
+
+func (s *topicSet) contains(topic string) bool {
+	s.mtx.RLock()
+	defer s.mtx.RUnlock()
+	return s.topics[topic]
+}
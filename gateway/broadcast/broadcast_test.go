@@ -0,0 +1,212 @@
+/*
+
+  Copyright 2017 Loopring Project Ltd (Loopring Foundation).
+
+  Licensed under the Apache License, Version 2.0 (the "License");
+  you may not use this file except in compliance with the License.
+  You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+  Unless required by applicable law or agreed to in writing, software
+  distributed under the License is distributed on an "AS IS" BASIS,
+  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+  See the License for the specific language governing permissions and
+  limitations under the License.
+
+*/
+
+package broadcast_test
+
+import (
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Loopring/relay/config"
+	"github.com/Loopring/relay/gateway/broadcast"
+	"github.com/Loopring/relay/log"
+	"go.uber.org/zap"
+)
+
+func init() {
+	log.Initialize(config.LogOptions{ZapOpts: zap.NewDevelopmentConfig()})
+}
+
+func collect(ch chan *broadcast.Message) broadcast.MessageHandler {
+	return func(msg *broadcast.Message) {
+		ch <- msg
+	}
+}
+
+func expectMessage(t *testing.T, ch chan *broadcast.Message, topic, data string) *broadcast.Message {
+	select {
+	case msg := <-ch:
+		if msg.Topic != topic || string(msg.Data) != data {
+			t.Fatalf("expect %s:%s, got %s:%s", topic, data, msg.Topic, string(msg.Data))
+		}
+		return msg
+	case <-time.After(3 * time.Second):
+		t.Fatalf("expect %s:%s, got nothing", topic, data)
+	}
+	return nil
+}
+
+func expectNothing(t *testing.T, ch chan *broadcast.Message) {
+	select {
+	case msg := <-ch:
+		t.Fatalf("expect nothing, got %s:%s", msg.Topic, string(msg.Data))
+	case <-time.After(200 * time.Millisecond):
+	}
+}
+
+func TestLoopback(t *testing.T) {
+	bus := broadcast.NewLoopbackBus()
+	ch := make(chan *broadcast.Message, 10)
+	sub := bus.Subscriber(collect(ch))
+	pub := bus.Broadcaster("relay1")
+
+	if err := sub.Register("LRC-WETH"); err != nil {
+		t.Fatal(err)
+	}
+	if err := sub.Register("LRC-WETH"); err == nil {
+		t.Fatal("register topic twice should fail")
+	}
+
+	// 未Start时收不到消息
+	pub.Publish("LRC-WETH", []byte("order0"))
+	expectNothing(t, ch)
+
+	sub.Start()
+	pub.Publish("LRC-WETH", []byte("order1"))
+	pub.Publish("RDN-WETH", []byte("order2"))
+	msg := expectMessage(t, ch, "LRC-WETH", "order1")
+	if msg.From != "relay1" {
+		t.Fatalf("expect message from relay1, got %s", msg.From)
+	}
+	expectNothing(t, ch)
+
+	if err := sub.Unregister("LRC-WETH"); err != nil {
+		t.Fatal(err)
+	}
+	pub.Publish("LRC-WETH", []byte("order3"))
+	expectNothing(t, ch)
+
+	sub.Register("RDN-WETH")
+	sub.Stop()
+	pub.Publish("RDN-WETH", []byte("order4"))
+	expectNothing(t, ch)
+}
+
+func TestTcp(t *testing.T) {
+	ch := make(chan *broadcast.Message, 10)
+	sub := broadcast.NewTcpSubscriber("127.0.0.1:0", collect(ch))
+	sub.Register("LRC-WETH")
+	sub.Start()
+	defer sub.Stop()
+
+	addr := sub.Addr().String()
+	pub := broadcast.NewTcpBroadcaster([]string{addr})
+	defer pub.Stop()
+	if err := pub.Publish("RDN-WETH", []byte("order1")); err != nil {
+		t.Fatal(err)
+	}
+	if err := pub.Publish("LRC-WETH", []byte("order2")); err != nil {
+		t.Fatal(err)
+	}
+	msg := expectMessage(t, ch, "LRC-WETH", "order2")
+	if !strings.HasPrefix(msg.From, "127.0.0.1:") {
+		t.Fatalf("expect message from the connection address, got %s", msg.From)
+	}
+	expectNothing(t, ch)
+}
+
+func TestTcpNoPeer(t *testing.T) {
+	pub := broadcast.NewTcpBroadcaster([]string{"127.0.0.1:1"})
+	defer pub.Stop()
+	if err := pub.Publish("LRC-WETH", []byte("order1")); err != nil {
+		t.Fatalf("message should be queued while the peer is unreachable:%s", err.Error())
+	}
+
+	// 队列满后发布失败
+	for i := 0; i < 10000; i++ {
+		if err := pub.Publish("LRC-WETH", []byte("order1")); err != nil {
+			return
+		}
+	}
+	t.Fatal("publish should fail if no peer is reachable and the queue is full")
+}
+
+func TestTcpReconnect(t *testing.T) {
+	// 先占用一个端口再释放，peer启动前发布的消息在重连后送达
+	ch := make(chan *broadcast.Message, 10)
+	probe := broadcast.NewTcpSubscriber("127.0.0.1:0", collect(ch))
+	probe.Start()
+	addr := probe.Addr().String()
+	probe.Stop()
+
+	pub := broadcast.NewTcpBroadcaster([]string{addr})
+	defer pub.Stop()
+	if err := pub.Publish("LRC-WETH", []byte("order1")); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(100 * time.Millisecond)
+
+	sub := broadcast.NewTcpSubscriber(addr, collect(ch))
+	sub.Register("LRC-WETH")
+	sub.Start()
+	defer sub.Stop()
+
+	expectMessage(t, ch, "LRC-WETH", "order1")
+	pub.Publish("LRC-WETH", []byte("order2"))
+	expectMessage(t, ch, "LRC-WETH", "order2")
+}
+
+func TestTcpStop(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+
+	// 一个peer已连接，另一个不可达而处于重连等待中
+	pub := broadcast.NewTcpBroadcaster([]string{listener.Addr().String(), "127.0.0.1:1"})
+	if err := pub.Publish("LRC-WETH", []byte("order1")); err != nil {
+		t.Fatal(err)
+	}
+	conn, err := listener.Accept()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	time.Sleep(100 * time.Millisecond)
+
+	stopped := make(chan struct{})
+	go func() {
+		pub.Stop()
+		close(stopped)
+	}()
+	select {
+	case <-stopped:
+	case <-time.After(3 * time.Second):
+		t.Fatal("stop should not wait for the reconnect backoff")
+	}
+
+	// Stop返回时连接已关闭
+	conn.SetReadDeadline(time.Now().Add(3 * time.Second))
+	buf := make([]byte, 1024)
+	for {
+		if _, err := conn.Read(buf); err != nil {
+			if e, ok := err.(net.Error); ok && e.Timeout() {
+				t.Fatal("connection should be closed after stop")
+			}
+			break
+		}
+	}
+
+	if err := pub.Publish("LRC-WETH", []byte("order2")); err == nil {
+		t.Fatal("publish should fail after stop")
+	}
+	pub.Stop()
+}
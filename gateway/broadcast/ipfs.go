@@ -0,0 +1,161 @@
+/*
+
+  Copyright 2017 Loopring Project Ltd (Loopring Foundation).
+
+  Licensed under the Apache License, Version 2.0 (the "License");
+  you may not use this file except in compliance with the License.
+  You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+  Unless required by applicable law or agreed to in writing, software
+  distributed under the License is distributed on an "AS IS" BASIS,
+  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+  See the License for the specific language governing permissions and
+  limitations under the License.
+
+*/
+
+package broadcast
+
+import (
+	"fmt"
+	"sync"
+
+	"github.com/Loopring/relay/gateway/ipfs"
+	"github.com/Loopring/relay/log"
+	"github.com/ipfs/go-ipfs-api"
+)
+
+// IpfsBroadcaster publishes to ipfs pubsub through the ipfs http api
+type IpfsBroadcaster struct {
+	sh *shell.Shell
+}
+
+func NewIpfsBroadcaster(url string) *IpfsBroadcaster {
+	return &IpfsBroadcaster{sh: shell.NewShell(url)}
+}
+
+func (b *IpfsBroadcaster) Publish(topic string, data []byte) error {
+	return b.sh.PubSubPublish(topic, string(data))
+}
+
+// Stop 每次发布都是独立的http请求，没有需要释放的连接
+func (b *IpfsBroadcaster) Stop() {}
+
+// IpfsSubscriber streams every registered topic from the ipfs http api
+type IpfsSubscriber struct {
+	url     string
+	handler MessageHandler
+	subs    map[string]*subProxy
+	started bool
+	mtx     sync.Mutex
+}
+
+func NewIpfsSubscriber(url string, handler MessageHandler) *IpfsSubscriber {
+	return &IpfsSubscriber{url: url, handler: handler, subs: make(map[string]*subProxy)}
+}
+
+func (l *IpfsSubscriber) Register(topic string) error {
+	l.mtx.Lock()
+	defer l.mtx.Unlock()
+
+	if _, ok := l.subs[topic]; ok {
+		return fmt.Errorf("ipfs sub,topic %s already exist", topic)
+	}
+
+	proxy := &subProxy{topic: topic, url: l.url, handler: l.handler}
+	if l.started {
+		if err := proxy.listen(); err != nil {
+			return fmt.Errorf("ipfs sub,register new topic %s error %s", topic, err.Error())
+		}
+	}
+	l.subs[topic] = proxy
+
+	return nil
+}
+
+func (l *IpfsSubscriber) Unregister(topic string) error {
+	l.mtx.Lock()
+	defer l.mtx.Unlock()
+
+	proxy, ok := l.subs[topic]
+	if !ok {
+		return fmt.Errorf("ipfs sub, topic %s do not exist", topic)
+	}
+
+	if l.started {
+		proxy.quit()
+	}
+	delete(l.subs, topic)
+
+	return nil
+}
+
+func (l *IpfsSubscriber) Start() {
+	l.mtx.Lock()
+	defer l.mtx.Unlock()
+
+	for topic, v := range l.subs {
+		if err := v.listen(); err != nil {
+			log.Fatalf("ipfs sub,subscribe topic %s error:%s", topic, err.Error())
+		}
+	}
+	l.started = true
+}
+
+func (l *IpfsSubscriber) Stop() {
+	l.mtx.Lock()
+	defer l.mtx.Unlock()
+
+	if !l.started {
+		return
+	}
+	for _, v := range l.subs {
+		v.quit()
+	}
+	l.started = false
+}
+
+type subProxy struct {
+	topic    string
+	url      string
+	handler  MessageHandler
+	iterator *ipfs.PubSubSubscription
+	stop     chan struct{}
+}
+
+func (p *subProxy) listen() error {
+	iterator, err := ipfs.PubSubSubscribe(p.url, p.topic)
+	if err != nil {
+		return err
+	}
+	p.iterator = iterator
+	p.stop = make(chan struct{})
+
+	go func(iterator *ipfs.PubSubSubscription, stop chan struct{}) {
+		for {
+			record, err := iterator.Next()
+			if err != nil {
+				select {
+				case <-stop:
+					return
+				default:
+					log.Fatalf("ipfs sub,ipfs occurs err:%s shut down!", err.Error())
+				}
+			}
+			//record.data() have to contain two char: '{' and '}'
+			if len(record.Data()) > 2 {
+				log.Debugf("ipfs sub,accept data from topic %s and data is %s", p.topic, string(record.Data()))
+				p.handler(&Message{Topic: p.topic, From: record.From().Pretty(), Data: record.Data()})
+			}
+		}
+	}(iterator, p.stop)
+
+	return nil
+}
+
+func (p *subProxy) quit() {
+	close(p.stop)
+	p.iterator.Close()
+}
@@ -0,0 +1,104 @@
+/*
+
+  Copyright 2017 Loopring Project Ltd (Loopring Foundation).
+
+  Licensed under the Apache License, Version 2.0 (the "License");
+  you may not use this file except in compliance with the License.
+  You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+  Unless required by applicable law or agreed to in writing, software
+  distributed under the License is distributed on an "AS IS" BASIS,
+  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+  See the License for the specific language governing permissions and
+  limitations under the License.
+
+*/
+
+package broadcast
+
+import (
+	"sync"
+)
+
+// DefaultLoopbackBus is used when IpfsOptions.Transport is loopback,
+// orders published by the relay are received by itself
+var DefaultLoopbackBus = NewLoopbackBus()
+
+// LoopbackBus delivers messages in process, every subscriber joined receives
+// messages of its topics published by any broadcaster of the bus, including itself.
+// Messages are handled synchronously in Publish.
+type LoopbackBus struct {
+	subs map[*LoopbackSubscriber]bool
+	mtx  sync.RWMutex
+}
+
+func NewLoopbackBus() *LoopbackBus {
+	return &LoopbackBus{subs: make(map[*LoopbackSubscriber]bool)}
+}
+
+// Broadcaster returns a broadcaster whose messages are from name
+func (bus *LoopbackBus) Broadcaster(name string) *LoopbackBroadcaster {
+	return &LoopbackBroadcaster{bus: bus, name: name}
+}
+
+func (bus *LoopbackBus) Subscriber(handler MessageHandler) *LoopbackSubscriber {
+	return &LoopbackSubscriber{bus: bus, handler: handler, topics: newTopicSet()}
+}
+
+func (bus *LoopbackBus) publish(msg *Message) {
+	bus.mtx.RLock()
+	subs := make([]*LoopbackSubscriber, 0, len(bus.subs))
+	for sub := range bus.subs {
+		subs = append(subs, sub)
+	}
+	bus.mtx.RUnlock()
+
+	// handler可能再次publish，不能持有锁
+	for _, sub := range subs {
+		if sub.topics.contains(msg.Topic) {
+			data := make([]byte, len(msg.Data))
+			copy(data, msg.Data)
+			sub.handler(&Message{Topic: msg.Topic, From: msg.From, Data: data})
+		}
+	}
+}
+
+type LoopbackBroadcaster struct {
+	bus  *LoopbackBus
+	name string
+}
+
+func (b *LoopbackBroadcaster) Publish(topic string, data []byte) error {
+	b.bus.publish(&Message{Topic: topic, From: b.name, Data: data})
+	return nil
+}
+
+func (b *LoopbackBroadcaster) Stop() {}
+
+type LoopbackSubscriber struct {
+	bus     *LoopbackBus
+	handler MessageHandler
+	topics  *topicSet
+}
+
+func (s *LoopbackSubscriber) Register(topic string) error {
+	return s.topics.register(topic)
+}
+
+func (s *LoopbackSubscriber) Unregister(topic string) error {
+	return s.topics.unregister(topic)
+}
+
+func (s *LoopbackSubscriber) Start() {
+	s.bus.mtx.Lock()
+	defer s.bus.mtx.Unlock()
+	s.bus.subs[s] = true
+}
+
+func (s *LoopbackSubscriber) Stop() {
+	s.bus.mtx.Lock()
+	defer s.bus.mtx.Unlock()
+	delete(s.bus.subs, s)
+}
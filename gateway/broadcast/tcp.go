@@ -0,0 +1,300 @@
+/*
+
+  Copyright 2017 Loopring Project Ltd (Loopring Foundation).
+
+  Licensed under the Apache License, Version 2.0 (the "License");
+  you may not use this file except in compliance with the License.
+  You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+  Unless required by applicable law or agreed to in writing, software
+  distributed under the License is distributed on an "AS IS" BASIS,
+  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+  See the License for the specific language governing permissions and
+  limitations under the License.
+
+*/
+
+package broadcast
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/Loopring/relay/log"
+)
+
+const (
+	tcpDialTimeout  = 5 * time.Second
+	tcpWriteTimeout = 10 * time.Second
+	// 单条消息的最大长度
+	maxTcpFrameSize = 1024 * 1024
+	// 每个peer待发送消息的队列长度，peer长时间不可用时队列满后丢弃新消息
+	tcpQueueSize = 1024
+	// 连接失败后重连的间隔，每次失败翻倍直到上限
+	tcpMinBackoff = time.Second
+	tcpMaxBackoff = time.Minute
+)
+
+// tcpFrame is a message on the wire, one json object per line
+type tcpFrame struct {
+	Topic string `json:"topic"`
+	Data  []byte `json:"data"`
+}
+
+// TcpBroadcaster sends every message to all peers of a private relay cluster,
+// peers don't forward messages so the cluster must be fully connected.
+// Unlike ipfs and loopback, the sender doesn't receive its own messages.
+// Each peer has its own queue and sending goroutine, so a slow or unreachable peer doesn't block the others.
+type TcpBroadcaster struct {
+	peers   []*tcpPeer
+	stop    chan struct{}
+	stopped bool
+	wg      sync.WaitGroup
+	mtx     sync.RWMutex
+}
+
+func NewTcpBroadcaster(peers []string) *TcpBroadcaster {
+	b := &TcpBroadcaster{stop: make(chan struct{})}
+	for _, addr := range peers {
+		peer := &tcpPeer{addr: addr, queue: make(chan []byte, tcpQueueSize), stop: b.stop}
+		b.peers = append(b.peers, peer)
+		b.wg.Add(1)
+		go func(peer *tcpPeer) {
+			defer b.wg.Done()
+			peer.run()
+		}(peer)
+	}
+	return b
+}
+
+// Stop closes the queues and connections of all peers, messages not sent yet are dropped.
+// It returns after the sending goroutines exit, which may wait for a dial or write in progress to time out.
+func (b *TcpBroadcaster) Stop() {
+	b.mtx.Lock()
+	if b.stopped {
+		b.mtx.Unlock()
+		return
+	}
+	b.stopped = true
+	close(b.stop)
+	for _, peer := range b.peers {
+		close(peer.queue)
+	}
+	b.mtx.Unlock()
+
+	b.wg.Wait()
+}
+
+// Publish queues the message for every peer, it fails only if the queues of all peers are full
+func (b *TcpBroadcaster) Publish(topic string, data []byte) error {
+	frame, err := json.Marshal(&tcpFrame{Topic: topic, Data: data})
+	if err != nil {
+		return err
+	}
+	frame = append(frame, '\n')
+
+	// 持有读锁使Stop不会在发送时关闭队列
+	b.mtx.RLock()
+	defer b.mtx.RUnlock()
+	if b.stopped {
+		return fmt.Errorf("broadcast,tcp broadcaster stopped")
+	}
+
+	queued := 0
+	for _, peer := range b.peers {
+		select {
+		case peer.queue <- frame:
+			queued++
+		default:
+			log.Debugf("broadcast,tcp queue of peer %s is full, message of topic %s dropped", peer.addr, topic)
+		}
+	}
+	if queued == 0 && len(b.peers) > 0 {
+		return fmt.Errorf("broadcast,tcp queues of all peers are full")
+	}
+	return nil
+}
+
+// tcpPeer 只在自己的goroutine中拨号和发送
+type tcpPeer struct {
+	addr  string
+	queue chan []byte
+	stop  chan struct{}
+	conn  net.Conn
+}
+
+func (p *tcpPeer) run() {
+	defer p.close()
+
+	backoff := tcpMinBackoff
+	for {
+		var frame []byte
+		select {
+		case f, ok := <-p.queue:
+			if !ok {
+				return
+			}
+			frame = f
+		case <-p.stop:
+			return
+		}
+
+		// 发送失败的消息在重连后重发
+		for {
+			err := p.send(frame)
+			if err == nil {
+				backoff = tcpMinBackoff
+				break
+			}
+			log.Debugf("broadcast,tcp send to peer %s error:%s, retry after %s", p.addr, err.Error(), backoff.String())
+			select {
+			case <-time.After(backoff):
+			case <-p.stop:
+				return
+			}
+			if backoff *= 2; backoff > tcpMaxBackoff {
+				backoff = tcpMaxBackoff
+			}
+		}
+	}
+}
+
+func (p *tcpPeer) close() {
+	if p.conn != nil {
+		p.conn.Close()
+		p.conn = nil
+	}
+}
+
+// send 连接断开后在下次发送时重连
+func (p *tcpPeer) send(frame []byte) error {
+	if p.conn == nil {
+		conn, err := net.DialTimeout("tcp", p.addr, tcpDialTimeout)
+		if err != nil {
+			return err
+		}
+		p.conn = conn
+	}
+
+	p.conn.SetWriteDeadline(time.Now().Add(tcpWriteTimeout))
+	if _, err := p.conn.Write(frame); err != nil {
+		p.conn.Close()
+		p.conn = nil
+		return err
+	}
+	return nil
+}
+
+// TcpSubscriber accepts connections of peers and handles messages of registered topics
+type TcpSubscriber struct {
+	listen   string
+	handler  MessageHandler
+	topics   *topicSet
+	listener net.Listener
+	conns    map[net.Conn]bool
+	mtx      sync.Mutex
+}
+
+func NewTcpSubscriber(listen string, handler MessageHandler) *TcpSubscriber {
+	return &TcpSubscriber{listen: listen, handler: handler, topics: newTopicSet(), conns: make(map[net.Conn]bool)}
+}
+
+func (s *TcpSubscriber) Register(topic string) error {
+	return s.topics.register(topic)
+}
+
+func (s *TcpSubscriber) Unregister(topic string) error {
+	return s.topics.unregister(topic)
+}
+
+func (s *TcpSubscriber) Start() {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+
+	listener, err := net.Listen("tcp", s.listen)
+	if err != nil {
+		log.Fatalf("broadcast,tcp listen on %s error:%s", s.listen, err.Error())
+	}
+	s.listener = listener
+	go s.accept(listener)
+}
+
+func (s *TcpSubscriber) Stop() {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+
+	if s.listener == nil {
+		return
+	}
+	s.listener.Close()
+	s.listener = nil
+	for conn := range s.conns {
+		conn.Close()
+	}
+	s.conns = make(map[net.Conn]bool)
+}
+
+// Addr returns the listening address, nil before Start
+func (s *TcpSubscriber) Addr() net.Addr {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+
+	if s.listener == nil {
+		return nil
+	}
+	return s.listener.Addr()
+}
+
+func (s *TcpSubscriber) accept(listener net.Listener) {
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			log.Debugf("broadcast,tcp listener on %s closed:%s", s.listen, err.Error())
+			return
+		}
+
+		s.mtx.Lock()
+		if s.listener != listener {
+			s.mtx.Unlock()
+			conn.Close()
+			return
+		}
+		s.conns[conn] = true
+		s.mtx.Unlock()
+
+		go s.serve(conn)
+	}
+}
+
+func (s *TcpSubscriber) serve(conn net.Conn) {
+	defer func() {
+		conn.Close()
+		s.mtx.Lock()
+		delete(s.conns, conn)
+		s.mtx.Unlock()
+	}()
+
+	scanner := bufio.NewScanner(conn)
+	scanner.Buffer(make([]byte, 4096), maxTcpFrameSize)
+	for scanner.Scan() {
+		frame := &tcpFrame{}
+		if err := json.Unmarshal(scanner.Bytes(), frame); err != nil {
+			log.Errorf("broadcast,tcp invalid frame from %s:%s", conn.RemoteAddr().String(), err.Error())
+			continue
+		}
+		if !s.topics.contains(frame.Topic) {
+			continue
+		}
+		// 发送者由连接的地址确定，不能由消息内容声明
+		s.handler(&Message{Topic: frame.Topic, From: conn.RemoteAddr().String(), Data: frame.Data})
+	}
+	if err := scanner.Err(); err != nil {
+		log.Debugf("broadcast,tcp connection from %s closed:%s", conn.RemoteAddr().String(), err.Error())
+	}
+}
@@ -0,0 +1,103 @@
+/*
+
+  Copyright 2017 Loopring Project Ltd (Loopring Foundation).
+
+  Licensed under the Apache License, Version 2.0 (the "License");
+  you may not use this file except in compliance with the License.
+  You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+  Unless required by applicable law or agreed to in writing, software
+  distributed under the License is distributed on an "AS IS" BASIS,
+  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+  See the License for the specific language governing permissions and
+  limitations under the License.
+
+*/
+
+package gateway
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/Loopring/relay/crypto"
+	"github.com/Loopring/relay/params"
+	"github.com/Loopring/relay/types"
+	"github.com/ethereum/go-ethereum/common"
+)
+
+// 未指定时信封在签名后10分钟内有效
+const defaultEnvelopeMaxAge = 10 * 60
+
+// RelayEnvelope wraps a broadcast message, signed by the sending relay
+type RelayEnvelope struct {
+	Version   string          `json:"version"`
+	Timestamp int64           `json:"timestamp"`
+	Relay     common.Address  `json:"relay"`
+	Payload   json.RawMessage `json:"payload"`
+	V         uint8           `json:"v"`
+	R         types.Bytes32   `json:"r"`
+	S         types.Bytes32   `json:"s"`
+}
+
+func (e *RelayEnvelope) GenerateHash() []byte {
+	timestamp := make([]byte, 8)
+	binary.BigEndian.PutUint64(timestamp, uint64(e.Timestamp))
+	return crypto.GenerateHash([]byte(e.Version), timestamp, e.Relay.Bytes(), e.Payload)
+}
+
+func newRelayEnvelope(relay common.Address, payload []byte) (*RelayEnvelope, error) {
+	e := &RelayEnvelope{Version: params.Version, Timestamp: time.Now().Unix(), Relay: relay, Payload: payload}
+	sig, err := crypto.Sign(e.GenerateHash(), relay)
+	if err != nil {
+		return nil, err
+	}
+	v, r, s := crypto.SigToVRS(sig)
+	e.V = uint8(v)
+	e.R = types.BytesToBytes32(r)
+	e.S = types.BytesToBytes32(s)
+	return e, nil
+}
+
+// verify checks the signer is Relay and the envelope is not older than maxAge seconds
+func (e *RelayEnvelope) verify(maxAge int64) error {
+	if age := time.Now().Unix() - e.Timestamp; age > maxAge || age < -maxAge {
+		return fmt.Errorf("envelope timestamp %d out of range", e.Timestamp)
+	}
+	// V为27或28，校验时使用恢复id
+	if e.V < 27 || !crypto.ValidateSignatureValues(e.V-27, e.R.Bytes(), e.S.Bytes()) {
+		return errors.New("invalid envelope signature values")
+	}
+	sig, err := crypto.VRSToSig(e.V, e.R.Bytes(), e.S.Bytes())
+	if err != nil {
+		return err
+	}
+	signer, err := crypto.SigToAddress(e.GenerateHash(), sig)
+	if err != nil {
+		return err
+	}
+	if common.BytesToAddress(signer) != e.Relay {
+		return fmt.Errorf("envelope signer %s is not relay %s", common.BytesToAddress(signer).Hex(), e.Relay.Hex())
+	}
+	return nil
+}
+
+// openMessage returns the payload of an envelope, or data itself if it isn't an envelope
+func openMessage(data []byte, requireEnvelope bool, maxAge int64) (payload []byte, envelope *RelayEnvelope, err error) {
+	envelope = &RelayEnvelope{}
+	if err := json.Unmarshal(data, envelope); err != nil || len(envelope.Payload) == 0 {
+		if requireEnvelope {
+			return nil, nil, errors.New("message is not in a signed envelope")
+		}
+		return data, nil, nil
+	}
+	if err := envelope.verify(maxAge); err != nil {
+		return nil, envelope, err
+	}
+	return envelope.Payload, envelope, nil
+}
@@ -0,0 +1,113 @@
+/*
+
+  Copyright 2017 Loopring Project Ltd (Loopring Foundation).
+
+  Licensed under the Apache License, Version 2.0 (the "License");
+  you may not use this file except in compliance with the License.
+  You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+  Unless required by applicable law or agreed to in writing, software
+  distributed under the License is distributed on an "AS IS" BASIS,
+  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+  See the License for the specific language governing permissions and
+  limitations under the License.
+
+*/
+
+package gateway
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/Loopring/relay/crypto"
+	"github.com/ethereum/go-ethereum/accounts/keystore"
+	"github.com/ethereum/go-ethereum/common"
+)
+
+func newTestSigner(t *testing.T) (common.Address, func()) {
+	dir, err := ioutil.TempDir("", "relay-keystore")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ks := keystore.NewKeyStore(dir, keystore.LightScryptN, keystore.LightScryptP)
+	account, err := ks.NewAccount("1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := ks.Unlock(account, "1"); err != nil {
+		t.Fatal(err)
+	}
+	crypto.Initialize(crypto.NewCrypto(true, ks))
+	return account.Address, func() {
+		crypto.Initialize(crypto.NewCrypto(true, nil))
+		os.RemoveAll(dir)
+	}
+}
+
+func TestRelayEnvelope(t *testing.T) {
+	relay, cleanup := newTestSigner(t)
+	defer cleanup()
+
+	payload := []byte(`{"hash":"0x01"}`)
+	envelope, err := newRelayEnvelope(relay, payload)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := json.Marshal(envelope)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	opened, received, err := openMessage(data, true, defaultEnvelopeMaxAge)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(opened) != string(payload) || received.Relay != relay {
+		t.Fatalf("unexpected payload %s from %s", string(opened), received.Relay.Hex())
+	}
+
+	// 篡改内容、冒充其他relay或过期的信封都无法通过验证
+	tampered := *envelope
+	tampered.Payload = []byte(`{"hash":"0x02"}`)
+	if err := tampered.verify(defaultEnvelopeMaxAge); err == nil {
+		t.Errorf("tampered payload should fail")
+	}
+	forged := *envelope
+	forged.Relay = common.HexToAddress("0x01")
+	if err := forged.verify(defaultEnvelopeMaxAge); err == nil {
+		t.Errorf("forged relay should fail")
+	}
+	expired, err := newRelayEnvelope(relay, payload)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expired.Timestamp = time.Now().Unix() - defaultEnvelopeMaxAge - 1
+	if err := expired.verify(defaultEnvelopeMaxAge); err == nil {
+		t.Errorf("expired envelope should fail")
+	}
+	if _, _, err := openMessage(mustMarshal(t, &tampered), false, defaultEnvelopeMaxAge); err == nil {
+		t.Errorf("open tampered envelope should fail")
+	}
+
+	// 不是信封的消息原样返回，除非要求信封
+	if opened, received, err := openMessage(payload, false, defaultEnvelopeMaxAge); err != nil || received != nil || string(opened) != string(payload) {
+		t.Errorf("plain message should be returned as is, got %s %v", string(opened), err)
+	}
+	if _, _, err := openMessage(payload, true, defaultEnvelopeMaxAge); err == nil {
+		t.Errorf("plain message should fail if envelope is required")
+	}
+}
+
+func mustMarshal(t *testing.T, v interface{}) []byte {
+	data, err := json.Marshal(v)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return data
+}
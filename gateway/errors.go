@@ -0,0 +1,109 @@
+/*
+
+  Copyright 2017 Loopring Project Ltd (Loopring Foundation).
+
+  Licensed under the Apache License, Version 2.0 (the "License");
+  you may not use this file except in compliance with the License.
+  You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+  Unless required by applicable law or agreed to in writing, software
+  distributed under the License is distributed on an "AS IS" BASIS,
+  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+  See the License for the specific language governing permissions and
+  limitations under the License.
+
+*/
+
+package gateway
+
+import (
+	"fmt"
+
+	"github.com/ethereum/go-ethereum/rpc"
+)
+
+// JSON-RPC error codes of loopring namespace, the range -32000 to -32099 is
+// reserved by JSON-RPC 2.0 for implementation-defined server errors.
+const (
+	ErrCodeInternal            = -32000
+	ErrCodeInvalidOrder        = -32010
+	ErrCodeInvalidSignature    = -32011
+	ErrCodeUnsupportedToken    = -32012
+	ErrCodeOrderCutoff         = -32013
+	ErrCodePriceOutOfRange     = -32014
+	ErrCodeOrderExpired        = -32015
+	ErrCodeDuplicateOrder      = -32016
+	ErrCodeInsufficientBalance = -32017
+	ErrCodeUnsupportedMarket   = -32018
+	ErrCodeRateLimited         = -32019
+	ErrCodeTooManyOpenOrders   = -32020
+	ErrCodeGasEstimationFailed = -32021
+	ErrCodeMethodNotFound      = -32601
+	ErrCodeInvalidParams       = -32602
+)
+
+var errorReasons = map[int]string{
+	ErrCodeInternal:            "internal_error",
+	ErrCodeInvalidOrder:        "invalid_order",
+	ErrCodeInvalidSignature:    "invalid_signature",
+	ErrCodeUnsupportedToken:    "unsupported_token",
+	ErrCodeOrderCutoff:         "order_cutoff",
+	ErrCodePriceOutOfRange:     "price_out_of_range",
+	ErrCodeOrderExpired:        "order_expired",
+	ErrCodeDuplicateOrder:      "duplicate_order",
+	ErrCodeInsufficientBalance: "insufficient_balance",
+	ErrCodeUnsupportedMarket:   "unsupported_market",
+	ErrCodeRateLimited:         "rate_limited",
+	ErrCodeTooManyOpenOrders:   "too_many_open_orders",
+	ErrCodeGasEstimationFailed: "gas_estimation_failed",
+	ErrCodeMethodNotFound:      "method_not_found",
+	ErrCodeInvalidParams:       "invalid_params",
+}
+
+// JsonrpcError is returned by loopring json-rpc methods and gateway filters,
+// the rpc server writes it as a JSON-RPC error object with code, message and data,
+// data always contains a machine readable reason.
+type JsonrpcError struct {
+	Code    int
+	Message string
+	Data    map[string]interface{}
+}
+
+func NewJsonrpcError(code int, format string, args ...interface{}) *JsonrpcError {
+	return &JsonrpcError{
+		Code:    code,
+		Message: fmt.Sprintf(format, args...),
+		Data:    map[string]interface{}{"reason": errorReasons[code]},
+	}
+}
+
+// With add key/value to error data
+func (e *JsonrpcError) With(key string, value interface{}) *JsonrpcError {
+	e.Data[key] = value
+	return e
+}
+
+func (e *JsonrpcError) Error() string {
+	return e.Message
+}
+
+func (e *JsonrpcError) ErrorCode() int {
+	return e.Code
+}
+
+func (e *JsonrpcError) ErrorData() interface{} {
+	return e.Data
+}
+
+// internalError wraps errors of order manager, dao, eth node etc., errors with code are returned as is
+func internalError(err error) error {
+	if err == nil {
+		return nil
+	}
+	if _, ok := err.(rpc.Error); ok {
+		return err
+	}
+	return NewJsonrpcError(ErrCodeInternal, "%s", err.Error())
+}
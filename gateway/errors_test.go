@@ -0,0 +1,169 @@
+/*
+
+  Copyright 2017 Loopring Project Ltd (Loopring Foundation).
+
+  Licensed under the Apache License, Version 2.0 (the "License");
+  you may not use this file except in compliance with the License.
+  You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+  Unless required by applicable law or agreed to in writing, software
+  distributed under the License is distributed on an "AS IS" BASIS,
+  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+  See the License for the specific language governing permissions and
+  limitations under the License.
+
+*/
+
+package gateway
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/Loopring/relay/config"
+	"github.com/Loopring/relay/crypto"
+	"github.com/Loopring/relay/log"
+	"github.com/ethereum/go-ethereum/rpc"
+	"go.uber.org/zap"
+)
+
+func init() {
+	log.Initialize(config.LogOptions{ZapOpts: zap.NewDevelopmentConfig()})
+	crypto.Initialize(crypto.NewCrypto(true, nil))
+}
+
+func TestInternalError(t *testing.T) {
+	rejected := &FilterRejectedError{Filter: "cutoff", Err: NewJsonrpcError(ErrCodeOrderCutoff, "order cutoff")}
+	tests := []struct {
+		err    error
+		code   int
+		filter interface{}
+	}{
+		{errors.New("record not found"), ErrCodeInternal, nil},
+		{NewJsonrpcError(ErrCodeInvalidParams, "bad param"), ErrCodeInvalidParams, nil},
+		{rejected, ErrCodeOrderCutoff, "cutoff"},
+		{&FilterRejectedError{Filter: "base", Err: errors.New("length error")}, ErrCodeInvalidOrder, "base"},
+	}
+	for _, test := range tests {
+		e := internalError(test.err).(dataError)
+		if e.ErrorCode() != test.code {
+			t.Errorf("%s: expect code %d, got %d", test.err.Error(), test.code, e.ErrorCode())
+		}
+		data := e.ErrorData().(map[string]interface{})
+		if data["reason"] != errorReasons[test.code] || data["filter"] != test.filter {
+			t.Errorf("%s: unexpected data %v", test.err.Error(), data)
+		}
+	}
+	if internalError(nil) != nil {
+		t.Errorf("expect nil")
+	}
+	if res := submitOrderResult("0x1", rejected); res.Code != ErrCodeOrderCutoff || res.Filter != "cutoff" || res.Accepted {
+		t.Errorf("unexpected submit result %+v", res)
+	}
+}
+
+type RpcTestService struct{}
+
+func (s *RpcTestService) Reject(ctx context.Context) (res string, err error) {
+	defer rpcError(ctx, &err)
+	return "", &FilterRejectedError{Filter: "cutoff", Err: NewJsonrpcError(ErrCodeOrderCutoff, "order cutoff").With("owner", "0x1")}
+}
+
+// Cutoff fails with a plain error of the same message as Reject
+func (s *RpcTestService) Cutoff(ctx context.Context) (res string, err error) {
+	defer rpcError(ctx, &err)
+	return "", errors.New("order cutoff")
+}
+
+func (s *RpcTestService) Fail() (string, error) {
+	return "", errors.New("plain failure")
+}
+
+func (s *RpcTestService) RemoteAddr(ctx context.Context) (string, error) {
+	return remoteAddr(ctx), nil
+}
+
+func TestRpcHTTPHandler(t *testing.T) {
+	srv := rpc.NewServer()
+	if err := srv.RegisterName("test", &RpcTestService{}); err != nil {
+		t.Fatal(err)
+	}
+	handler := &rpcHTTPHandler{srv: srv}
+
+	call := func(method string) map[string]json.RawMessage {
+		body := `{"jsonrpc":"2.0","id":1,"method":"` + method + `","params":[]}`
+		r := httptest.NewRequest("POST", "/", bytes.NewBufferString(body))
+		r.Header.Set("content-type", "application/json")
+		r.RemoteAddr = "10.0.0.1:1234"
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, r)
+		if w.Code != http.StatusOK {
+			t.Fatalf("%s: status %d", method, w.Code)
+		}
+		res := make(map[string]json.RawMessage)
+		if err := json.Unmarshal(w.Body.Bytes(), &res); err != nil {
+			t.Fatalf("%s: %s", method, err.Error())
+		}
+		return res
+	}
+
+	var rpcErr struct {
+		Code    int                    `json:"code"`
+		Message string                 `json:"message"`
+		Data    map[string]interface{} `json:"data"`
+	}
+	json.Unmarshal(call("test_reject")["error"], &rpcErr)
+	if rpcErr.Code != ErrCodeOrderCutoff || rpcErr.Data["filter"] != "cutoff" || rpcErr.Data["owner"] != "0x1" || rpcErr.Data["reason"] != "order_cutoff" {
+		t.Errorf("unexpected error %+v", rpcErr)
+	}
+
+	rpcErr.Data = nil
+	json.Unmarshal(call("test_cutoff")["error"], &rpcErr)
+	if rpcErr.Code != ErrCodeInternal || rpcErr.Message != "order cutoff" || rpcErr.Data != nil {
+		t.Errorf("unexpected error %+v", rpcErr)
+	}
+
+	json.Unmarshal(call("test_fail")["error"], &rpcErr)
+	if rpcErr.Code != ErrCodeInternal || rpcErr.Message != "plain failure" || rpcErr.Data != nil {
+		t.Errorf("unexpected error %+v", rpcErr)
+	}
+
+	var addr string
+	json.Unmarshal(call("test_remoteAddr")["result"], &addr)
+	if addr != "10.0.0.1:1234" {
+		t.Errorf("expect remote address 10.0.0.1:1234, got %s", addr)
+	}
+}
+
+func TestKeptError(t *testing.T) {
+	conn := newRpcConn(rpc.NewJSONCodec(&httpReadWriteNopCloser{bytes.NewBuffer(nil), bytes.NewBuffer(nil)}), "10.0.0.1:1234", true)
+	defer conn.release()
+
+	first := NewJsonrpcError(ErrCodeOrderCutoff, "rejected").With("owner", "0x1")
+	second := NewJsonrpcError(ErrCodeRateLimited, "rejected").With("owner", "0x2")
+	kept1, kept2 := conn.keepError(first), conn.keepError(second)
+	if first.Error() != "rejected" {
+		t.Errorf("Error() of kept error changed:%s", first.Error())
+	}
+
+	// 相同message的错误按key各自取回
+	if e, ok := conn.takeError(kept2.Error()); !ok || e != second {
+		t.Errorf("expect second error, got %v", e)
+	}
+	if e, ok := conn.takeError(kept1.Error()); !ok || e != first {
+		t.Errorf("expect first error, got %v", e)
+	}
+	if _, ok := conn.takeError(kept1.Error()); ok {
+		t.Errorf("error should be taken only once")
+	}
+	if _, ok := conn.takeError("rejected"); ok {
+		t.Errorf("error without key should not be found")
+	}
+}
@@ -1,30 +1,213 @@
 package gateway
 
 import (
+	"context"
+	"encoding/json"
+	"github.com/Loopring/relay/config"
+	"github.com/Loopring/relay/dao"
 	"github.com/Loopring/relay/ethaccessor"
+	"github.com/Loopring/relay/eventemiter"
 	"github.com/ethereum/go-ethereum/common"
+	"github.com/patrickmn/go-cache"
+	"math/big"
+	"time"
 )
 
+// ethMethods are all methods EthForwarder forwards, JsonrpcOptions.EthMethods picks the public ones
+var ethMethods = []string{
+	"eth_getBalance",
+	"eth_sendRawTransaction",
+	"eth_getTransactionCount",
+	"eth_call",
+	"eth_estimateGas",
+	"eth_gasPrice",
+	"eth_getTransactionReceipt",
+	"eth_getTransactionByHash",
+	"eth_blockNumber",
+}
+
+// EthForwarder forwards eth namespace requests to the ethereum node,
+// read results are cached until the next block or EthCacheTtl seconds.
+// Raw transactions sent through it are recorded and tracked per owner.
 type EthForwarder struct {
-	Accessor ethaccessor.EthNodeAccessor
+	Accessor     ethaccessor.EthNodeAccessor
+	rds          dao.RdsService
+	allowed      map[string]bool
+	cache        *cache.Cache
+	blockWatcher *eventemitter.Watcher
+	dropTimeout  int64
+	checking     int32
+
+	// estimateGas 默认由节点估算，测试中可替换
+	estimateGas func(from, to common.Address, value *big.Int, callData []byte) (gas, gasPrice *big.Int, err error)
+}
+
+func NewEthForwarder(options *config.JsonrpcOptions, accessor ethaccessor.EthNodeAccessor, rds dao.RdsService) *EthForwarder {
+	e := &EthForwarder{Accessor: accessor, rds: rds}
+	e.estimateGas = e.Accessor.EstimateTransactionGas
+
+	e.dropTimeout = options.EthTxDropTimeout
+	if e.dropTimeout <= 0 {
+		e.dropTimeout = defaultTxDropTimeout
+	}
+
+	methods := options.EthMethods
+	if len(methods) == 0 {
+		methods = ethMethods
+	}
+	e.allowed = make(map[string]bool)
+	for _, m := range methods {
+		e.allowed[m] = true
+	}
+
+	if options.EthCacheTtl > 0 {
+		ttl := time.Duration(options.EthCacheTtl) * time.Second
+		e.cache = cache.New(ttl, 2*ttl)
+	}
+
+	return e
+}
+
+func (e *EthForwarder) start() {
+	e.blockWatcher = &eventemitter.Watcher{Concurrent: false, Handle: e.handleNewBlock}
+	eventemitter.On(eventemitter.Block_New, e.blockWatcher)
+}
+
+func (e *EthForwarder) stop() {
+	if e.blockWatcher != nil {
+		eventemitter.Un(eventemitter.Block_New, e.blockWatcher)
+	}
+}
+
+// 新块到来后缓存的结果都可能过期，pending交易的状态也可能变化
+func (e *EthForwarder) handleNewBlock(input eventemitter.EventData) error {
+	if e.cache != nil {
+		e.cache.Flush()
+	}
+	if e.rds != nil {
+		go e.checkPendingTransactions()
+	}
+	return nil
+}
+
+func (e *EthForwarder) GetBalance(ctx context.Context, address, blockNumber string) (result string, err error) {
+	defer rpcError(ctx, &err)
+	if err = e.checkAllowed("eth_getBalance"); err != nil {
+		return
+	}
+	return e.getBalance(address, blockNumber)
+}
+
+// getBalance 供gateway内部调用，不受eth_methods配置限制
+func (e *EthForwarder) getBalance(address, blockNumber string) (result string, err error) {
+	err = e.cachedCall(&result, "eth_getBalance", common.HexToAddress(address), blockNumber)
+	return
+}
+
+func (e *EthForwarder) SendRawTransaction(ctx context.Context, tx string) (result string, err error) {
+	defer rpcError(ctx, &err)
+	if err = e.checkAllowed("eth_sendRawTransaction"); err != nil {
+		return
+	}
+	if err = e.Accessor.RetryCall(2, &result, "eth_sendRawTransaction", tx); err != nil {
+		return
+	}
+	e.recordTransaction(result, tx)
+	return
+}
+
+func (e *EthForwarder) GetTransactionCount(ctx context.Context, address, blockNumber string) (result string, err error) {
+	defer rpcError(ctx, &err)
+	err = e.forward(&result, "eth_getTransactionCount", common.HexToAddress(address), blockNumber)
+	return
+}
+
+func (e *EthForwarder) Call(ctx context.Context, ethCall ethaccessor.CallArg, blockNumber string) (result string, err error) {
+	defer rpcError(ctx, &err)
+	err = e.forward(&result, "eth_call", ethCall, blockNumber)
+	return
+}
+
+// EstimateGas 参数原样转发，避免CallArg把未填的to、gas等字段补成零值
+func (e *EthForwarder) EstimateGas(ctx context.Context, ethCall json.RawMessage) (result string, err error) {
+	defer rpcError(ctx, &err)
+	err = e.forward(&result, "eth_estimateGas", ethCall)
+	return
+}
+
+func (e *EthForwarder) GasPrice(ctx context.Context) (result string, err error) {
+	defer rpcError(ctx, &err)
+	if err = e.checkAllowed("eth_gasPrice"); err != nil {
+		return
+	}
+	return e.gasPrice()
+}
+
+// gasPrice 供gateway内部调用，不受eth_methods配置限制
+func (e *EthForwarder) gasPrice() (result string, err error) {
+	err = e.cachedCall(&result, "eth_gasPrice")
+	return
 }
 
-func (e *EthForwarder) GetBalance(address, blockNumber string) (result string, err error) {
-	err = e.Accessor.RetryCall(2, &result, "eth_getBalance", common.HexToAddress(address), blockNumber)
+// GetTransactionReceipt returns the receipt as the node does, null if the tx is not mined
+func (e *EthForwarder) GetTransactionReceipt(ctx context.Context, txHash string) (result json.RawMessage, err error) {
+	defer rpcError(ctx, &err)
+	err = e.forward(&result, "eth_getTransactionReceipt", common.HexToHash(txHash))
 	return
 }
 
-func (e *EthForwarder) SendRawTransaction(tx string) (result string, err error) {
-	err = e.Accessor.RetryCall(2, &result, "eth_sendRawTransaction", tx)
+func (e *EthForwarder) GetTransactionByHash(ctx context.Context, txHash string) (result json.RawMessage, err error) {
+	defer rpcError(ctx, &err)
+	err = e.forward(&result, "eth_getTransactionByHash", common.HexToHash(txHash))
 	return
 }
 
-func (e *EthForwarder) GetTransactionCount(address, blockNumber string) (result string, err error) {
-	err = e.Accessor.RetryCall(2, &result, "eth_getTransactionCount", common.HexToAddress(address), blockNumber)
-	return result, nil
+func (e *EthForwarder) BlockNumber(ctx context.Context) (result string, err error) {
+	defer rpcError(ctx, &err)
+	err = e.forward(&result, "eth_blockNumber")
+	return
 }
 
-func (e *EthForwarder) Call(ethCall ethaccessor.CallArg, blockNumber string) (result string, err error) {
-	err = e.Accessor.RetryCall(2, &result, "eth_call", ethCall, blockNumber)
-	return result, nil
+func (e *EthForwarder) checkAllowed(method string) error {
+	if !e.allowed[method] {
+		return NewJsonrpcError(ErrCodeMethodNotFound, "the method %s does not exist/is not available", method).With("method", method)
+	}
+	return nil
+}
+
+// forward 转发客户端的请求，只允许配置的方法
+func (e *EthForwarder) forward(result interface{}, method string, args ...interface{}) error {
+	if err := e.checkAllowed(method); err != nil {
+		return err
+	}
+	return e.cachedCall(result, method, args...)
+}
+
+// cachedCall 读请求的结果在同一块内复用，pending状态随交易提交变化，不缓存
+func (e *EthForwarder) cachedCall(result interface{}, method string, args ...interface{}) error {
+	cacheable := e.cache != nil
+	for _, arg := range args {
+		if arg == "pending" {
+			cacheable = false
+		}
+	}
+	if !cacheable {
+		return internalError(e.Accessor.RetryCall(2, result, method, args...))
+	}
+
+	params, err := json.Marshal(args)
+	if err != nil {
+		return internalError(err)
+	}
+	key := method + string(params)
+	if raw, ok := e.cache.Get(key); ok {
+		return json.Unmarshal(raw.(json.RawMessage), result)
+	}
+
+	var raw json.RawMessage
+	if err := e.Accessor.RetryCall(2, &raw, method, args...); err != nil {
+		return internalError(err)
+	}
+	e.cache.Set(key, raw, cache.DefaultExpiration)
+	return json.Unmarshal(raw, result)
 }
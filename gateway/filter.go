@@ -0,0 +1,265 @@
+/*
+
+  Copyright 2017 Loopring Project Ltd (Loopring Foundation).
+
+  Licensed under the Apache License, Version 2.0 (the "License");
+  you may not use this file except in compliance with the License.
+  You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+  Unless required by applicable law or agreed to in writing, software
+  distributed under the License is distributed on an "AS IS" BASIS,
+  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+  See the License for the specific language governing permissions and
+  limitations under the License.
+
+*/
+
+package gateway
+
+import (
+	"fmt"
+	"github.com/Loopring/relay/config"
+	"github.com/Loopring/relay/market"
+	"github.com/Loopring/relay/market/util"
+	"github.com/Loopring/relay/marketcap"
+	"github.com/Loopring/relay/ordermanager"
+	"github.com/Loopring/relay/types"
+	"github.com/Loopring/relay/usermanager"
+	"github.com/ethereum/go-ethereum/common"
+	"math/big"
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+// Filter checks orders before they are saved and broadcast,
+// filter returns false and the reason if the order should be rejected,
+// or true and the reason if the order is accepted but flagged.
+// Filters are registered by name with RegisterFilter and enabled in relay.toml.
+type Filter interface {
+	Name() string
+	Filter(o *types.Order) (bool, error)
+}
+
+// FilterContext holds options and services which filters depend on
+type FilterContext struct {
+	Options        *config.GatewayFiltersOptions
+	OrderManager   ordermanager.OrderManager
+	AccountManager *market.AccountManager
+	UserManager    usermanager.UserManager
+	MarketCap      marketcap.MarketCapProvider
+}
+
+// FilterCreator creates a filter, parameters of filters not known by relay
+// are configured in gateway_filters.params.<name>
+type FilterCreator func(ctx *FilterContext) (Filter, error)
+
+var (
+	filterCreators   = make(map[string]FilterCreator)
+	filterCreatorMtx sync.RWMutex
+)
+
+// 过滤器模式，reject拒绝订单，flag接受订单并标记
+const (
+	filterModeReject = "reject"
+	filterModeFlag   = "flag"
+)
+
+// 未配置enabled时默认启用的过滤器及顺序
+var defaultFilters = []string{"base", signFilterName, "token", "cutoff", "expired"}
+
+// RegisterFilter registers a filter creator by name, it should be called in init
+func RegisterFilter(name string, creator FilterCreator) {
+	filterCreatorMtx.Lock()
+	defer filterCreatorMtx.Unlock()
+
+	if _, ok := filterCreators[name]; ok {
+		panic("gateway,filter " + name + " registered twice")
+	}
+	filterCreators[name] = creator
+}
+
+func init() {
+	RegisterFilter("base", newBaseFilter)
+	RegisterFilter(signFilterName, newSignFilter)
+	RegisterFilter("token", newTokenFilter)
+	RegisterFilter("cutoff", newCutoffFilter)
+	RegisterFilter("expired", newExpiredFilter)
+	RegisterFilter("balance", newBalanceFilter)
+	RegisterFilter("quota", newQuotaFilter)
+	RegisterFilter("price", newPriceFilter)
+}
+
+// newFilters creates enabled filters in configured order
+func newFilters(ctx *FilterContext) ([]Filter, error) {
+	names := ctx.Options.Enabled
+	if len(names) == 0 {
+		names = defaultFilters
+	}
+
+	filterCreatorMtx.RLock()
+	defer filterCreatorMtx.RUnlock()
+
+	filters := make([]Filter, 0, len(names))
+	for _, name := range names {
+		creator, ok := filterCreators[name]
+		if !ok {
+			return nil, fmt.Errorf("gateway,filter %s not registered", name)
+		}
+		f, err := creator(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("gateway,create filter %s error:%s", name, err.Error())
+		}
+		filters = append(filters, f)
+	}
+	return filters, nil
+}
+
+func newBaseFilter(ctx *FilterContext) (Filter, error) {
+	opts := ctx.Options.BaseFilter
+	if opts.MaxPrice <= 0 {
+		return nil, fmt.Errorf("max_price must be positive")
+	}
+	return &BaseFilter{MinLrcFee: big.NewInt(opts.MinLrcFee), MaxPrice: big.NewInt(opts.MaxPrice)}, nil
+}
+
+func newSignFilter(ctx *FilterContext) (Filter, error) {
+	return &SignFilter{}, nil
+}
+
+func newTokenFilter(ctx *FilterContext) (Filter, error) {
+	f := &TokenFilter{AllowTokens: make(map[common.Address]bool), DeniedTokens: make(map[common.Address]bool)}
+	for _, t := range ctx.Options.TokenFilter.AllowTokens {
+		addr, err := tokenAddress(t)
+		if err != nil {
+			return nil, err
+		}
+		f.AllowTokens[addr] = true
+	}
+	for _, t := range ctx.Options.TokenFilter.DeniedTokens {
+		addr, err := tokenAddress(t)
+		if err != nil {
+			return nil, err
+		}
+		f.DeniedTokens[addr] = true
+	}
+	return f, nil
+}
+
+func newCutoffFilter(ctx *FilterContext) (Filter, error) {
+	return &CutoffFilter{om: ctx.OrderManager}, nil
+}
+
+func newExpiredFilter(ctx *FilterContext) (Filter, error) {
+	return &ExpiredFilter{}, nil
+}
+
+func newBalanceFilter(ctx *FilterContext) (Filter, error) {
+	mode := ctx.Options.BalanceFilter.Mode
+	if mode == "" {
+		mode = filterModeReject
+	}
+	if err := checkFilterMode(mode); err != nil {
+		return nil, err
+	}
+	if ctx.AccountManager == nil {
+		return nil, fmt.Errorf("account manager required")
+	}
+	return &BalanceFilter{Mode: mode, om: ctx.OrderManager, accountManager: ctx.AccountManager}, nil
+}
+
+func newQuotaFilter(ctx *FilterContext) (Filter, error) {
+	opts := ctx.Options.QuotaFilter
+	return &QuotaFilter{MaxOpenOrders: opts.MaxOpenOrders, WhiteListMaxOpenOrders: opts.WhiteListMaxOpenOrders, om: ctx.OrderManager, um: ctx.UserManager}, nil
+}
+
+func newPriceFilter(ctx *FilterContext) (Filter, error) {
+	opts := ctx.Options.PriceFilter
+	mode := opts.Mode
+	if mode == "" {
+		mode = filterModeReject
+	}
+	if err := checkFilterMode(mode); err != nil {
+		return nil, err
+	}
+	if ctx.MarketCap == nil {
+		return nil, fmt.Errorf("market cap provider required")
+	}
+
+	f := &PriceFilter{Mode: mode, MaxDeviation: opts.MaxDeviation, MarketDeviations: make(map[string]float64), mc: ctx.MarketCap}
+	for mkt, v := range opts.MarketDeviations {
+		f.MarketDeviations[strings.ToUpper(mkt)] = v
+	}
+	return f, nil
+}
+
+func checkFilterMode(mode string) error {
+	if mode != filterModeReject && mode != filterModeFlag {
+		return fmt.Errorf("unsupported mode %s, should be %s or %s", mode, filterModeReject, filterModeFlag)
+	}
+	return nil
+}
+
+// applyFilterMode 查询出错等情况直接拒绝，错误码为flagCode时按mode拒绝或标记
+func applyFilterMode(mode string, valid bool, err error, flagCode int) (bool, error) {
+	if valid || mode != filterModeFlag {
+		return valid, err
+	}
+	if je, ok := err.(*JsonrpcError); ok && je.Code == flagCode {
+		return true, err
+	}
+	return false, err
+}
+
+// tokenAddress accepts token alias or address
+func tokenAddress(token string) (common.Address, error) {
+	if addr := util.AliasToAddress(token); addr != (common.Address{}) {
+		return addr, nil
+	}
+	if common.IsHexAddress(token) {
+		return common.HexToAddress(token), nil
+	}
+	return common.Address{}, fmt.Errorf("unsupported token %s", token)
+}
+
+// FilterStats is the count of orders accepted and rejected by a filter
+type FilterStats struct {
+	Name     string `json:"name"`
+	Accepted uint64 `json:"accepted"`
+	Rejected uint64 `json:"rejected"`
+	Flagged  uint64 `json:"flagged"`
+}
+
+type filterCounter struct {
+	accepted uint64
+	rejected uint64
+	flagged  uint64
+}
+
+func (c *filterCounter) count(valid bool, err error) {
+	if valid {
+		atomic.AddUint64(&c.accepted, 1)
+		if err != nil {
+			atomic.AddUint64(&c.flagged, 1)
+		}
+	} else {
+		atomic.AddUint64(&c.rejected, 1)
+	}
+}
+
+// GetFilterStats returns counts of rate limits and enabled filters in the order they run
+func GetFilterStats() []FilterStats {
+	stats := make([]FilterStats, 0, len(gateway.counterNames))
+	for _, name := range gateway.counterNames {
+		c := gateway.counters[name]
+		stats = append(stats, FilterStats{
+			Name:     name,
+			Accepted: atomic.LoadUint64(&c.accepted),
+			Rejected: atomic.LoadUint64(&c.rejected),
+			Flagged:  atomic.LoadUint64(&c.flagged),
+		})
+	}
+	return stats
+}
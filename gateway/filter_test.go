@@ -0,0 +1,97 @@
+/*
+
+  Copyright 2017 Loopring Project Ltd (Loopring Foundation).
+
+  Licensed under the Apache License, Version 2.0 (the "License");
+  you may not use this file except in compliance with the License.
+  You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+  Unless required by applicable law or agreed to in writing, software
+  distributed under the License is distributed on an "AS IS" BASIS,
+  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+  See the License for the specific language governing permissions and
+  limitations under the License.
+
+*/
+
+package gateway
+
+import (
+	"math"
+	"math/big"
+	"testing"
+
+	"github.com/Loopring/relay/types"
+	"github.com/ethereum/go-ethereum/common"
+)
+
+func TestPriceDeviation(t *testing.T) {
+	cases := []struct {
+		price, priceS, priceB *big.Rat
+		refPrice              string
+		deviation             float64
+	}{
+		// tokenS 2, tokenB 4 -> 每单位tokenB值2个tokenS
+		{big.NewRat(2, 1), big.NewRat(2, 1), big.NewRat(4, 1), "2", 0},
+		{big.NewRat(3, 1), big.NewRat(2, 1), big.NewRat(4, 1), "2", 50},
+		{big.NewRat(1, 1), big.NewRat(2, 1), big.NewRat(4, 1), "2", 50},
+		{big.NewRat(11, 10), big.NewRat(1, 1), big.NewRat(1, 1), "1", 10},
+		{big.NewRat(1, 200), big.NewRat(400, 1), big.NewRat(1, 1), "1/400", 100},
+	}
+	for i, c := range cases {
+		refPrice, deviation := priceDeviation(c.price, c.priceS, c.priceB)
+		if refPrice.RatString() != c.refPrice {
+			t.Errorf("case %d: refPrice %s, expected %s", i, refPrice.RatString(), c.refPrice)
+		}
+		if math.Abs(deviation-c.deviation) > 1e-9 {
+			t.Errorf("case %d: deviation %f, expected %f", i, deviation, c.deviation)
+		}
+	}
+}
+
+func TestApplyFilterMode(t *testing.T) {
+	flagErr := NewJsonrpcError(ErrCodePriceOutOfRange, "out of range")
+	otherErr := NewJsonrpcError(ErrCodeInternal, "internal")
+
+	if valid, err := applyFilterMode(filterModeReject, false, flagErr, ErrCodePriceOutOfRange); valid || err != flagErr {
+		t.Errorf("reject mode should reject, got %t %v", valid, err)
+	}
+	if valid, err := applyFilterMode(filterModeFlag, false, flagErr, ErrCodePriceOutOfRange); !valid || err != flagErr {
+		t.Errorf("flag mode should accept with error, got %t %v", valid, err)
+	}
+	if valid, _ := applyFilterMode(filterModeFlag, false, otherErr, ErrCodePriceOutOfRange); valid {
+		t.Errorf("flag mode should reject other errors")
+	}
+	if valid, err := applyFilterMode(filterModeFlag, true, nil, ErrCodePriceOutOfRange); !valid || err != nil {
+		t.Errorf("valid order should pass, got %t %v", valid, err)
+	}
+}
+
+// foreignError is an rpc.Error of a filter registered by other packages
+type foreignError struct{}
+
+func (e *foreignError) Error() string  { return "foreign rejection" }
+func (e *foreignError) ErrorCode() int { return -32099 }
+
+type foreignFilter struct{}
+
+func (f *foreignFilter) Name() string { return "foreign" }
+
+func (f *foreignFilter) Filter(o *types.Order) (bool, error) {
+	return false, &foreignError{}
+}
+
+func TestValidateOrderForeignError(t *testing.T) {
+	om := &testOrderManager{orders: make(map[common.Hash]*types.OrderState)}
+	defer setupTestGateway(om, &foreignFilter{})()
+
+	valid, verdicts := ValidateOrder(types.ToOrder(newTestOrderRequest(common.HexToAddress("0x10"), 1)))
+	if valid || len(verdicts) != 1 {
+		t.Fatalf("expect the order rejected by foreign filter, got %t %+v", valid, verdicts)
+	}
+	if v := verdicts[0]; v.Filter != "foreign" || v.Passed || v.Code != -32099 || v.Reason != "foreign rejection" {
+		t.Errorf("unexpected verdict %+v", v)
+	}
+}
@@ -19,100 +19,262 @@
 package gateway
 
 import (
-	"fmt"
 	"github.com/Loopring/relay/config"
 	"github.com/Loopring/relay/eventemiter"
+	"github.com/Loopring/relay/gateway/broadcast"
 	"github.com/Loopring/relay/log"
+	"github.com/Loopring/relay/market"
 	"github.com/Loopring/relay/market/util"
+	"github.com/Loopring/relay/marketcap"
 	"github.com/Loopring/relay/ordermanager"
 	"github.com/Loopring/relay/types"
+	"github.com/Loopring/relay/usermanager"
 	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/rpc"
 	"math/big"
+	"time"
 )
 
 type Gateway struct {
-	filters          []Filter
-	om               ordermanager.OrderManager
-	isBroadcast      bool
-	maxBroadcastTime int
-	ipfsPubService   IPFSPubService
+	filters           []Filter
+	counters          map[string]*filterCounter
+	counterNames      []string
+	rateLimiter       *orderRateLimiter
+	om                ordermanager.OrderManager
+	isBroadcast       bool
+	maxBroadcastTime  int
+	broadcastInterval int64
+	rebroadcaster     *rebroadcaster
+	broadcaster       broadcast.Broadcaster
+	broadcastTopics   []string
+	marketTopic       string
+	relayAccount      common.Address
+	signEnvelope      bool
+	requireEnvelope   bool
+	envelopeMaxAge    int64
+	peers             *peerScorer
+	syncer            *orderBookSyncer
 }
 
 var gateway Gateway
 
-type Filter interface {
-	filter(o *types.Order) (bool, error)
+// FilterRejectedError records which filter rejected the order
+type FilterRejectedError struct {
+	Filter string
+	Err    error
 }
 
-func Initialize(filterOptions *config.GatewayFiltersOptions, options *config.GateWayOptions, ipfsOptions *config.IpfsOptions, om ordermanager.OrderManager) {
+func (e *FilterRejectedError) Error() string {
+	return e.Err.Error()
+}
+
+func (e *FilterRejectedError) ErrorCode() int {
+	if je, ok := e.Err.(*JsonrpcError); ok {
+		return je.Code
+	}
+	return ErrCodeInvalidOrder
+}
+
+func (e *FilterRejectedError) ErrorData() interface{} {
+	data := map[string]interface{}{"reason": errorReasons[e.ErrorCode()]}
+	if je, ok := e.Err.(*JsonrpcError); ok {
+		for k, v := range je.Data {
+			data[k] = v
+		}
+	}
+	data["filter"] = e.Filter
+	return data
+}
+
+func Initialize(filterOptions *config.GatewayFiltersOptions, options *config.GateWayOptions, ipfsOptions *config.IpfsOptions, om ordermanager.OrderManager, accountManager *market.AccountManager, um usermanager.UserManager, mc marketcap.MarketCapProvider) {
 	// add gateway watcher
 	gatewayWatcher := &eventemitter.Watcher{Concurrent: false, Handle: HandleOrder}
 	eventemitter.On(eventemitter.Gateway, gatewayWatcher)
 
-	gateway = Gateway{filters: make([]Filter, 0), om: om, isBroadcast: options.IsBroadcast, maxBroadcastTime: options.MaxBroadcastTime}
-	gateway.ipfsPubService = NewIPFSPubService(ipfsOptions)
+	gateway = Gateway{filters: make([]Filter, 0), counters: make(map[string]*filterCounter), om: om, isBroadcast: options.IsBroadcast, maxBroadcastTime: options.MaxBroadcastTime, broadcastInterval: options.BroadcastInterval}
+	broadcaster, err := broadcast.NewBroadcaster(ipfsOptions)
+	if err != nil {
+		log.Fatalf("gateway,init broadcaster error:%s", err.Error())
+	}
+	gateway.broadcaster = broadcaster
+	gateway.broadcastTopics = ipfsOptions.BroadcastTopics
+	gateway.marketTopic = ipfsOptions.MarketTopic
+	if ipfsOptions.RelayAccount != "" {
+		gateway.relayAccount = common.HexToAddress(ipfsOptions.RelayAccount)
+		gateway.signEnvelope = true
+	}
+	gateway.requireEnvelope = ipfsOptions.RequireEnvelope
+	gateway.envelopeMaxAge = ipfsOptions.EnvelopeMaxAge
+	if gateway.envelopeMaxAge <= 0 {
+		gateway.envelopeMaxAge = defaultEnvelopeMaxAge
+	}
+	gateway.peers = newPeerScorer(ipfsOptions)
+	if ipfsOptions.SyncTopic != "" {
+		gateway.syncer = newOrderBookSyncer(ipfsOptions)
+	}
 
-	// new base filter
-	baseFilter := &BaseFilter{MinLrcFee: big.NewInt(filterOptions.BaseFilter.MinLrcFee), MaxPrice: big.NewInt(filterOptions.BaseFilter.MaxPrice)}
+	gateway.rateLimiter = newOrderRateLimiter(options, um)
+	gateway.addCounter(ownerRateLimitName)
+	gateway.addCounter(ipRateLimitName)
 
-	// new token filter
-	tokenFilter := &TokenFilter{}
+	filters, err := newFilters(&FilterContext{Options: filterOptions, OrderManager: om, AccountManager: accountManager, UserManager: um, MarketCap: mc})
+	if err != nil {
+		log.Fatalf("gateway,init filters error:%s", err.Error())
+	}
+	gateway.filters = filters
+	for _, f := range filters {
+		gateway.addCounter(f.Name())
+	}
+}
 
-	// new sign filter
-	signFilter := &SignFilter{}
+func (g *Gateway) addCounter(name string) {
+	if _, ok := g.counters[name]; !ok {
+		g.counters[name] = &filterCounter{}
+		g.counterNames = append(g.counterNames, name)
+	}
+}
 
-	// new cutoff filter
-	cutoffFilter := &CutoffFilter{om: om}
+// orderSource is where an order handled by gateway comes from
+type orderSource int
 
-	gateway.filters = append(gateway.filters, baseFilter)
-	gateway.filters = append(gateway.filters, signFilter)
-	gateway.filters = append(gateway.filters, tokenFilter)
-	gateway.filters = append(gateway.filters, cutoffFilter)
-}
+const (
+	orderFromClient orderSource = iota // submitted through json-rpc
+	orderFromPeer                      // broadcast by other relays
+	orderFromChain                     // extracted from ethereum
+	orderFromSync                      // requested from peers during order book sync
+)
 
 func HandleOrder(input eventemitter.EventData) error {
-	var (
-		state *types.OrderState
-		err   error
-	)
+	return handleOrder(input.(*types.Order), orderFromChain, "")
+}
+
+// handleOrder checks rate limits and filters for new orders, saves and broadcasts them,
+// remoteAddr is empty for orders not submitted through json-rpc
+func handleOrder(order *types.Order, source orderSource, remoteAddr string) error {
+	_, err := processOrder(order, source, remoteAddr)
+	return err
+}
+
+// processOrder is handleOrder reporting whether the order is new to this relay,
+// orders from sync are requested by relay itself and not rate limited
+func processOrder(order *types.Order, source orderSource, remoteAddr string) (isNew bool, err error) {
+	limited := source != orderFromSync
+	var state *types.OrderState
 
-	order := input.(*types.Order)
 	order.Hash = order.GenerateHash()
 
 	var broadcastTime int
 
 	//TODO(xiaolu) 这里需要测试一下，超时error和查询数据为空的error，处理方式不应该一样
 	if state, err = gateway.om.GetOrderByHash(order.Hash); err != nil && err.Error() == "record not found" {
-		generatePrice(order)
+		if limited {
+			if rejectErr := checkRateLimit(ipRateLimitName, gateway.rateLimiter.checkIp(order, remoteAddr), order, remoteAddr); rejectErr != nil {
+				return false, rejectErr
+			}
+		}
+
+		if err = generatePrice(order); err != nil {
+			log.Errorf("gateway,generate order %s price error:%s", order.Hash.Hex(), err.Error())
+			return false, NewJsonrpcError(ErrCodeUnsupportedToken, "gateway,generate order %s price error:%s", order.Hash.Hex(), err.Error()).With("orderHash", order.Hash.Hex())
+		}
+		ownerChecked := false
 		for _, v := range gateway.filters {
-			valid, err := v.filter(order)
+			valid, err := v.Filter(order)
+			gateway.counters[v.Name()].count(valid, err)
 			if !valid {
-				log.Errorf(err.Error())
-				return err
+				log.Errorf("gateway,filter %s reject order %s of owner %s:%s", v.Name(), order.Hash.Hex(), order.Owner.Hex(), err.Error())
+				return false, &FilterRejectedError{Filter: v.Name(), Err: err}
+			}
+			if err != nil {
+				log.Warnf("gateway,filter %s flag order %s of owner %s:%s", v.Name(), order.Hash.Hex(), order.Owner.Hex(), err.Error())
+			}
+			// 签名验证通过后才扣除owner的限流额度
+			if v.Name() == signFilterName && limited {
+				if rejectErr := checkRateLimit(ownerRateLimitName, gateway.rateLimiter.checkOwner(order), order, remoteAddr); rejectErr != nil {
+					return false, rejectErr
+				}
+				ownerChecked = true
+			}
+		}
+		if !ownerChecked && limited {
+			if rejectErr := checkRateLimit(ownerRateLimitName, gateway.rateLimiter.checkOwner(order), order, remoteAddr); rejectErr != nil {
+				return false, rejectErr
 			}
 		}
 		state = &types.OrderState{}
 		state.RawOrder = *order
+		state.IsLocal = source == orderFromClient
 		broadcastTime = 0
+		isNew = true
 		eventemitter.Emit(eventemitter.OrderManagerGatewayNewOrder, state)
+	} else if err != nil {
+		log.Errorf("gateway,get order %s error:%s", order.Hash.Hex(), err.Error())
+		return false, internalError(err)
 	} else {
 		broadcastTime = state.BroadcastTime
 		log.Infof("gateway,order %s exist,will not insert again", order.Hash.Hex())
 	}
 
-	if gateway.isBroadcast && broadcastTime < gateway.maxBroadcastTime {
+	// 同步的订单来自peer的订单簿，不再广播
+	if gateway.isBroadcast && source != orderFromSync && broadcastTime < gateway.maxBroadcastTime {
 		//broadcast
-		log.Infof(">>>>>>> broad to ipfs order : " + state.RawOrder.Hash.Hex())
-		pubErr := gateway.ipfsPubService.PublishOrder(state.RawOrder)
+		log.Infof(">>>>>>> broadcast order : " + state.RawOrder.Hash.Hex())
+		pubErr := gateway.publishOrder(state.RawOrder)
 		if pubErr != nil {
-			log.Errorf("gateway,publish order %s failed", state.RawOrder.Hash.String())
+			log.Errorf("gateway,publish order %s failed:%s", state.RawOrder.Hash.String(), pubErr.Error())
 		} else {
 			if err = gateway.om.UpdateBroadcastTimeByHash(state.RawOrder.Hash, state.BroadcastTime+1); nil != err {
-				return err
+				return isNew, err
 			}
 		}
 	}
-	return nil
+	return isNew, nil
+}
+
+// checkRateLimit counts the result of rate limit named limit, and returns the reject error if not allowed
+func checkRateLimit(limit string, allowed bool, order *types.Order, remoteAddr string) error {
+	gateway.counters[limit].count(allowed, nil)
+	if allowed {
+		return nil
+	}
+	log.Errorf("gateway,%s reject order %s of owner %s from %s", limit, order.Hash.Hex(), order.Owner.Hex(), remoteAddr)
+	rejectErr := NewJsonrpcError(ErrCodeRateLimited, "gateway,too many orders of owner %s", order.Owner.Hex()).With("orderHash", order.Hash.Hex()).With("owner", order.Owner.Hex())
+	return &FilterRejectedError{Filter: limit, Err: rejectErr}
+}
+
+// FilterVerdict is the result of a filter for an order
+type FilterVerdict struct {
+	Filter  string `json:"filter"`
+	Passed  bool   `json:"passed"`
+	Flagged bool   `json:"flagged,omitempty"`
+	Code    int    `json:"code,omitempty"`
+	Reason  string `json:"reason,omitempty"`
+}
+
+// ValidateOrder runs order through hash generation, generatePrice and all filters
+// without counting, storing or broadcasting it. Unlike handleOrder it doesn't stop
+// at the first rejection, verdicts are in the order filters run.
+func ValidateOrder(order *types.Order) (valid bool, verdicts []FilterVerdict) {
+	order.Hash = order.GenerateHash()
+	verdicts = make([]FilterVerdict, 0, len(gateway.filters)+1)
+
+	if err := generatePrice(order); err != nil {
+		verdicts = append(verdicts, FilterVerdict{Filter: "price_generation", Code: ErrCodeUnsupportedToken, Reason: err.Error()})
+		return false, verdicts
+	}
+
+	valid = true
+	for _, v := range gateway.filters {
+		passed, err := v.Filter(order)
+		verdict := FilterVerdict{Filter: v.Name(), Passed: passed, Flagged: passed && err != nil}
+		if err != nil {
+			verdict.Code = internalError(err).(rpc.Error).ErrorCode()
+			verdict.Reason = err.Error()
+		}
+		valid = valid && passed
+		verdicts = append(verdicts, verdict)
+	}
+	return valid, verdicts
 }
 
 func generatePrice(order *types.Order) error {
@@ -139,46 +301,63 @@ type BaseFilter struct {
 	MaxPrice  *big.Int
 }
 
-func (f *BaseFilter) filter(o *types.Order) (bool, error) {
+func (f *BaseFilter) Name() string {
+	return "base"
+}
+
+func (f *BaseFilter) Filter(o *types.Order) (bool, error) {
 	const (
 		addrLength = 20
 		hashLength = 32
 	)
 
 	if len(o.Hash) != hashLength {
-		return false, fmt.Errorf("gateway,base filter,order %s length error", o.Hash.Hex())
+		return false, NewJsonrpcError(ErrCodeInvalidOrder, "gateway,base filter,order %s length error", o.Hash.Hex()).With("orderHash", o.Hash.Hex())
 	}
 	if len(o.TokenB) != addrLength {
-		return false, fmt.Errorf("gateway,base filter,order %s tokenB %s address length error", o.Hash.Hex(), o.TokenB.Hex())
+		return false, NewJsonrpcError(ErrCodeInvalidOrder, "gateway,base filter,order %s tokenB %s address length error", o.Hash.Hex(), o.TokenB.Hex()).With("orderHash", o.Hash.Hex())
 	}
 	if len(o.TokenS) != addrLength {
-		return false, fmt.Errorf("gateway,base filter,order %s tokenS %s address length error", o.Hash.Hex(), o.TokenS.Hex())
+		return false, NewJsonrpcError(ErrCodeInvalidOrder, "gateway,base filter,order %s tokenS %s address length error", o.Hash.Hex(), o.TokenS.Hex()).With("orderHash", o.Hash.Hex())
 	}
 	if o.TokenB == o.TokenS {
-		return false, fmt.Errorf("gateway,base filter,order %s tokenB == tokenS", o.Hash.Hex())
+		return false, NewJsonrpcError(ErrCodeInvalidOrder, "gateway,base filter,order %s tokenB == tokenS", o.Hash.Hex()).With("orderHash", o.Hash.Hex())
 	}
 	if len(o.Owner) != addrLength {
-		return false, fmt.Errorf("gateway,base filter,order %s owner %s address length error", o.Hash.Hex(), o.Owner.Hex())
+		return false, NewJsonrpcError(ErrCodeInvalidOrder, "gateway,base filter,order %s owner %s address length error", o.Hash.Hex(), o.Owner.Hex()).With("orderHash", o.Hash.Hex())
 	}
 	if len(o.Protocol) != addrLength {
-		return false, fmt.Errorf("gateway,base filter,order %s protocol %s address length error", o.Hash.Hex(), o.Owner.Hex())
+		return false, NewJsonrpcError(ErrCodeInvalidOrder, "gateway,base filter,order %s protocol %s address length error", o.Hash.Hex(), o.Owner.Hex()).With("orderHash", o.Hash.Hex())
 	}
 	if o.Price.Cmp(new(big.Rat).SetFrac(f.MaxPrice, big.NewInt(1))) > 0 || o.Price.Cmp(new(big.Rat).SetFrac(big.NewInt(1), f.MaxPrice)) < 0 {
-		return false, fmt.Errorf("dao order convert down,price out of range")
+		return false, NewJsonrpcError(ErrCodePriceOutOfRange, "gateway,base filter,order %s price out of range", o.Hash.Hex()).
+			With("orderHash", o.Hash.Hex()).
+			With("price", o.Price.FloatString(10)).
+			With("maxPrice", f.MaxPrice.String())
 	}
 	return true, nil
 }
 
+// 订单owner的限流在该过滤器之后检查
+const signFilterName = "sign"
+
 type SignFilter struct {
 }
 
-func (f *SignFilter) filter(o *types.Order) (bool, error) {
+func (f *SignFilter) Name() string {
+	return signFilterName
+}
+
+func (f *SignFilter) Filter(o *types.Order) (bool, error) {
 	o.Hash = o.GenerateHash()
 
 	if addr, err := o.SignerAddress(); nil != err {
-		return false, err
+		return false, NewJsonrpcError(ErrCodeInvalidSignature, "gateway,sign filter,order %s signature error:%s", o.Hash.Hex(), err.Error()).With("orderHash", o.Hash.Hex())
 	} else if addr != o.Owner {
-		return false, fmt.Errorf("gateway,sign filter,o.Owner %s and signeraddress %s are not match", o.Owner.Hex(), addr.Hex())
+		return false, NewJsonrpcError(ErrCodeInvalidSignature, "gateway,sign filter,o.Owner %s and signeraddress %s are not match", o.Owner.Hex(), addr.Hex()).
+			With("orderHash", o.Hash.Hex()).
+			With("owner", o.Owner.Hex()).
+			With("signer", addr.Hex())
 	}
 
 	return true, nil
@@ -189,7 +368,11 @@ type TokenFilter struct {
 	DeniedTokens map[common.Address]bool
 }
 
-func (f *TokenFilter) filter(o *types.Order) (bool, error) {
+func (f *TokenFilter) Name() string {
+	return "token"
+}
+
+func (f *TokenFilter) Filter(o *types.Order) (bool, error) {
 	supportTokenS := false
 	supportTokenB := false
 	for _, v := range util.AllTokens {
@@ -201,11 +384,18 @@ func (f *TokenFilter) filter(o *types.Order) (bool, error) {
 		}
 	}
 
+	if f.DeniedTokens[o.TokenS] || (len(f.AllowTokens) > 0 && !f.AllowTokens[o.TokenS]) {
+		supportTokenS = false
+	}
+	if f.DeniedTokens[o.TokenB] || (len(f.AllowTokens) > 0 && !f.AllowTokens[o.TokenB]) {
+		supportTokenB = false
+	}
+
 	if !supportTokenS {
-		return false, fmt.Errorf("gateway,token filter,tokenS:%s do not supported", o.TokenS.Hex())
+		return false, NewJsonrpcError(ErrCodeUnsupportedToken, "gateway,token filter,tokenS:%s do not supported", o.TokenS.Hex()).With("orderHash", o.Hash.Hex()).With("token", o.TokenS.Hex())
 	}
 	if !supportTokenB {
-		return false, fmt.Errorf("gateway,token filter,tokenB:%s do not supported", o.TokenB.Hex())
+		return false, NewJsonrpcError(ErrCodeUnsupportedToken, "gateway,token filter,tokenB:%s do not supported", o.TokenB.Hex()).With("orderHash", o.Hash.Hex()).With("token", o.TokenB.Hex())
 	}
 
 	return true, nil
@@ -215,11 +405,206 @@ type CutoffFilter struct {
 	om ordermanager.OrderManager
 }
 
+func (f *CutoffFilter) Name() string {
+	return "cutoff"
+}
+
 // 如果订单接收在cutoff(cancel)事件之后，则该订单直接过滤
-func (f *CutoffFilter) filter(o *types.Order) (bool, error) {
+func (f *CutoffFilter) Filter(o *types.Order) (bool, error) {
 	if f.om.IsOrderCutoff(o.Protocol, o.Owner, o.Timestamp) {
-		return false, fmt.Errorf("gateway,cutoff filter order:%s should be cutoff", o.Owner.Hex())
+		return false, NewJsonrpcError(ErrCodeOrderCutoff, "gateway,cutoff filter order:%s should be cutoff", o.Owner.Hex()).With("orderHash", o.Hash.Hex()).With("owner", o.Owner.Hex())
 	}
 
 	return true, nil
 }
+
+type ExpiredFilter struct {
+}
+
+func (f *ExpiredFilter) Name() string {
+	return "expired"
+}
+
+// 订单有效期为[timestamp, timestamp+ttl)，已过期的订单直接过滤
+func (f *ExpiredFilter) Filter(o *types.Order) (bool, error) {
+	expireTime := new(big.Int).Add(o.Timestamp, o.Ttl)
+	if expireTime.Cmp(big.NewInt(time.Now().Unix())) <= 0 {
+		return false, NewJsonrpcError(ErrCodeOrderExpired, "gateway,expired filter,order %s expired at %s", o.Hash.Hex(), expireTime.String()).With("orderHash", o.Hash.Hex()).With("expireTime", expireTime.Int64())
+	}
+
+	return true, nil
+}
+
+// BalanceFilter checks that balance and allowance of owner can back the new order
+// and its lrc fee on top of the open orders, Mode decides whether insufficient
+// orders are rejected or accepted and flagged.
+type BalanceFilter struct {
+	Mode           string
+	om             ordermanager.OrderManager
+	accountManager *market.AccountManager
+}
+
+func (f *BalanceFilter) Name() string {
+	return "balance"
+}
+
+func (f *BalanceFilter) Filter(o *types.Order) (bool, error) {
+	statusSet := []types.OrderStatus{types.ORDER_NEW, types.ORDER_PARTIAL}
+	lrcAddress := util.AliasToAddress("LRC")
+
+	lrcFee := big.NewInt(0)
+	if o.LrcFee != nil {
+		lrcFee.Set(o.LrcFee)
+	}
+	frozenLrcFee, err := f.om.GetFrozenLRCFee(o.Owner, statusSet)
+	if err != nil {
+		return false, internalError(err)
+	}
+
+	required := new(big.Int).Set(o.AmountS)
+	if o.TokenS == lrcAddress {
+		required.Add(required, lrcFee)
+		required.Add(required, frozenLrcFee)
+	}
+	if ok, err := f.checkToken(o, o.TokenS, required); !ok || err != nil {
+		return applyFilterMode(f.Mode, ok, err, ErrCodeInsufficientBalance)
+	}
+
+	if o.TokenS != lrcAddress && lrcFee.Sign() > 0 {
+		required = new(big.Int).Add(lrcFee, frozenLrcFee)
+		ok, err := f.checkToken(o, lrcAddress, required)
+		return applyFilterMode(f.Mode, ok, err, ErrCodeInsufficientBalance)
+	}
+
+	return true, nil
+}
+
+// checkToken checks min(balance, allowance) of token covers the amount frozen by open orders plus required
+func (f *BalanceFilter) checkToken(o *types.Order, token common.Address, required *big.Int) (bool, error) {
+	balance, allowance, err := f.accountManager.GetBalanceByTokenAddress(o.Owner, token)
+	if err != nil {
+		return false, internalError(err)
+	}
+	frozen, err := f.om.GetFrozenAmount(o.Owner, token, []types.OrderStatus{types.ORDER_NEW, types.ORDER_PARTIAL})
+	if err != nil {
+		return false, internalError(err)
+	}
+
+	available := big.NewInt(0)
+	if balance != nil && allowance != nil {
+		available.Set(balance)
+		if allowance.Cmp(available) < 0 {
+			available.Set(allowance)
+		}
+	}
+
+	total := new(big.Int).Add(frozen, required)
+	if total.Cmp(available) > 0 {
+		return false, NewJsonrpcError(ErrCodeInsufficientBalance, "gateway,balance filter,order %s owner %s token %s insufficient, required:%s available:%s", o.Hash.Hex(), o.Owner.Hex(), token.Hex(), total.String(), available.String()).
+			With("orderHash", o.Hash.Hex()).
+			With("owner", o.Owner.Hex()).
+			With("token", token.Hex()).
+			With("required", total.String()).
+			With("available", available.String())
+	}
+	return true, nil
+}
+
+// QuotaFilter caps the NEW/PARTIAL orders of an owner in a market,
+// owners in white list use WhiteListMaxOpenOrders.
+type QuotaFilter struct {
+	MaxOpenOrders          int
+	WhiteListMaxOpenOrders int
+	om                     ordermanager.OrderManager
+	um                     usermanager.UserManager
+}
+
+func (f *QuotaFilter) Name() string {
+	return "quota"
+}
+
+func (f *QuotaFilter) Filter(o *types.Order) (bool, error) {
+	max := f.MaxOpenOrders
+	if f.um != nil && f.um.InWhiteList(o.Owner) {
+		max = f.WhiteListMaxOpenOrders
+	}
+	if max <= 0 {
+		return true, nil
+	}
+
+	mkt, err := util.WrapMarketByAddress(o.TokenB.Hex(), o.TokenS.Hex())
+	if err != nil {
+		return false, NewJsonrpcError(ErrCodeUnsupportedMarket, "gateway,quota filter,order %s market unsupported", o.Hash.Hex()).With("orderHash", o.Hash.Hex())
+	}
+	count, err := f.om.GetOpenOrderCount(o.Owner, mkt)
+	if err != nil {
+		return false, internalError(err)
+	}
+	if count >= max {
+		return false, NewJsonrpcError(ErrCodeTooManyOpenOrders, "gateway,quota filter,owner %s has %d open orders in market %s", o.Owner.Hex(), count, mkt).
+			With("orderHash", o.Hash.Hex()).
+			With("owner", o.Owner.Hex()).
+			With("market", mkt).
+			With("maxOpenOrders", max)
+	}
+	return true, nil
+}
+
+// PriceFilter compares order price with the reference price from market cap provider,
+// orders deviate more than MaxDeviation percent are rejected or flagged according to Mode.
+type PriceFilter struct {
+	Mode             string
+	MaxDeviation     float64
+	MarketDeviations map[string]float64
+	mc               marketcap.MarketCapProvider
+}
+
+func (f *PriceFilter) Name() string {
+	return "price"
+}
+
+func (f *PriceFilter) Filter(o *types.Order) (bool, error) {
+	mkt, err := util.WrapMarketByAddress(o.TokenB.Hex(), o.TokenS.Hex())
+	if err != nil {
+		return false, NewJsonrpcError(ErrCodeUnsupportedMarket, "gateway,price filter,order %s market unsupported", o.Hash.Hex()).With("orderHash", o.Hash.Hex())
+	}
+	maxDeviation := f.MaxDeviation
+	if v, ok := f.MarketDeviations[mkt]; ok {
+		maxDeviation = v
+	}
+	if maxDeviation <= 0 {
+		return true, nil
+	}
+
+	// 无法获取参考价格时不做判断
+	priceS, errS := f.mc.GetMarketCap(o.TokenS)
+	priceB, errB := f.mc.GetMarketCap(o.TokenB)
+	if errS != nil || errB != nil || priceS.Sign() <= 0 || priceB.Sign() <= 0 {
+		log.Debugf("gateway,price filter,reference price of market %s not found", mkt)
+		return true, nil
+	}
+
+	refPrice, deviationFloat := priceDeviation(o.Price, priceS, priceB)
+	if deviationFloat <= maxDeviation {
+		return true, nil
+	}
+
+	err = NewJsonrpcError(ErrCodePriceOutOfRange, "gateway,price filter,order %s price %s deviates %.2f%% from reference price %s", o.Hash.Hex(), o.Price.FloatString(10), deviationFloat, refPrice.FloatString(10)).
+		With("orderHash", o.Hash.Hex()).
+		With("market", mkt).
+		With("price", o.Price.FloatString(10)).
+		With("referencePrice", refPrice.FloatString(10)).
+		With("deviation", deviationFloat).
+		With("maxDeviation", maxDeviation)
+	return applyFilterMode(f.Mode, false, err, ErrCodePriceOutOfRange)
+}
+
+// priceDeviation returns the reference price and the percent deviation of price from it,
+// order.Price与参考价格均为每单位tokenB对应的tokenS数量
+func priceDeviation(price, priceS, priceB *big.Rat) (*big.Rat, float64) {
+	refPrice := new(big.Rat).Quo(priceB, priceS)
+	deviation := new(big.Rat).Quo(new(big.Rat).Sub(price, refPrice), refPrice)
+	deviation.Abs(deviation).Mul(deviation, big.NewRat(100, 1))
+	deviationFloat, _ := deviation.Float64()
+	return refPrice, deviationFloat
+}
@@ -0,0 +1,102 @@
+/*
+
+  Copyright 2017 Loopring Project Ltd (Loopring Foundation).
+
+  Licensed under the Apache License, Version 2.0 (the "License");
+  you may not use this file except in compliance with the License.
+  You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+  Unless required by applicable law or agreed to in writing, software
+  distributed under the License is distributed on an "AS IS" BASIS,
+  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+  See the License for the specific language governing permissions and
+  limitations under the License.
+
+*/
+
+package gateway
+
+import (
+	"fmt"
+	"github.com/Loopring/relay/config"
+	"github.com/ethereum/go-ethereum/rpc"
+	"github.com/rs/cors"
+	"net"
+	"net/http"
+	"strings"
+)
+
+// 默认请求体上限
+const defaultMaxBodySize = 1024 * 128
+
+// newHTTPHandler wrap rpc server with virtual host check, cors and body size limit
+func newHTTPHandler(options *config.JsonrpcOptions, srv *rpc.Server) http.Handler {
+	limit := options.MaxBodySize
+	if limit <= 0 {
+		limit = defaultMaxBodySize
+	}
+
+	var handler http.Handler = &bodyLimitHandler{limit: limit, next: &rpcHTTPHandler{srv: srv}}
+	if len(options.AllowedOrigins) > 0 {
+		handler = cors.New(cors.Options{
+			AllowedOrigins: options.AllowedOrigins,
+			AllowedMethods: []string{"POST", "GET"},
+			MaxAge:         600,
+			AllowedHeaders: []string{"*"},
+		}).Handler(handler)
+	}
+	return newVirtualHostHandler(options.VirtualHosts, handler)
+}
+
+type bodyLimitHandler struct {
+	limit int64
+	next  http.Handler
+}
+
+func (h *bodyLimitHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.ContentLength > h.limit {
+		http.Error(w, fmt.Sprintf("content length too large (%d>%d)", r.ContentLength, h.limit), http.StatusRequestEntityTooLarge)
+		return
+	}
+	r.Body = http.MaxBytesReader(w, r.Body, h.limit)
+	h.next.ServeHTTP(w, r)
+}
+
+// virtualHostHandler rejects requests whose Host header is not in vhosts,
+// it prevents DNS rebinding attacks. requests to an ip address are always allowed,
+// empty vhosts allows localhost only and "*" allows any host.
+type virtualHostHandler struct {
+	vhosts map[string]bool
+	next   http.Handler
+}
+
+func newVirtualHostHandler(vhosts []string, next http.Handler) http.Handler {
+	h := &virtualHostHandler{vhosts: make(map[string]bool), next: next}
+	for _, v := range vhosts {
+		if v != "" {
+			h.vhosts[strings.ToLower(v)] = true
+		}
+	}
+	if len(h.vhosts) == 0 {
+		h.vhosts["localhost"] = true
+	}
+	return h
+}
+
+func (h *virtualHostHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Host == "" || h.vhosts["*"] {
+		h.next.ServeHTTP(w, r)
+		return
+	}
+	host, _, err := net.SplitHostPort(r.Host)
+	if err != nil {
+		host = r.Host
+	}
+	if net.ParseIP(host) != nil || h.vhosts[strings.ToLower(host)] {
+		h.next.ServeHTTP(w, r)
+		return
+	}
+	http.Error(w, "invalid host specified", http.StatusForbidden)
+}
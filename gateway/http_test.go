@@ -0,0 +1,52 @@
+/*
+
+  Copyright 2017 Loopring Project Ltd (Loopring Foundation).
+
+  Licensed under the Apache License, Version 2.0 (the "License");
+  you may not use this file except in compliance with the License.
+  You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+  Unless required by applicable law or agreed to in writing, software
+  distributed under the License is distributed on an "AS IS" BASIS,
+  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+  See the License for the specific language governing permissions and
+  limitations under the License.
+
+*/
+
+package gateway
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestVirtualHostHandler(t *testing.T) {
+	ok := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})
+	cases := []struct {
+		vhosts []string
+		host   string
+		status int
+	}{
+		// 未配置时只允许localhost
+		{nil, "localhost:8083", http.StatusOK},
+		{nil, "relay.example.com", http.StatusForbidden},
+		{[]string{""}, "relay.example.com", http.StatusForbidden},
+		{nil, "127.0.0.1:8083", http.StatusOK},
+		{[]string{"relay.example.com"}, "Relay.Example.com:8083", http.StatusOK},
+		{[]string{"relay.example.com"}, "localhost", http.StatusForbidden},
+		{[]string{"*"}, "evil.example.com", http.StatusOK},
+	}
+	for _, c := range cases {
+		r := httptest.NewRequest("POST", "/rpc", nil)
+		r.Host = c.host
+		w := httptest.NewRecorder()
+		newVirtualHostHandler(c.vhosts, ok).ServeHTTP(w, r)
+		if w.Code != c.status {
+			t.Errorf("vhosts %v host %s:status %d, expected %d", c.vhosts, c.host, w.Code, c.status)
+		}
+	}
+}
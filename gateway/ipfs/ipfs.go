@@ -27,6 +27,7 @@ import (
 	"github.com/ipfs/go-ipfs-api"
 	pb "github.com/libp2p/go-floodsub/pb"
 	peer "github.com/libp2p/go-libp2p-peer"
+	"io"
 	"net/http"
 )
 
@@ -56,6 +57,12 @@ func (r Record) TopicIDs() []string {
 
 type PubSubSubscription struct {
 	reader *chunkedReader
+	closer io.Closer
+}
+
+// Close 关闭订阅的http连接，阻塞中的Next会返回错误
+func (s *PubSubSubscription) Close() error {
+	return s.closer.Close()
 }
 
 func (s *PubSubSubscription) Next() (*Record, error) {
@@ -85,6 +92,6 @@ func PubSubSubscribe(url, topic string) (*PubSubSubscription, error) {
 			return nil, err
 		}
 		reader := NewChunkedReader(response.Output)
-		return &PubSubSubscription{reader: reader}, nil
+		return &PubSubSubscription{reader: reader, closer: response.Output}, nil
 	}
 }
@@ -1,3 +1,6 @@
+//go:build integration
+// +build integration
+
 /*
 
   Copyright 2017 Loopring Project Ltd (Loopring Foundation).
@@ -21,6 +24,7 @@ package gateway_test
 import (
 	"github.com/Loopring/relay/config"
 	"github.com/Loopring/relay/gateway"
+	"github.com/Loopring/relay/gateway/broadcast"
 	"github.com/Loopring/relay/test"
 	"github.com/ipfs/go-ipfs-api"
 	"testing"
@@ -29,13 +33,13 @@ import (
 
 var (
 	options config.IpfsOptions
-	impl    *gateway.IPFSSubServiceImpl
+	impl    broadcast.Subscriber
 	sh      *shell.Shell
 )
 
 func prepare() {
 	globalConfig := test.LoadConfig()
-	impl = gateway.NewIPFSSubService(globalConfig.Ipfs)
+	impl = gateway.NewOrderSubscriber(&globalConfig.Ipfs)
 	options = globalConfig.Ipfs
 	sh = shell.NewLocalShell()
 }
@@ -19,22 +19,26 @@
 package gateway
 
 import (
-	"errors"
-	"fmt"
+	"context"
+	"github.com/Loopring/relay/config"
 	"github.com/Loopring/relay/dao"
 	"github.com/Loopring/relay/log"
 	"github.com/Loopring/relay/market"
 	"github.com/Loopring/relay/market/util"
 	"github.com/Loopring/relay/marketcap"
+	"github.com/Loopring/relay/miner"
 	"github.com/Loopring/relay/ordermanager"
 	"github.com/Loopring/relay/types"
 	"github.com/ethereum/go-ethereum/common"
 	"github.com/ethereum/go-ethereum/rpc"
 	"math/big"
 	"net"
+	"net/http"
 	"sort"
 	"strconv"
 	"strings"
+	"sync"
+	"time"
 )
 
 func (*JsonrpcServiceImpl) Ping(val string, val2 int) (res string, err error) {
@@ -61,11 +65,45 @@ type AskBid struct {
 }
 
 type DepthElement struct {
-	Price string
-	Size *big.Rat
+	Price  string
+	Size   *big.Rat
 	Amount *big.Rat
 }
 
+type FeeQuote struct {
+	Market                string `json:"market"`
+	TokenS                string `json:"tokenS"`
+	AmountS               string `json:"amountS"`
+	ContractVersion       string `json:"contractVersion"`
+	LrcFee                string `json:"lrcFee"`
+	MarginSplitPercentage uint8  `json:"marginSplitPercentage"`
+	GasPrice              string `json:"gasPrice"`
+	RingGas               string `json:"ringGas"`
+	LegalCost             string `json:"legalCost"`
+	LegalValue            string `json:"legalValue"`
+}
+
+type Portfolio struct {
+	Owner          string           `json:"owner"`
+	Currency       string           `json:"currency"`
+	AvailableValue float64          `json:"availableValue"`
+	FrozenValue    float64          `json:"frozenValue"`
+	TotalValue     float64          `json:"totalValue"`
+	Tokens         []TokenPortfolio `json:"tokens"`
+}
+
+type TokenPortfolio struct {
+	Token          string  `json:"token"`
+	Balance        string  `json:"balance"`
+	Allowance      string  `json:"allowance"`
+	Available      string  `json:"available"`
+	Frozen         string  `json:"frozen"`
+	Price          float64 `json:"price"`
+	AvailableValue float64 `json:"availableValue"`
+	FrozenValue    float64 `json:"frozenValue"`
+	TotalValue     float64 `json:"totalValue"`
+}
+
 type CommonTokenRequest struct {
 	ContractVersion string `json:"contractVersion"`
 	Owner           string `json:"owner"`
@@ -83,6 +121,7 @@ type OrderQuery struct {
 
 type DepthQuery struct {
 	Length          int    `json:"length"`
+	Precision       int    `json:"precision"`
 	ContractVersion string `json:"contractVersion"`
 	Market          string `json:"market"`
 }
@@ -132,6 +171,38 @@ type OrderJsonResult struct {
 	Status           string             `json:"status"`
 }
 
+type OrderHistoryEvent struct {
+	Event            string `json:"event"`
+	Status           string `json:"status"`
+	DealtAmountS     string `json:"dealtAmountS"`
+	DealtAmountB     string `json:"dealtAmountB"`
+	CancelledAmountS string `json:"cancelledAmountS"`
+	CancelledAmountB string `json:"cancelledAmountB"`
+	RingHash         string `json:"ringHash,omitempty"`
+	TxHash           string `json:"txHash,omitempty"`
+	BlockNumber      int64  `json:"blockNumber"`
+	CreateTime       int64  `json:"createTime"`
+}
+
+type SupportedToken struct {
+	Symbol           string   `json:"symbol"`
+	Address          string   `json:"address"`
+	Decimals         int      `json:"decimals"`
+	IsMarket         bool     `json:"isMarket"`
+	Deny             bool     `json:"deny"`
+	ContractVersions []string `json:"contractVersions"`
+}
+
+type SupportedMarket struct {
+	Market           string   `json:"market"`
+	Token            string   `json:"token"`
+	TokenAddress     string   `json:"tokenAddress"`
+	BaseToken        string   `json:"baseToken"`
+	BaseTokenAddress string   `json:"baseTokenAddress"`
+	Deny             bool     `json:"deny"`
+	ContractVersions []string `json:"contractVersions"`
+}
+
 type PriceQuote struct {
 	Currency string       `json:"currency"`
 	Tokens   []TokenPrice `json:"tokens"`
@@ -142,82 +213,316 @@ type TokenPrice struct {
 	Price float64 `json:"price"`
 }
 
-var RemoteAddrContextKey = "RemoteAddr"
+type SubmitOrderResult struct {
+	Hash     string `json:"hash"`
+	Accepted bool   `json:"accepted"`
+	Filter   string `json:"filter,omitempty"`
+	Code     int    `json:"code,omitempty"`
+	Reason   string `json:"reason,omitempty"`
+}
+
+const (
+	// 未指定时间范围时返回的k线数量
+	defaultTrendCount = 100
+
+	// 深度价格的最大精度(小数位数)，也是默认精度
+	maxDepthPrecision = 10
+	// 聚合深度时每次从数据库读取的订单数
+	depthPageSize = 100
+
+	// loopring_getFeeQuote按两个订单组成的环路估算成本
+	defaultFeeQuoteRingGas = 400000
+	feeQuoteRingLength     = 2
+	// 未配置fee_quote_margin_split时建议的分润比例，lrcFee为0时合约会使用100%分润
+	defaultMarginSplitPercentage = 50
+)
+
+const (
+	maxBatchOrders     = 500
+	batchSubmitWorkers = 8
+)
+
+const shutdownTimeout = 5 * time.Second
 
 type JsonrpcService interface {
-	Start(port string)
+	Start()
 	Stop()
 }
 
 type JsonrpcServiceImpl struct {
-	port           string
+	options        *config.JsonrpcOptions
 	trendManager   market.TrendManager
 	orderManager   ordermanager.OrderManager
 	accountManager market.AccountManager
 	ethForwarder   *EthForwarder
 	marketCap      marketcap.MarketCapProvider
+	evaluator      *miner.Evaluator
+	wsService      *WebsocketServiceImpl
+	rpcServer      *rpc.Server
+	httpServer     *http.Server
+	wsServer       *http.Server
 }
 
-func NewJsonrpcService(port string, trendManager market.TrendManager, orderManager ordermanager.OrderManager, accountManager market.AccountManager, ethForwarder *EthForwarder, capProvider marketcap.MarketCapProvider) *JsonrpcServiceImpl {
+func NewJsonrpcService(options *config.JsonrpcOptions, trendManager market.TrendManager, orderManager ordermanager.OrderManager, accountManager market.AccountManager, ethForwarder *EthForwarder, capProvider marketcap.MarketCapProvider, evaluator *miner.Evaluator) *JsonrpcServiceImpl {
 	l := &JsonrpcServiceImpl{}
-	l.port = port
+	l.options = options
 	l.trendManager = trendManager
 	l.orderManager = orderManager
 	l.accountManager = accountManager
 	l.ethForwarder = ethForwarder
 	l.marketCap = capProvider
+	l.evaluator = evaluator
 	return l
 }
 
 func (j *JsonrpcServiceImpl) Start() {
 	handler := rpc.NewServer()
 	if err := handler.RegisterName("loopring", j); err != nil {
-		fmt.Println(err)
+		log.Errorf("jsonrpc,register loopring service error:%s", err.Error())
 		return
 	}
 	if err := handler.RegisterName("eth", j.ethForwarder); err != nil {
-		fmt.Println(err)
+		log.Errorf("jsonrpc,register eth service error:%s", err.Error())
 		return
 	}
-	var (
-		listener net.Listener
-		err      error
-	)
-	if listener, err = net.Listen("tcp", ":8083"); err != nil {
+	j.ethForwarder.start()
+	j.rpcServer = handler
+
+	addr := net.JoinHostPort(j.options.Host, strconv.Itoa(j.options.Port))
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		log.Errorf("jsonrpc,http endpoint listen on %s error:%s", addr, err.Error())
 		return
 	}
-	go rpc.NewHTTPServer([]string{"*"}, handler).Serve(listener)
-	log.Info(fmt.Sprintf("HTTP endpoint opened on 8083"))
+	j.httpServer = &http.Server{
+		Handler:      newHTTPHandler(j.options, handler),
+		ReadTimeout:  time.Duration(j.options.ReadTimeout) * time.Second,
+		WriteTimeout: time.Duration(j.options.WriteTimeout) * time.Second,
+	}
+	go j.serve(j.httpServer, listener)
+	log.Infof("HTTP endpoint opened on %s", addr)
 
-	return
+	if j.options.WsPort <= 0 {
+		return
+	}
+	j.wsService = NewWebsocketService(j)
+	if err := handler.RegisterName("loopring", j.wsService); err != nil {
+		log.Errorf("jsonrpc,register websocket service error:%s", err.Error())
+		return
+	}
+	wsAddr := net.JoinHostPort(j.options.Host, strconv.Itoa(j.options.WsPort))
+	wsListener, err := net.Listen("tcp", wsAddr)
+	if err != nil {
+		log.Errorf("websocket endpoint listen on %s error:%s", wsAddr, err.Error())
+		return
+	}
+	j.wsService.Start()
+	// websocket连接在握手后被接管，读写超时不适用
+	j.wsServer = &http.Server{Handler: newVirtualHostHandler(j.options.VirtualHosts, newWebsocketHandler(handler, j.options.AllowedOrigins))}
+	go j.serve(j.wsServer, wsListener)
+	log.Infof("websocket endpoint opened on %s", wsAddr)
 }
 
-func (j *JsonrpcServiceImpl) SubmitOrder(order *types.OrderJsonRequest) (res string, err error) {
-	err = HandleOrder(types.ToOrder(order))
-	if err != nil {
-		fmt.Println(err)
+func (j *JsonrpcServiceImpl) serve(srv *http.Server, listener net.Listener) {
+	var err error
+	if j.options.TlsCertFile != "" && j.options.TlsKeyFile != "" {
+		err = srv.ServeTLS(listener, j.options.TlsCertFile, j.options.TlsKeyFile)
+	} else {
+		err = srv.Serve(listener)
+	}
+	if err != nil && err != http.ErrServerClosed {
+		log.Errorf("jsonrpc,serve on %s error:%s", listener.Addr().String(), err.Error())
+	}
+}
+
+// Stop close listeners and wait for in-flight requests to finish
+func (j *JsonrpcServiceImpl) Stop() {
+	ctx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer cancel()
+
+	for _, srv := range []*http.Server{j.httpServer, j.wsServer} {
+		if srv == nil {
+			continue
+		}
+		if err := srv.Shutdown(ctx); err != nil {
+			log.Errorf("jsonrpc,shutdown error:%s", err.Error())
+		}
+	}
+	if j.wsService != nil {
+		j.wsService.Stop()
+	}
+	if j.rpcServer != nil {
+		j.ethForwarder.stop()
+		j.rpcServer.Stop()
+	}
+}
+
+func (j *JsonrpcServiceImpl) SubmitOrder(ctx context.Context, order *types.OrderJsonRequest) (res string, err error) {
+	defer rpcError(ctx, &err)
+	if err = handleOrder(types.ToOrder(order), orderFromClient, remoteAddr(ctx)); err != nil {
+		log.Errorf("jsonrpc,submit order error:%s", err.Error())
+		return "", internalError(err)
 	}
 	res = "SUBMIT_SUCCESS"
-	return res, err
+	return res, nil
+}
+
+// SubmitOrders run every order through gateway filters and return result of each order in the same sequence
+func (j *JsonrpcServiceImpl) SubmitOrders(ctx context.Context, orders []*types.OrderJsonRequest) (res []SubmitOrderResult, err error) {
+	defer rpcError(ctx, &err)
+	if len(orders) > maxBatchOrders {
+		return nil, NewJsonrpcError(ErrCodeInvalidParams, "too many orders, at most %d orders can be submitted once", maxBatchOrders).With("maxOrders", maxBatchOrders)
+	}
+
+	res = make([]SubmitOrderResult, len(orders))
+	handled := make(map[common.Hash]bool)
+	// quota和balance过滤先查询再插入，同一owner的订单必须依次处理，否则并发处理时会超出限制
+	var owners []common.Address
+	ownerOrders := make(map[common.Address][]int)
+	for idx, req := range orders {
+		order := types.ToOrder(req)
+		hash := order.GenerateHash()
+		res[idx].Hash = hash.Hex()
+
+		// 同一批次内重复的订单只处理一次
+		if handled[hash] {
+			res[idx] = duplicateOrderResult(hash.Hex(), "order "+hash.Hex()+" duplicated in batch")
+			continue
+		}
+		handled[hash] = true
+		if _, ok := ownerOrders[order.Owner]; !ok {
+			owners = append(owners, order.Owner)
+		}
+		ownerOrders[order.Owner] = append(ownerOrders[order.Owner], idx)
+	}
+	jobs := make(chan []int, len(owners))
+	for _, owner := range owners {
+		jobs <- ownerOrders[owner]
+	}
+	close(jobs)
+
+	addr := remoteAddr(ctx)
+	var wg sync.WaitGroup
+	for i := 0; i < batchSubmitWorkers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for indexes := range jobs {
+				for _, idx := range indexes {
+					isNew, err := processOrder(types.ToOrder(orders[idx]), orderFromClient, addr)
+					if err == nil && !isNew {
+						res[idx] = duplicateOrderResult(res[idx].Hash, "order "+res[idx].Hash+" already exists")
+					} else {
+						res[idx] = submitOrderResult(res[idx].Hash, err)
+					}
+				}
+			}
+		}()
+	}
+	wg.Wait()
+
+	return res, nil
+}
+
+type ValidateOrderResult struct {
+	Hash    string          `json:"hash"`
+	Price   string          `json:"price"`
+	Valid   bool            `json:"valid"`
+	Filters []FilterVerdict `json:"filters"`
 }
 
-func (j *JsonrpcServiceImpl) GetOrders(query *OrderQuery) (res PageResult, err error) {
+// ValidateOrder is a dry run of SubmitOrder, the order is neither stored nor broadcast
+func (j *JsonrpcServiceImpl) ValidateOrder(order *types.OrderJsonRequest) (res ValidateOrderResult, err error) {
+	o := types.ToOrder(order)
+	res.Valid, res.Filters = ValidateOrder(o)
+	res.Hash = o.Hash.Hex()
+	if o.Price != nil {
+		res.Price = o.Price.FloatString(10)
+	}
+	return res, nil
+}
+
+func remoteAddr(ctx context.Context) string {
+	if conn, ok := rpcConnFromContext(ctx); ok {
+		return conn.remoteAddr
+	}
+	return ""
+}
+
+func submitOrderResult(hash string, err error) SubmitOrderResult {
+	result := SubmitOrderResult{Hash: hash, Accepted: err == nil}
+	if rejected, ok := err.(*FilterRejectedError); ok {
+		result.Filter = rejected.Filter
+	}
+	if err != nil {
+		result.Code = internalError(err).(rpc.Error).ErrorCode()
+		result.Reason = err.Error()
+	}
+	return result
+}
+
+func duplicateOrderResult(hash, reason string) SubmitOrderResult {
+	return SubmitOrderResult{Hash: hash, Filter: "duplicate", Code: ErrCodeDuplicateOrder, Reason: reason}
+}
+
+// GetFilterStats returns accept/reject counts of gateway filters since relay started
+func (j *JsonrpcServiceImpl) GetFilterStats() (res []FilterStats, err error) {
+	return GetFilterStats(), nil
+}
+
+// GetPeers returns score and message counts of relays broadcasting orders to us
+func (j *JsonrpcServiceImpl) GetPeers() (res []PeerStatus, err error) {
+	return GetPeers(), nil
+}
+
+func (j *JsonrpcServiceImpl) GetOrders(ctx context.Context, query *OrderQuery) (res PageResult, err error) {
+	defer rpcError(ctx, &err)
 	orderQuery, pi, ps := convertFromQuery(query)
 	queryRst, err := j.orderManager.GetOrders(orderQuery, pi, ps)
 	if err != nil {
-		fmt.Println(err)
+		log.Errorf("jsonrpc,get orders error:%s", err.Error())
+	}
+	return buildOrderResult(queryRst), internalError(err)
+}
+
+func (j *JsonrpcServiceImpl) GetOrderHistory(ctx context.Context, orderHash string) (res []OrderHistoryEvent, err error) {
+	defer rpcError(ctx, &err)
+	if !strings.HasPrefix(orderHash, "0x") || len(orderHash) != 66 {
+		return res, NewJsonrpcError(ErrCodeInvalidParams, "invalid order hash %s", orderHash).With("orderHash", orderHash)
+	}
+
+	events, err := j.orderManager.GetOrderHistory(common.HexToHash(orderHash))
+	if err != nil {
+		return res, internalError(err)
+	}
+
+	res = make([]OrderHistoryEvent, 0, len(events))
+	for _, v := range events {
+		res = append(res, OrderHistoryEvent{
+			Event:            v.Event,
+			Status:           getStringStatus(types.OrderStatus(v.Status)),
+			DealtAmountS:     amountToHex(v.DealtAmountS),
+			DealtAmountB:     amountToHex(v.DealtAmountB),
+			CancelledAmountS: amountToHex(v.CancelledAmountS),
+			CancelledAmountB: amountToHex(v.CancelledAmountB),
+			RingHash:         v.RingHash,
+			TxHash:           v.TxHash,
+			BlockNumber:      v.BlockNumber,
+			CreateTime:       v.CreateTime,
+		})
 	}
-	return buildOrderResult(queryRst), err
+	return res, nil
 }
 
-func (j *JsonrpcServiceImpl) GetDepth(query DepthQuery) (res Depth, err error) {
+func (j *JsonrpcServiceImpl) GetDepth(ctx context.Context, query DepthQuery) (res Depth, err error) {
+	defer rpcError(ctx, &err)
 
 	mkt := strings.ToUpper(query.Market)
 	protocol := query.ContractVersion
 	length := query.Length
 
 	if mkt == "" || protocol == "" || util.ContractVersionConfig[protocol] == "" {
-		err = errors.New("market and correct contract version must be applied")
+		err = NewJsonrpcError(ErrCodeInvalidParams, "market and correct contract version must be applied").With("market", query.Market).With("contractVersion", protocol)
 		return
 	}
 
@@ -225,11 +530,16 @@ func (j *JsonrpcServiceImpl) GetDepth(query DepthQuery) (res Depth, err error) {
 		length = 20
 	}
 
+	precision := query.Precision
+	if precision <= 0 || precision > maxDepthPrecision {
+		precision = maxDepthPrecision
+	}
+
 	a, b := util.UnWrap(mkt)
 
 	_, err = util.WrapMarket(a, b)
 	if err != nil {
-		err = errors.New("unsupported market type")
+		err = NewJsonrpcError(ErrCodeUnsupportedMarket, "unsupported market type").With("market", mkt)
 		return
 	}
 
@@ -241,42 +551,34 @@ func (j *JsonrpcServiceImpl) GetDepth(query DepthQuery) (res Depth, err error) {
 	askBid := AskBid{Buy: empty, Sell: empty}
 	depth := Depth{ContractVersion: util.ContractVersionConfig[protocol], Market: mkt, Depth: askBid}
 
-	//(TODO) 考虑到需要聚合的情况，所以每次取2倍的数据，先聚合完了再cut, 不是完美方案，后续再优化
-	asks, askErr := j.orderManager.GetOrderBook(
-		common.HexToAddress(util.ContractVersionConfig[protocol]),
-		util.AllTokens[a].Protocol,
-		util.AllTokens[b].Protocol, length*2)
+	protocolAddress := common.HexToAddress(util.ContractVersionConfig[protocol])
 
+	sell, askErr := j.getDepthLevels(protocolAddress, util.AllTokens[a].Protocol, util.AllTokens[b].Protocol, length, precision, true, util.AllTokens[a].Decimals, util.AllTokens[b].Decimals)
 	if askErr != nil {
-		err = errors.New("get depth error , please refresh again")
+		err = NewJsonrpcError(ErrCodeInternal, "get depth error , please refresh again")
 		return
 	}
+	depth.Depth.Sell = sell
 
-	depth.Depth.Sell = calculateDepth(asks, length, true, util.AllTokens[a].Decimals, util.AllTokens[b].Decimals)
-
-	bids, bidErr := j.orderManager.GetOrderBook(
-		common.HexToAddress(util.ContractVersionConfig[protocol]),
-		util.AllTokens[b].Protocol,
-		util.AllTokens[a].Protocol, length*2)
-
+	buy, bidErr := j.getDepthLevels(protocolAddress, util.AllTokens[b].Protocol, util.AllTokens[a].Protocol, length, precision, false, util.AllTokens[b].Decimals, util.AllTokens[a].Decimals)
 	if bidErr != nil {
-		err = errors.New("get depth error , please refresh again")
+		err = NewJsonrpcError(ErrCodeInternal, "get depth error , please refresh again")
 		return
 	}
-
-	depth.Depth.Buy = calculateDepth(bids, length, false, util.AllTokens[b].Decimals, util.AllTokens[a].Decimals)
+	depth.Depth.Buy = buy
 
 	return depth, err
 }
 
-func (j *JsonrpcServiceImpl) GetFills(query FillQuery) (dao.PageResult, error) {
+func (j *JsonrpcServiceImpl) GetFills(ctx context.Context, query FillQuery) (result dao.PageResult, err error) {
+	defer rpcError(ctx, &err)
 	res, err := j.orderManager.FillsPageQuery(fillQueryToMap(query))
 
 	if err != nil {
-		return dao.PageResult{}, nil
+		return dao.PageResult{}, internalError(err)
 	}
 
-	result := dao.PageResult{PageIndex: res.PageIndex, PageSize: res.PageSize, Total: res.Total, Data: make([]interface{}, 0)}
+	result = dao.PageResult{PageIndex: res.PageIndex, PageSize: res.PageSize, Total: res.Total, Data: make([]interface{}, 0)}
 
 	for _, f := range res.Data {
 		fill := f.(dao.FillEvent)
@@ -287,8 +589,40 @@ func (j *JsonrpcServiceImpl) GetFills(query FillQuery) (dao.PageResult, error) {
 	return result, nil
 }
 
-func (j *JsonrpcServiceImpl) GetTicker(contractVersion string) (res []market.Ticker, err error) {
+// GetTransactions 返回owner通过relay提交的交易，按提交时间倒序
+func (j *JsonrpcServiceImpl) GetTransactions(ctx context.Context, query TransactionQuery) (res dao.PageResult, err error) {
+	defer rpcError(ctx, &err)
+	if !common.IsHexAddress(query.Owner) {
+		return res, NewJsonrpcError(ErrCodeInvalidParams, "invalid owner %s", query.Owner).With("owner", query.Owner)
+	}
+
+	var statusSet []types.TxStatus
+	if query.Status != "" {
+		status, ok := convertTxStatus(query.Status)
+		if !ok {
+			return res, NewJsonrpcError(ErrCodeInvalidParams, "unsupported transaction status %s", query.Status).With("status", query.Status)
+		}
+		statusSet = append(statusSet, status)
+	}
+
+	pageIndex, pageSize := query.PageIndex, query.PageSize
+	if pageIndex <= 0 {
+		pageIndex = 1
+	}
+	if pageSize <= 0 || pageSize > 50 {
+		pageSize = 50
+	}
+
+	res, err = j.ethForwarder.getTransactions(common.HexToAddress(query.Owner), statusSet, pageIndex, pageSize)
+	return res, internalError(err)
+}
+
+func (j *JsonrpcServiceImpl) GetTicker(ctx context.Context, contractVersion string) (res []market.Ticker, err error) {
+	defer rpcError(ctx, &err)
 	res, err = j.trendManager.GetTicker()
+	if err != nil {
+		return nil, internalError(err)
+	}
 
 	for i, t := range res {
 		j.fillBuyAndSell(&t, contractVersion)
@@ -297,22 +631,50 @@ func (j *JsonrpcServiceImpl) GetTicker(contractVersion string) (res []market.Tic
 	return
 }
 
-func (j *JsonrpcServiceImpl) GetTrend(market string) (res []market.Trend, err error) {
-	res, err = j.trendManager.GetTrends(market)
-	sort.Slice(res, func(i, j int) bool {
-		return res[i].Start < res[j].Start
-	})
-	return
+// GetTrend interval和时间范围可省略，默认返回最近100根1h k线
+func (j *JsonrpcServiceImpl) GetTrend(ctx context.Context, mkt string, interval *string, start, end *int64) (res []market.Trend, err error) {
+	defer rpcError(ctx, &err)
+	trendInterval := market.OneHour
+	if interval != nil {
+		trendInterval = *interval
+	}
+	if !market.IsValidInterval(trendInterval) {
+		return nil, NewJsonrpcError(ErrCodeInvalidParams, "unsupported trend interval %s", trendInterval).With("interval", trendInterval)
+	}
+
+	trendEnd := time.Now().Unix()
+	if end != nil {
+		trendEnd = *end
+	}
+	trendStart := trendEnd - defaultTrendCount*market.IntervalSeconds(trendInterval)
+	if start != nil {
+		trendStart = *start
+	}
+	if trendStart > trendEnd {
+		return nil, NewJsonrpcError(ErrCodeInvalidParams, "trend start %d is after end %d", trendStart, trendEnd)
+	}
+	if (trendEnd-trendStart)/market.IntervalSeconds(trendInterval) >= market.MaxTrendCount {
+		return nil, NewJsonrpcError(ErrCodeInvalidParams, "trend range is too large, at most %d %s candles", market.MaxTrendCount, trendInterval)
+	}
+
+	res, err = j.trendManager.GetTrends(mkt, trendInterval, trendStart, trendEnd)
+	return res, internalError(err)
 }
 
-func (j *JsonrpcServiceImpl) GetRingMined(query RingMinedQuery) (res dao.PageResult, err error) {
-	return j.orderManager.RingMinedPageQuery(ringMinedQueryToMap(query))
+func (j *JsonrpcServiceImpl) GetRingMined(ctx context.Context, query RingMinedQuery) (res dao.PageResult, err error) {
+	defer rpcError(ctx, &err)
+	res, err = j.orderManager.RingMinedPageQuery(ringMinedQueryToMap(query))
+	return res, internalError(err)
 }
 
-func (j *JsonrpcServiceImpl) GetBalance(balanceQuery CommonTokenRequest) (res market.AccountJson, err error) {
+func (j *JsonrpcServiceImpl) GetBalance(ctx context.Context, balanceQuery CommonTokenRequest) (res market.AccountJson, err error) {
+	defer rpcError(ctx, &err)
+	if util.ContractVersionConfig[balanceQuery.ContractVersion] == "" {
+		return res, NewJsonrpcError(ErrCodeInvalidParams, "unsupported contract version %s", balanceQuery.ContractVersion).With("contractVersion", balanceQuery.ContractVersion)
+	}
 	account := j.accountManager.GetBalance(balanceQuery.ContractVersion, balanceQuery.Owner)
 	ethBalance := market.Balance{Token: "ETH", Balance: big.NewInt(0)}
-	b, bErr := j.ethForwarder.GetBalance(balanceQuery.Owner, "latest")
+	b, bErr := j.ethForwarder.getBalance(balanceQuery.Owner, "latest")
 	if bErr == nil {
 		ethBalance.Balance = types.HexToBigint(b)
 		newBalances := make(map[string]market.Balance)
@@ -326,15 +688,239 @@ func (j *JsonrpcServiceImpl) GetBalance(balanceQuery CommonTokenRequest) (res ma
 	return
 }
 
-func (j *JsonrpcServiceImpl) GetCutoff(address, contractVersion, blockNumber string) (result string, err error) {
+func (j *JsonrpcServiceImpl) GetSupportedTokens(ctx context.Context) (res []SupportedToken, err error) {
+	defer rpcError(ctx, &err)
+	versions := contractVersions()
+	res = make([]SupportedToken, 0)
+	for _, v := range supportedTokens() {
+		res = append(res, SupportedToken{
+			Symbol:           v.Symbol,
+			Address:          v.Protocol.Hex(),
+			Decimals:         tokenDecimals(v),
+			IsMarket:         v.IsMarket,
+			Deny:             v.Deny,
+			ContractVersions: versions,
+		})
+	}
+	sort.Slice(res, func(i, j int) bool {
+		return res[i].Symbol < res[j].Symbol
+	})
+	return res, nil
+}
+
+func (j *JsonrpcServiceImpl) GetSupportedMarkets(ctx context.Context) (res []SupportedMarket, err error) {
+	defer rpcError(ctx, &err)
+	versions := contractVersions()
+	tokens := supportedTokens()
+	res = make([]SupportedMarket, 0)
+	for _, base := range tokens {
+		if !base.IsMarket {
+			continue
+		}
+		for _, token := range tokens {
+			if token.IsMarket {
+				continue
+			}
+			res = append(res, SupportedMarket{
+				Market:           token.Symbol + "-" + base.Symbol,
+				Token:            token.Symbol,
+				TokenAddress:     token.Protocol.Hex(),
+				BaseToken:        base.Symbol,
+				BaseTokenAddress: base.Protocol.Hex(),
+				Deny:             token.Deny || base.Deny,
+				ContractVersions: versions,
+			})
+		}
+	}
+	sort.Slice(res, func(i, j int) bool {
+		return res[i].Market < res[j].Market
+	})
+	return res, nil
+}
+
+func (j *JsonrpcServiceImpl) GetCutoff(ctx context.Context, address, contractVersion, blockNumber string) (result string, err error) {
+	defer rpcError(ctx, &err)
+	if util.ContractVersionConfig[contractVersion] == "" {
+		return "", NewJsonrpcError(ErrCodeInvalidParams, "unsupported contract version %s", contractVersion).With("contractVersion", contractVersion)
+	}
 	cutoff, err := j.ethForwarder.Accessor.GetCutoff(common.HexToAddress(util.ContractVersionConfig[contractVersion]), common.HexToAddress(address), blockNumber)
 	if err != nil {
-		return "", err
+		return "", internalError(err)
 	}
 	return cutoff.String(), nil
 }
 
-func (j *JsonrpcServiceImpl) GetPriceQuote(currency string) (result PriceQuote, err error) {
+// BuildCancelOrderTx 构造取消订单的交易，未指定cancelAmount时取消全部
+func (j *JsonrpcServiceImpl) BuildCancelOrderTx(ctx context.Context, req CancelOrderTxRequest) (tx UnsignedTx, err error) {
+	defer rpcError(ctx, &err)
+	if !strings.HasPrefix(req.OrderHash, "0x") || len(req.OrderHash) != 66 {
+		return tx, NewJsonrpcError(ErrCodeInvalidParams, "invalid order hash %s", req.OrderHash).With("orderHash", req.OrderHash)
+	}
+	state, err := j.orderManager.GetOrderByHash(common.HexToHash(req.OrderHash))
+	if err != nil {
+		return tx, NewJsonrpcError(ErrCodeInvalidParams, "order %s not found", req.OrderHash).With("orderHash", req.OrderHash)
+	}
+
+	order := state.RawOrder
+	if _, ok := j.ethForwarder.Accessor.ProtocolAddresses[order.Protocol]; !ok {
+		return tx, NewJsonrpcError(ErrCodeInvalidParams, "unsupported protocol %s", order.Protocol.Hex()).With("protocol", order.Protocol.Hex())
+	}
+
+	// 合约按buyNoMoreThanAmountB决定cancelAmount的单位
+	cancelAmount := order.AmountS
+	if order.BuyNoMoreThanAmountB {
+		cancelAmount = order.AmountB
+	}
+	if req.CancelAmount != "" {
+		amount, ok := parseAmount(req.CancelAmount)
+		if !ok || amount.Sign() == 0 {
+			return tx, NewJsonrpcError(ErrCodeInvalidParams, "invalid cancel amount %s", req.CancelAmount).With("cancelAmount", req.CancelAmount)
+		}
+		cancelAmount = amount
+	}
+
+	addresses := [3]common.Address{order.Owner, order.TokenS, order.TokenB}
+	values := [7]*big.Int{order.AmountS, order.AmountB, order.Timestamp, order.Ttl, order.Salt, order.LrcFee, cancelAmount}
+	return j.ethForwarder.buildTransaction(order.Owner, order.Protocol, big.NewInt(0), j.ethForwarder.Accessor.ProtocolImplAbi, txMethodCancelOrder,
+		addresses, values, order.BuyNoMoreThanAmountB, order.MarginSplitPercentage, order.V, order.R, order.S)
+}
+
+// BuildCutoffTx 构造设置cutoff的交易，cutoff之前创建的订单全部失效，未指定时为当前时间
+func (j *JsonrpcServiceImpl) BuildCutoffTx(ctx context.Context, req CutoffTxRequest) (tx UnsignedTx, err error) {
+	defer rpcError(ctx, &err)
+	if !common.IsHexAddress(req.Owner) {
+		return tx, NewJsonrpcError(ErrCodeInvalidParams, "invalid owner %s", req.Owner).With("owner", req.Owner)
+	}
+	protocol, ok := util.ContractVersionConfig[req.ContractVersion]
+	if !ok {
+		return tx, NewJsonrpcError(ErrCodeInvalidParams, "unsupported contract version %s", req.ContractVersion).With("contractVersion", req.ContractVersion)
+	}
+
+	cutoff := req.Cutoff
+	if cutoff <= 0 {
+		cutoff = time.Now().Unix()
+	}
+	return j.ethForwarder.buildTransaction(common.HexToAddress(req.Owner), common.HexToAddress(protocol), big.NewInt(0), j.ethForwarder.Accessor.ProtocolImplAbi, txMethodSetCutoff,
+		big.NewInt(cutoff))
+}
+
+// BuildApproveTx 构造授权delegate转移token的交易，未指定amount时授权最大值
+func (j *JsonrpcServiceImpl) BuildApproveTx(ctx context.Context, req ApproveTxRequest) (tx UnsignedTx, err error) {
+	defer rpcError(ctx, &err)
+	if !common.IsHexAddress(req.Owner) {
+		return tx, NewJsonrpcError(ErrCodeInvalidParams, "invalid owner %s", req.Owner).With("owner", req.Owner)
+	}
+	protocol, ok := util.ContractVersionConfig[req.ContractVersion]
+	if !ok {
+		return tx, NewJsonrpcError(ErrCodeInvalidParams, "unsupported contract version %s", req.ContractVersion).With("contractVersion", req.ContractVersion)
+	}
+	impl, ok := j.ethForwarder.Accessor.ProtocolAddresses[common.HexToAddress(protocol)]
+	if !ok {
+		return tx, NewJsonrpcError(ErrCodeInvalidParams, "unsupported contract version %s", req.ContractVersion).With("contractVersion", req.ContractVersion)
+	}
+	token, ok := util.AllTokens[req.Token]
+	if !ok {
+		return tx, NewJsonrpcError(ErrCodeUnsupportedToken, "unsupported token %s", req.Token).With("token", req.Token)
+	}
+
+	amount := maxApproveAmount
+	if req.Amount != "" {
+		if amount, ok = parseAmount(req.Amount); !ok {
+			return tx, NewJsonrpcError(ErrCodeInvalidParams, "invalid amount %s", req.Amount).With("amount", req.Amount)
+		}
+	}
+	return j.ethForwarder.buildTransaction(common.HexToAddress(req.Owner), token.Protocol, big.NewInt(0), j.ethForwarder.Accessor.Erc20Abi, txMethodApprove,
+		impl.DelegateAddress, amount)
+}
+
+// BuildWethTx 构造eth与weth互换的交易，method为deposit或withdraw
+func (j *JsonrpcServiceImpl) BuildWethTx(ctx context.Context, req WethTxRequest) (tx UnsignedTx, err error) {
+	defer rpcError(ctx, &err)
+	if !common.IsHexAddress(req.Owner) {
+		return tx, NewJsonrpcError(ErrCodeInvalidParams, "invalid owner %s", req.Owner).With("owner", req.Owner)
+	}
+	amount, ok := parseAmount(req.Amount)
+	if !ok || amount.Sign() == 0 {
+		return tx, NewJsonrpcError(ErrCodeInvalidParams, "invalid amount %s", req.Amount).With("amount", req.Amount)
+	}
+
+	owner := common.HexToAddress(req.Owner)
+	weth := j.ethForwarder.Accessor.WethAddress
+	wethAbi := j.ethForwarder.Accessor.WethAbi
+	switch req.Method {
+	case txMethodDeposit:
+		return j.ethForwarder.buildTransaction(owner, weth, amount, wethAbi, txMethodDeposit)
+	case txMethodWithdraw:
+		return j.ethForwarder.buildTransaction(owner, weth, big.NewInt(0), wethAbi, txMethodWithdraw, amount)
+	}
+	return tx, NewJsonrpcError(ErrCodeInvalidParams, "unsupported weth method %s", req.Method).With("method", req.Method)
+}
+
+// GetFeeQuote 按当前gas价格和token价格估算订单需要的lrcFee，使包含该订单的环路对矿工有利可图，
+// lrcFee只是环路gas成本的均摊，与amountS无关，不计算分润收益；amountS只用于返回其法币价值供对比。
+// 报价是固定的：marginSplitPercentage直接返回配置的fee_quote_margin_split，不随订单价值变化
+func (j *JsonrpcServiceImpl) GetFeeQuote(ctx context.Context, mkt, amountS, tokenS string, contractVersion *string) (res FeeQuote, err error) {
+	defer rpcError(ctx, &err)
+	mkt = strings.ToUpper(mkt)
+	s, b := util.UnWrap(mkt)
+	if wrapped, err := util.WrapMarket(s, b); err != nil || wrapped != mkt {
+		return res, NewJsonrpcError(ErrCodeUnsupportedMarket, "unsupported market %s", mkt).With("market", mkt)
+	}
+	tokenS = strings.ToUpper(tokenS)
+	if tokenS != s && tokenS != b {
+		return res, NewJsonrpcError(ErrCodeInvalidParams, "token %s is not in market %s", tokenS, mkt).With("token", tokenS).With("market", mkt)
+	}
+	amount, ok := parseAmount(amountS)
+	if !ok || amount.Sign() == 0 {
+		return res, NewJsonrpcError(ErrCodeInvalidParams, "invalid amountS %s", amountS).With("amountS", amountS)
+	}
+
+	version := latestContractVersion()
+	if contractVersion != nil {
+		version = *contractVersion
+	}
+	protocol, ok := util.ContractVersionConfig[version]
+	if !ok {
+		return res, NewJsonrpcError(ErrCodeInvalidParams, "unsupported contract version %s", version).With("contractVersion", version)
+	}
+
+	gasPriceHex, err := j.ethForwarder.gasPrice()
+	if err != nil {
+		return res, internalError(err)
+	}
+	gasPrice := types.HexToBigint(gasPriceHex)
+	ringGas := big.NewInt(j.options.FeeQuoteRingGas)
+	if ringGas.Sign() <= 0 {
+		ringGas = big.NewInt(defaultFeeQuoteRingGas)
+	}
+
+	lrcFee, legalCost, err := j.evaluator.LrcFeeOfCost(common.HexToAddress(protocol), ringGas, gasPrice, feeQuoteRingLength)
+	if err != nil {
+		return res, internalError(err)
+	}
+	legalValue, err := j.marketCap.LegalCurrencyValue(util.AllTokens[tokenS].Protocol, new(big.Rat).SetInt(amount))
+	if err != nil {
+		return res, internalError(err)
+	}
+
+	res.Market = mkt
+	res.TokenS = tokenS
+	res.AmountS = types.BigintToHex(amount)
+	res.ContractVersion = version
+	res.LrcFee = types.BigintToHex(lrcFee)
+	res.MarginSplitPercentage = j.options.FeeQuoteMarginSplit
+	if res.MarginSplitPercentage == 0 || res.MarginSplitPercentage > 100 {
+		res.MarginSplitPercentage = defaultMarginSplitPercentage
+	}
+	res.GasPrice = types.BigintToHex(gasPrice)
+	res.RingGas = types.BigintToHex(ringGas)
+	res.LegalCost = legalCost.FloatString(6)
+	res.LegalValue = legalValue.FloatString(6)
+	return res, nil
+}
+
+func (j *JsonrpcServiceImpl) GetPriceQuote(ctx context.Context, currency string) (result PriceQuote, err error) {
+	defer rpcError(ctx, &err)
 
 	rst := PriceQuote{currency, make([]TokenPrice, 0)}
 	for k, v := range util.AllTokens {
@@ -346,24 +932,117 @@ func (j *JsonrpcServiceImpl) GetPriceQuote(currency string) (result PriceQuote,
 	return rst, nil
 }
 
-func (j *JsonrpcServiceImpl) GetEstimatedAllocatedAllowance(owner, token string) (frozenAmount string, err error) {
+// GetPortfolio 汇总owner各token的余额、被未成交订单冻结的数量及其法币价值
+func (j *JsonrpcServiceImpl) GetPortfolio(ctx context.Context, owner, currency string) (res Portfolio, err error) {
+	defer rpcError(ctx, &err)
+	if !common.IsHexAddress(owner) {
+		return res, NewJsonrpcError(ErrCodeInvalidParams, "invalid owner %s", owner).With("owner", owner)
+	}
+	currency = strings.ToUpper(currency)
+	if currency != "CNY" && currency != "USD" && currency != "BTC" {
+		return res, NewJsonrpcError(ErrCodeInvalidParams, "unsupported currency %s", currency).With("currency", currency)
+	}
+
+	ownerAddress := common.HexToAddress(owner)
+	version := latestContractVersion()
+	account := j.accountManager.GetBalance(version, owner)
+	statusSet := []types.OrderStatus{types.ORDER_NEW, types.ORDER_PARTIAL}
+	frozenLrcFee, err := j.orderManager.GetFrozenLRCFee(ownerAddress, statusSet)
+	if err != nil {
+		return res, internalError(err)
+	}
+
+	res.Owner = ownerAddress.Hex()
+	res.Currency = currency
+	res.Tokens = make([]TokenPortfolio, 0)
+	for _, token := range supportedTokens() {
+		balance := big.NewInt(0)
+		if b, ok := account.Balances[token.Symbol]; ok && b.Balance != nil {
+			balance = b.Balance
+		}
+		frozen, err := j.orderManager.GetFrozenAmount(ownerAddress, token.Protocol, statusSet)
+		if err != nil {
+			return res, internalError(err)
+		}
+		if token.Symbol == "LRC" {
+			frozen.Add(frozen, frozenLrcFee)
+		}
+		if balance.Sign() == 0 && frozen.Sign() == 0 {
+			continue
+		}
+		res.Tokens = append(res.Tokens, j.tokenPortfolio(token, balance, account.GetAllowance(version, token.Symbol), frozen, currency))
+	}
+
+	// eth不能被订单冻结，按weth计价
+	if b, err := j.ethForwarder.getBalance(owner, "latest"); err == nil {
+		if balance := types.HexToBigint(b); balance.Sign() > 0 {
+			eth := j.tokenPortfolio(util.AllTokens["WETH"], balance, big.NewInt(0), big.NewInt(0), currency)
+			eth.Token = "ETH"
+			res.Tokens = append(res.Tokens, eth)
+		}
+	}
+
+	sort.Slice(res.Tokens, func(i, j int) bool {
+		return res.Tokens[i].Token < res.Tokens[j].Token
+	})
+	for _, v := range res.Tokens {
+		res.AvailableValue += v.AvailableValue
+		res.FrozenValue += v.FrozenValue
+		res.TotalValue += v.TotalValue
+	}
+	return res, nil
+}
+
+// tokenPortfolio 冻结数量超过余额时按余额计算，此时订单已无法完全成交
+func (j *JsonrpcServiceImpl) tokenPortfolio(token types.Token, balance, allowance, frozen *big.Int, currency string) TokenPortfolio {
+	if frozen.Cmp(balance) > 0 {
+		frozen = new(big.Int).Set(balance)
+	}
+	available := new(big.Int).Sub(balance, frozen)
+
+	price, _ := j.marketCap.GetMarketCapByCurrency(token.Protocol, currency)
+	value := func(amount *big.Int) float64 {
+		v, err := j.marketCap.LegalCurrencyValueByCurrency(token.Protocol, new(big.Rat).SetInt(amount), currency)
+		if err != nil {
+			return 0
+		}
+		f, _ := v.Float64()
+		return f
+	}
+	floatPrice, _ := price.Float64()
+
+	return TokenPortfolio{
+		Token:          token.Symbol,
+		Balance:        types.BigintToHex(balance),
+		Allowance:      types.BigintToHex(allowance),
+		Available:      types.BigintToHex(available),
+		Frozen:         types.BigintToHex(frozen),
+		Price:          floatPrice,
+		AvailableValue: value(available),
+		FrozenValue:    value(frozen),
+		TotalValue:     value(balance),
+	}
+}
+
+func (j *JsonrpcServiceImpl) GetEstimatedAllocatedAllowance(ctx context.Context, owner, token string) (frozenAmount string, err error) {
+	defer rpcError(ctx, &err)
 	statusSet := make([]types.OrderStatus, 0)
 	statusSet = append(statusSet, types.ORDER_NEW)
 	statusSet = append(statusSet, types.ORDER_PARTIAL)
 
 	tokenAddress := util.AliasToAddress(token)
-	if tokenAddress.Hex() == "" {
-		return "", errors.New("unsupported token alias " + token)
+	if tokenAddress == (common.Address{}) {
+		return "", NewJsonrpcError(ErrCodeUnsupportedToken, "unsupported token alias %s", token).With("token", token)
 	}
 	amount, err := j.orderManager.GetFrozenAmount(common.HexToAddress(owner), tokenAddress, statusSet)
 	if err != nil {
-		return "", err
+		return "", internalError(err)
 	}
 
 	if token == "LRC" {
 		allLrcFee, err := j.orderManager.GetFrozenLRCFee(common.HexToAddress(owner), statusSet)
 		if err != nil {
-			return "", err
+			return "", internalError(err)
 		}
 		amount.Add(amount, allLrcFee)
 	}
@@ -408,10 +1087,91 @@ func convertStatus(s string) types.OrderStatus {
 		return types.ORDER_CANCEL
 	case "ORDER_CUTOFF":
 		return types.ORDER_CUTOFF
+	case "ORDER_EXPIRED":
+		return types.ORDER_EXPIRED
 	}
 	return types.ORDER_UNKNOWN
 }
 
+// supportedTokens 返回所有已知token，包括被禁用的，随TokenRegister/TokenUnRegister事件更新
+func supportedTokens() []types.Token {
+	list := make([]types.Token, 0, len(util.AllTokens)+len(util.DeniedTokens))
+	for _, v := range util.SupportTokens {
+		v.IsMarket = false
+		list = append(list, v)
+	}
+	for _, v := range util.SupportMarkets {
+		v.IsMarket = true
+		list = append(list, v)
+	}
+	for _, v := range util.DeniedTokens {
+		v.Deny = true
+		list = append(list, v)
+	}
+	return list
+}
+
+// token表没有区分合约版本，所有token对配置的每个合约版本都可用
+func contractVersions() []string {
+	versions := make([]string, 0, len(util.ContractVersionConfig))
+	for k := range util.ContractVersionConfig {
+		versions = append(versions, k)
+	}
+	sort.Slice(versions, func(i, j int) bool {
+		return compareVersion(versions[i], versions[j]) < 0
+	})
+	return versions
+}
+
+// compareVersion 按数字比较v1.10这样的版本号，使v1.10排在v1.9之后，非数字的部分按字符串比较
+func compareVersion(a, b string) int {
+	as := strings.Split(strings.TrimPrefix(strings.ToLower(a), "v"), ".")
+	bs := strings.Split(strings.TrimPrefix(strings.ToLower(b), "v"), ".")
+	for i := 0; i < len(as) && i < len(bs); i++ {
+		x, errX := strconv.Atoi(as[i])
+		y, errY := strconv.Atoi(bs[i])
+		if errX != nil || errY != nil {
+			if c := strings.Compare(as[i], bs[i]); c != 0 {
+				return c
+			}
+			continue
+		}
+		if x != y {
+			if x < y {
+				return -1
+			}
+			return 1
+		}
+	}
+	return len(as) - len(bs)
+}
+
+// latestContractVersion 未指定合约版本时使用最新的版本
+func latestContractVersion() string {
+	versions := contractVersions()
+	if len(versions) == 0 {
+		return ""
+	}
+	return versions[len(versions)-1]
+}
+
+// tokenDecimals 链上新注册的token在重新加载之前不知道精度，返回0
+func tokenDecimals(token types.Token) int {
+	if token.Decimals == nil || token.Decimals.Sign() <= 0 {
+		return 0
+	}
+	return len(token.Decimals.String()) - 1
+}
+
+// amountToHex 将数据库中十进制存储的数量转为与订单接口一致的hex格式
+func amountToHex(amount string) string {
+	n, ok := new(big.Int).SetString(amount, 10)
+	if !ok {
+		n = big.NewInt(0)
+	}
+	return types.BigintToHex(n)
+}
+
 func getStringStatus(s types.OrderStatus) string {
 	switch s {
 	case types.ORDER_NEW:
@@ -424,87 +1184,92 @@ func getStringStatus(s types.OrderStatus) string {
 		return "ORDER_CANCELED"
 	case types.ORDER_CUTOFF:
 		return "ORDER_CUTOFF"
+	case types.ORDER_EXPIRED:
+		return "ORDER_EXPIRED"
 	}
 	return "ORDER_UNKNOWN"
 }
 
-func calculateDepth(states []types.OrderState, length int, isAsk bool, tokenSDecimal, tokenBDecimal *big.Int) [][]string {
+// getDepthLevels 按价格由优到劣分页读取订单并聚合，直到取满length个价格档位或订单全部读完
+func (j *JsonrpcServiceImpl) getDepthLevels(protocol, tokenS, tokenB common.Address, length, precision int, isAsk bool, tokenSDecimal, tokenBDecimal *big.Int) ([][]string, error) {
+	levels := make([]*DepthElement, 0)
+	for offset := 0; ; offset += depthPageSize {
+		states, rows, err := j.orderManager.GetOrderBook(protocol, tokenS, tokenB, offset, depthPageSize)
+		if err != nil {
+			return nil, err
+		}
 
-	if len(states) == 0 {
-		return [][]string{}
+		var full bool
+		levels, full = calculateDepth(levels, states, length, precision, isAsk, tokenSDecimal, tokenBDecimal)
+		if full || rows < depthPageSize {
+			break
+		}
 	}
 
 	depth := make([][]string, 0)
-	for i := range depth {
-		depth[i] = make([]string, 0)
+	totalAmount := new(big.Rat)
+	totalSize := new(big.Rat)
+	for _, v := range levels {
+		totalAmount.Add(totalAmount, v.Amount)
+		totalSize.Add(totalSize, v.Size)
+		depth = append(depth, []string{v.Price, v.Amount.FloatString(10), v.Size.FloatString(10), totalAmount.FloatString(10), totalSize.FloatString(10)})
 	}
+	return depth, nil
+}
 
-	depthMap := make(map[string]DepthElement)
-
+// calculateDepth 将订单按精度聚合到价格档位中，卖单价格向上取整，买单价格向下取整。
+// states需按价格由优到劣排列，出现第length+1个档位时返回full
+func calculateDepth(levels []*DepthElement, states []types.OrderState, length, precision int, isAsk bool, tokenSDecimal, tokenBDecimal *big.Int) ([]*DepthElement, bool) {
 	for _, s := range states {
-
-		price := *s.RawOrder.Price
 		amountS, amountB := s.RemainedAmount()
 		amountS = amountS.Quo(amountS, new(big.Rat).SetFrac(tokenSDecimal, big.NewInt(1)))
 		amountB = amountB.Quo(amountB, new(big.Rat).SetFrac(tokenBDecimal, big.NewInt(1)))
 
-		if amountS.Cmp(new(big.Rat).SetFloat64(0)) == 0 {
+		if amountS.Sign() == 0 {
 			log.Debug("amount s is zero, skipped")
 			continue
 		}
 
-		if amountB.Cmp(new(big.Rat).SetFloat64(0)) == 0 {
+		if amountB.Sign() == 0 {
 			log.Debug("amount b is zero, skipped")
 			continue
 		}
 
+		var price *big.Rat
+		amount, size := amountB, amountS
 		if isAsk {
-			price = *price.Inv(&price)
-			priceFloatStr := price.FloatString(10)
-			if v, ok := depthMap[priceFloatStr]; ok {
-				amount := v.Amount
-				size := v.Size
-				amount = amount.Add(amount, amountS)
-				size = size.Add(size, amountB)
-				depthMap[priceFloatStr] = DepthElement{Price:v.Price, Amount:amount, Size:size}
-			} else {
-				depthMap[priceFloatStr] = DepthElement{Price:priceFloatStr, Amount:amountS, Size:amountB}
-			}
+			price = new(big.Rat).Inv(s.RawOrder.Price)
+			amount, size = amountS, amountB
 		} else {
-			priceFloatStr := price.FloatString(10)
-			if v, ok := depthMap[priceFloatStr]; ok {
-				amount := v.Amount
-				size := v.Size
-				amount = amount.Add(amount, amountB)
-				size = size.Add(size, amountS)
-				depthMap[priceFloatStr] = DepthElement{Price:v.Price, Amount:amount, Size:size}
-			} else {
-				depthMap[priceFloatStr] = DepthElement{Price:priceFloatStr, Amount:amountB, Size:amountS}
-			}
+			price = new(big.Rat).Set(s.RawOrder.Price)
 		}
-	}
+		priceStr := roundPrice(price, precision, isAsk).FloatString(precision)
 
-	for k, v := range depthMap {
-		amount, _ := v.Amount.Float64()
-		size, _ := v.Size.Float64()
-		depth = append(depth, []string{k, strconv.FormatFloat(amount, 'f', 10, 64), strconv.FormatFloat(size, 'f', 10, 64)})
-	}
+		if len(levels) > 0 && levels[len(levels)-1].Price == priceStr {
+			last := levels[len(levels)-1]
+			last.Amount.Add(last.Amount, amount)
+			last.Size.Add(last.Size, size)
+			continue
+		}
 
-	sort.Slice(depth, func(i, j int) bool {
-		cmpA, _ := strconv.ParseFloat(depth[i][0], 64)
-		cmpB, _ := strconv.ParseFloat(depth[j][0], 64)
-		if isAsk {
-			return cmpA < cmpB
-		} else {
-			return cmpA > cmpB
+		if len(levels) >= length {
+			return levels, true
 		}
+		levels = append(levels, &DepthElement{Price: priceStr, Amount: amount, Size: size})
+	}
 
-	})
+	return levels, false
+}
+
+func roundPrice(price *big.Rat, precision int, up bool) *big.Rat {
+	scale := new(big.Int).Exp(big.NewInt(10), big.NewInt(int64(precision)), nil)
+	scaled := new(big.Rat).Mul(price, new(big.Rat).SetInt(scale))
 
-	if length < len(depth) {
-		return depth[:length]
+	rounded, rem := new(big.Int).QuoRem(scaled.Num(), scaled.Denom(), new(big.Int))
+	if up && rem.Sign() > 0 {
+		rounded.Add(rounded, big.NewInt(1))
 	}
-	return depth
+	return new(big.Rat).SetFrac(rounded, scale)
 }
 
 func fillQueryToMap(q FillQuery) (map[string]interface{}, int, int) {
@@ -603,9 +1368,9 @@ func orderStateToJson(src types.OrderState) OrderJsonResult {
 }
 
 func (j *JsonrpcServiceImpl) fillBuyAndSell(ticker *market.Ticker, contractVersion string) {
-	queryDepth := DepthQuery{1, contractVersion, ticker.Market}
+	queryDepth := DepthQuery{Length: 1, ContractVersion: contractVersion, Market: ticker.Market}
 
-	depth, err := j.GetDepth(queryDepth)
+	depth, err := j.GetDepth(context.Background(), queryDepth)
 	if err != nil {
 		log.Error("fill depth info failed")
 	} else {
@@ -1,3 +1,6 @@
+//go:build integration
+// +build integration
+
 /*
 
   Copyright 2017 Loopring Project Ltd (Loopring Foundation).
@@ -16,85 +19,56 @@
 
 */
 
-package gateway
+package gateway_test
 
 import (
-	"fmt"
-	"github.com/Loopring/relay/gateway"
-	"github.com/powerman/rpc-codec/jsonrpc2"
-	"net/http"
+	"math/big"
+	"os"
 	"testing"
 	"time"
-	//"net/http"
-	"encoding/json"
-	"github.com/Loopring/relay/types"
-	"math/big"
-)
 
-var (
-	impl       *gateway.JsonrpcServiceImpl
-	clientHTTP *jsonrpc2.Client
+	"github.com/Loopring/relay/types"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/rpc"
 )
 
-//func main() {
-//	lnHTTP, err := net.Listen("tcp", "127.0.0.1:8080")
-//	if err != nil {
-//		panic(err)
-//	}
-//	defer lnHTTP.Close()
-//
-//	clientHTTP := jsonrpc2.NewHTTPClient("http://" + lnHTTP.Addr().String() + "/rpc")
-//
-//	var relay int
-//
-//	clientHTTP.Call("DefaultHandleSvc.SubmitOrder", []int{3, 5, -2}, &relay)
-//	fmt.Printf("SumAll(3,5,-2)=%d\n", relay)
-//}
-
-func prepare() {
-
-	impl = gateway.NewJsonrpcService("8080")
-
-	impl.Start()
-	//gateway.Example()
+// 需要运行中的relay，默认连接relay.toml中的jsonrpc端口，可通过RELAY_RPC_URL指定
+func dialRelay(t *testing.T) *rpc.Client {
+	url := os.Getenv("RELAY_RPC_URL")
+	if url == "" {
+		url = "http://127.0.0.1:8083"
+	}
+	client, err := rpc.Dial(url)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return client
 }
 
 func TestJsonrpcServiceImpl_SubmitOrder(t *testing.T) {
-	prepare()
-
-	var relay string
-
-	clientHTTP = jsonrpc2.NewHTTPClient("http://127.0.0.1:8080/rpc")
-	defer clientHTTP.Close()
-
-	var req types.Order
-	req.Protocol = types.StringToAddress("testProtocol")
-	req.AmountB = new(big.Int)
-	req.AmountB.UnmarshalText([]byte("123"))
-	req.AmountS = new(big.Int)
-	req.AmountS.UnmarshalText([]byte("222"))
-	req.Timestamp = new(big.Int)
-	req.Timestamp.UnmarshalText([]byte("222"))
-	req.Ttl = new(big.Int)
-	req.Ttl.UnmarshalText([]byte("222"))
-	req.Salt = new(big.Int)
-	req.Salt.UnmarshalText([]byte("222"))
-	req.LrcFee = new(big.Int)
-	req.LrcFee.UnmarshalText([]byte("222"))
-	req.BuyNoMoreThanAmountB = true
-	req.MarginSplitPercentage = uint8(10)
-	req.V = uint8(11)
-	req.R = types.StringToSign("ssss")
-	req.S = types.StringToSign("ssss")
-
-	fmt.Println(req)
-	fmt.Println(json.Marshal(req))
-
-	err := clientHTTP.Call("JsonrpcServiceImpl.SubmitOrder", req, &relay)
-	if err != nil {
-		fmt.Println(err)
+	client := dialRelay(t)
+	defer client.Close()
+
+	req := &types.OrderJsonRequest{
+		Protocol:              common.HexToAddress("0x03E0F73A93993E5101362656Af1162eD80FB54F2"),
+		TokenS:                common.HexToAddress("0xEF68e7C694F40c8202821eDF525dE3782458639f"),
+		TokenB:                common.HexToAddress("0x2956356cD2a2bf3202F771F50D3D14A367b48070"),
+		AmountS:               big.NewInt(222),
+		AmountB:               big.NewInt(123),
+		Timestamp:             time.Now().Unix(),
+		Ttl:                   3600,
+		Salt:                  222,
+		LrcFee:                big.NewInt(222),
+		BuyNoMoreThanAmountB:  true,
+		MarginSplitPercentage: 10,
+		V:                     11,
 	}
-	fmt.Printf("result from submit order %s\n", relay)
 
-	time.Sleep(1 * time.Second)
+	// 未签名的订单应被拒绝
+	var res string
+	if err := client.Call(&res, "loopring_submitOrder", req); err == nil {
+		t.Errorf("unsigned order accepted: %s", res)
+	} else {
+		t.Logf("submit order rejected: %s", err.Error())
+	}
 }
@@ -0,0 +1,246 @@
+/*
+
+  Copyright 2017 Loopring Project Ltd (Loopring Foundation).
+
+  Licensed under the Apache License, Version 2.0 (the "License");
+  you may not use this file except in compliance with the License.
+  You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+  Unless required by applicable law or agreed to in writing, software
+  distributed under the License is distributed on an "AS IS" BASIS,
+  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+  See the License for the specific language governing permissions and
+  limitations under the License.
+
+*/
+
+package gateway
+
+import (
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/Loopring/relay/config"
+	"github.com/Loopring/relay/log"
+)
+
+// 未配置时的默认值
+const (
+	defaultPeerMuteScore     = -100
+	defaultPeerMuteTime      = 10 * 60
+	defaultPeerScoreRecovery = 10
+)
+
+// score of each outcome of a message received from a peer, honest peers relay
+// duplicated and rejected orders too, so they cost much less than invalid data
+const (
+	peerScoreAccepted   = 1
+	peerScoreDuplicated = -0.1
+	peerScoreRejected   = -1
+	peerScoreInvalid    = -10
+	peerScoreMax        = 100
+)
+
+// 不在禁言中且分数已恢复的peer空闲peerIdleTime后删除，每peerSweepInterval检查一次
+const (
+	peerIdleTime      = 30 * time.Minute
+	peerSweepInterval = time.Minute
+)
+
+type peerOutcome int
+
+const (
+	peerAccepted peerOutcome = iota
+	peerDuplicated
+	peerRejected
+	peerInvalid
+)
+
+// PeerStatus is the reputation of a peer, peers signing envelopes are identified by relay address,
+// others by transport id
+type PeerStatus struct {
+	Peer       string  `json:"peer"`
+	Relay      string  `json:"relay,omitempty"`
+	Version    string  `json:"version,omitempty"`
+	Score      float64 `json:"score"`
+	Accepted   int64   `json:"accepted"`
+	Duplicated int64   `json:"duplicated"`
+	Rejected   int64   `json:"rejected"`
+	Invalid    int64   `json:"invalid"`
+	Dropped    int64   `json:"dropped"`
+	Muted      bool    `json:"muted"`
+	MutedUntil int64   `json:"mutedUntil,omitempty"`
+	LastSeen   int64   `json:"lastSeen"`
+
+	updatedAt time.Time
+}
+
+// peerScorer 根据消息处理结果给peer打分，分数低于muteScore的peer在muteTime内的消息直接丢弃。
+// 无法解析的数据和签名错误扣分最多，负分随时间恢复。
+// 验证过信封的peer按relay地址记分，更换连接后仍保留分数和禁言
+type peerScorer struct {
+	muteScore float64
+	muteTime  time.Duration
+	recovery  float64
+	peers     map[string]*PeerStatus
+	swept     time.Time
+	mtx       sync.Mutex
+}
+
+func newPeerScorer(options *config.IpfsOptions) *peerScorer {
+	s := &peerScorer{
+		muteScore: options.PeerMuteScore,
+		muteTime:  time.Duration(options.PeerMuteTime) * time.Second,
+		recovery:  options.PeerScoreRecovery,
+		peers:     make(map[string]*PeerStatus),
+	}
+	if s.muteScore == 0 {
+		s.muteScore = defaultPeerMuteScore
+	}
+	if s.muteTime <= 0 {
+		s.muteTime = defaultPeerMuteTime * time.Second
+	}
+	if s.recovery <= 0 {
+		s.recovery = defaultPeerScoreRecovery
+	}
+	return s
+}
+
+// get returns the status of peer after recovering its score and expiring its mute, mtx must be held
+func (s *peerScorer) get(peer string, now time.Time) *PeerStatus {
+	if now.Sub(s.swept) >= peerSweepInterval {
+		s.sweep(now)
+	}
+	p, ok := s.peers[peer]
+	if !ok {
+		p = &PeerStatus{Peer: peer, updatedAt: now}
+		s.peers[peer] = p
+	}
+	s.refresh(p, now)
+	return p
+}
+
+// refresh recovers the score of p and expires its mute, mtx must be held
+func (s *peerScorer) refresh(p *PeerStatus, now time.Time) {
+	if p.Muted && now.Unix() >= p.MutedUntil {
+		p.Muted = false
+		p.MutedUntil = 0
+		p.Score = 0
+		log.Infof("gateway,peer %s relay %s unmuted", p.Peer, p.Relay)
+	}
+	if p.Score < 0 {
+		p.Score += s.recovery * now.Sub(p.updatedAt).Minutes()
+		if p.Score > 0 {
+			p.Score = 0
+		}
+	}
+	p.updatedAt = now
+}
+
+// sweep deletes peers idle for peerIdleTime which are neither muted nor below score 0, mtx must be held
+func (s *peerScorer) sweep(now time.Time) {
+	for key, p := range s.peers {
+		s.refresh(p, now)
+		if !p.Muted && p.Score >= 0 && now.Unix()-p.LastSeen >= int64(peerIdleTime/time.Second) {
+			delete(s.peers, key)
+		}
+	}
+	s.swept = now
+}
+
+// allow reports whether messages of peer should be handled, dropped messages are counted.
+// peers never scored are allowed without being recorded
+func (s *peerScorer) allow(peer string) bool {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+
+	if _, ok := s.peers[peer]; !ok {
+		return true
+	}
+	now := time.Now()
+	p := s.get(peer, now)
+	p.LastSeen = now.Unix()
+	if p.Muted {
+		p.Dropped++
+		return false
+	}
+	return true
+}
+
+// identify returns the peer key of the relay signing envelope received from transport id from,
+// the relay keeps its score and mute when it reconnects with another transport id
+func (s *peerScorer) identify(from string, envelope *RelayEnvelope) string {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+
+	now := time.Now()
+	relay := envelope.Relay.Hex()
+	p := s.get(relay, now)
+	p.Peer = from
+	p.Relay = relay
+	p.Version = envelope.Version
+	p.LastSeen = now.Unix()
+	return relay
+}
+
+func (s *peerScorer) record(peer string, outcome peerOutcome) {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+
+	now := time.Now()
+	p := s.get(peer, now)
+	p.LastSeen = now.Unix()
+	switch outcome {
+	case peerAccepted:
+		p.Accepted++
+		p.Score += peerScoreAccepted
+	case peerDuplicated:
+		p.Duplicated++
+		p.Score += peerScoreDuplicated
+	case peerRejected:
+		p.Rejected++
+		p.Score += peerScoreRejected
+	case peerInvalid:
+		p.Invalid++
+		p.Score += peerScoreInvalid
+	}
+	if p.Score > peerScoreMax {
+		p.Score = peerScoreMax
+	}
+	if !p.Muted && p.Score < s.muteScore {
+		p.Muted = true
+		p.MutedUntil = now.Add(s.muteTime).Unix()
+		log.Warnf("gateway,peer %s relay %s muted until %d, score %f", p.Peer, p.Relay, p.MutedUntil, p.Score)
+	}
+}
+
+// statuses returns all peers sorted by score, best first
+func (s *peerScorer) statuses() []PeerStatus {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+
+	now := time.Now()
+	res := make([]PeerStatus, 0, len(s.peers))
+	for _, p := range s.peers {
+		s.refresh(p, now)
+		res = append(res, *p)
+	}
+	sort.Slice(res, func(i, j int) bool {
+		if res[i].Score != res[j].Score {
+			return res[i].Score > res[j].Score
+		}
+		return res[i].Peer < res[j].Peer
+	})
+	return res
+}
+
+// GetPeers returns reputation of peers which sent broadcast messages since relay started
+func GetPeers() []PeerStatus {
+	if gateway.peers == nil {
+		return []PeerStatus{}
+	}
+	return gateway.peers.statuses()
+}
@@ -0,0 +1,128 @@
+/*
+
+  Copyright 2017 Loopring Project Ltd (Loopring Foundation).
+
+  Licensed under the Apache License, Version 2.0 (the "License");
+  you may not use this file except in compliance with the License.
+  You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+  Unless required by applicable law or agreed to in writing, software
+  distributed under the License is distributed on an "AS IS" BASIS,
+  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+  See the License for the specific language governing permissions and
+  limitations under the License.
+
+*/
+
+package gateway
+
+import (
+	"math"
+	"testing"
+	"time"
+
+	"github.com/Loopring/relay/config"
+	"github.com/ethereum/go-ethereum/common"
+)
+
+func TestPeerScorer(t *testing.T) {
+	s := newPeerScorer(&config.IpfsOptions{PeerMuteScore: -25, PeerMuteTime: 60, PeerScoreRecovery: 10})
+	peer := "QmPeer"
+
+	if !s.allow(peer) || len(s.statuses()) != 0 {
+		t.Fatal("unknown peer should be allowed without being recorded")
+	}
+
+	// 重复和被过滤的订单少量扣分
+	for i := 0; i < 10; i++ {
+		s.record(peer, peerDuplicated)
+		s.record(peer, peerRejected)
+	}
+	if p := s.statuses()[0]; math.Abs(p.Score+11) > 1e-3 || p.Duplicated != 10 || p.Rejected != 10 || p.Muted {
+		t.Fatalf("duplicates and rejections should cost 11, got %+v", p)
+	}
+	s.peers[peer].Score = 0
+
+	s.record(peer, peerInvalid)
+	s.record(peer, peerInvalid)
+	if !s.allow(peer) {
+		t.Fatal("peer above mute score should be allowed")
+	}
+	s.record(peer, peerInvalid)
+	if s.allow(peer) {
+		t.Fatal("peer below mute score should be muted")
+	}
+	p := s.statuses()[0]
+	if !p.Muted || p.Invalid != 3 || p.Dropped != 1 {
+		t.Fatalf("unexpected status %+v", p)
+	}
+
+	// 禁言到期后分数清零
+	now := time.Now()
+	s.peers[peer].MutedUntil = now.Unix() - 1
+	if p := s.get(peer, now); p.Muted || p.Score != 0 {
+		t.Fatalf("mute should expire, got %+v", p)
+	}
+
+	// 负分按分钟恢复，不超过0
+	s.peers[peer].Score = -20
+	if p := s.get(peer, now.Add(time.Minute)); p.Score != -10 {
+		t.Fatalf("score should recover to -10, got %f", p.Score)
+	}
+	if p := s.get(peer, now.Add(3*time.Minute)); p.Score != 0 {
+		t.Fatalf("score should recover to 0, got %f", p.Score)
+	}
+
+	// 正分不衰减，最高peerScoreMax
+	for i := 0; i < 2*peerScoreMax; i++ {
+		s.record(peer, peerAccepted)
+	}
+	s.peers[peer].LastSeen = now.Add(time.Hour).Unix()
+	if p := s.get(peer, now.Add(time.Hour)); p.Score != peerScoreMax {
+		t.Fatalf("score should be capped at %d, got %f", peerScoreMax, p.Score)
+	}
+}
+
+func TestPeerScorerRelay(t *testing.T) {
+	s := newPeerScorer(&config.IpfsOptions{PeerMuteScore: -25, PeerMuteTime: 60, PeerScoreRecovery: 10})
+	envelope := &RelayEnvelope{Relay: common.HexToAddress("0x01"), Version: "v1.0"}
+
+	peer := s.identify("10.0.0.1:50001", envelope)
+	for i := 0; i < 3; i++ {
+		s.record(peer, peerInvalid)
+	}
+	if s.allow(peer) {
+		t.Fatal("relay below mute score should be muted")
+	}
+
+	// 更换连接后仍按relay地址禁言
+	if !s.allow("10.0.0.1:50002") {
+		t.Fatal("new transport id is not scored before the envelope is verified")
+	}
+	if peer := s.identify("10.0.0.1:50002", envelope); s.allow(peer) {
+		t.Fatal("relay should stay muted after reconnecting")
+	}
+	if p := s.statuses()[0]; p.Peer != "10.0.0.1:50002" || p.Relay != envelope.Relay.Hex() || !p.Muted {
+		t.Fatalf("unexpected status %+v", p)
+	}
+}
+
+func TestPeerScorerSweep(t *testing.T) {
+	s := newPeerScorer(&config.IpfsOptions{PeerMuteScore: -25, PeerMuteTime: 3600, PeerScoreRecovery: 1})
+	now := time.Now()
+	s.record("idle", peerAccepted)
+	s.record("negative", peerInvalid)
+	s.record("muted", peerInvalid)
+	s.record("muted", peerInvalid)
+	s.record("muted", peerInvalid)
+	s.record("active", peerAccepted)
+
+	later := now.Add(peerIdleTime)
+	s.peers["active"].LastSeen = later.Unix()
+	s.sweep(later)
+	if len(s.peers) != 2 || s.peers["muted"] == nil || s.peers["active"] == nil {
+		t.Fatalf("only idle peers with recovered score should be deleted, left %v", s.peers)
+	}
+}
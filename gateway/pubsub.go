@@ -0,0 +1,250 @@
+/*
+
+  Copyright 2017 Loopring Project Ltd (Loopring Foundation).
+
+  Licensed under the Apache License, Version 2.0 (the "License");
+  you may not use this file except in compliance with the License.
+  You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+  Unless required by applicable law or agreed to in writing, software
+  distributed under the License is distributed on an "AS IS" BASIS,
+  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+  See the License for the specific language governing permissions and
+  limitations under the License.
+
+*/
+
+package gateway
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/Loopring/relay/config"
+	"github.com/Loopring/relay/eventemiter"
+	"github.com/Loopring/relay/gateway/broadcast"
+	"github.com/Loopring/relay/log"
+	"github.com/Loopring/relay/market/util"
+	"github.com/Loopring/relay/types"
+)
+
+// placeholders of IpfsOptions.MarketTopic
+const (
+	topicVersionHolder = "{version}"
+	topicMarketHolder  = "{market}"
+)
+
+// NewOrderSubscriber subscribes ListenTopics on the transport selected in IpfsOptions,
+// and topics of served markets if MarketTopic is set, orders received are handled by gateway
+func NewOrderSubscriber(options *config.IpfsOptions) broadcast.Subscriber {
+	sub, err := broadcast.NewSubscriber(options, handleOrderMessage)
+	if err != nil {
+		log.Fatalf("gateway,create order subscriber error:%s", err.Error())
+	}
+	if options.SyncTopic != "" {
+		if err := sub.Register(options.SyncTopic); err != nil {
+			log.Fatalf("gateway,register sync topic error:%s", err.Error())
+		}
+	}
+	if options.MarketTopic == "" {
+		return sub
+	}
+
+	markets := options.Markets
+	if len(markets) == 0 {
+		markets = util.AllMarkets
+
+		// 未指定市场时订阅全部市场，新注册token的市场也需要订阅
+		watcher := &eventemitter.Watcher{Concurrent: false, Handle: func(input eventemitter.EventData) error {
+			evt := input.(*types.TokenRegisterEvent)
+			registerMarketTopics(sub, options, newTokenMarkets(evt.Symbol))
+			return nil
+		}}
+		eventemitter.On(eventemitter.TokenRegistered, watcher)
+	}
+	registerMarketTopics(sub, options, markets)
+	return sub
+}
+
+func registerMarketTopics(sub broadcast.Subscriber, options *config.IpfsOptions, markets []string) {
+	for _, topic := range marketTopics(options.MarketTopic, markets) {
+		if containsTopic(options.ListenTopics, topic) {
+			continue
+		}
+		if err := sub.Register(topic); err != nil {
+			log.Errorf("gateway,subscribe topic %s error:%s", topic, err.Error())
+		} else {
+			log.Debugf("gateway,subscribe topic %s", topic)
+		}
+	}
+}
+
+// marketTopics returns topics of markets for every contract version
+func marketTopics(template string, markets []string) []string {
+	topics := make([]string, 0)
+	for _, version := range contractVersions() {
+		for _, market := range markets {
+			topic := marketTopic(template, version, strings.ToUpper(market))
+			if !containsTopic(topics, topic) {
+				topics = append(topics, topic)
+			}
+		}
+	}
+	return topics
+}
+
+func marketTopic(template, version, market string) string {
+	return strings.NewReplacer(topicVersionHolder, version, topicMarketHolder, market).Replace(template)
+}
+
+// newTokenMarkets 与util.TokenRegister生成市场的方式一致，两者并发执行所以不能读AllMarkets
+func newTokenMarkets(symbol string) []string {
+	markets := make([]string, 0)
+	for _, v := range util.SupportMarkets {
+		markets = append(markets, strings.ToUpper(symbol)+"-"+v.Symbol)
+	}
+	return markets
+}
+
+func containsTopic(topics []string, topic string) bool {
+	for _, v := range topics {
+		if v == topic {
+			return true
+		}
+	}
+	return false
+}
+
+// handleOrderMessage opens the envelope of msg and handles the order in it,
+// the result is scored to the peer sending it
+func handleOrderMessage(msg *broadcast.Message) {
+	//msg.Data have to contain two char: '{' and '}'
+	if len(msg.Data) <= 2 {
+		return
+	}
+	if !gateway.peers.allow(msg.From) {
+		return
+	}
+
+	data, envelope, err := openMessage(msg.Data, gateway.requireEnvelope, gateway.envelopeMaxAge)
+	if err != nil {
+		log.Errorf("gateway,drop message from %s of topic %s:%s", msg.From, msg.Topic, err.Error())
+		gateway.peers.record(msg.From, peerInvalid)
+		return
+	}
+	// 信封验证通过后才能确定发送者，之后按relay地址记分
+	peer := msg.From
+	if envelope != nil {
+		if gateway.signEnvelope && envelope.Relay == gateway.relayAccount {
+			return
+		}
+		peer = gateway.peers.identify(msg.From, envelope)
+		if !gateway.peers.allow(peer) {
+			return
+		}
+	}
+	if gateway.syncer != nil && msg.Topic == gateway.syncer.topic {
+		gateway.syncer.handle(peer, data)
+		return
+	}
+
+	ord := &types.Order{}
+	if err := ord.UnmarshalJSON(data); err != nil {
+		log.Errorf("gateway,failed to accept data from %s of topic %s:%s", msg.From, msg.Topic, err.Error())
+		gateway.peers.record(peer, peerInvalid)
+		return
+	}
+	log.Debugf("gateway,accept order %s from %s of topic %s", ord.Hash.Hex(), msg.From, msg.Topic)
+
+	isNew, err := processOrder(ord, orderFromPeer, "")
+	scoreOrderResult(peer, isNew, err)
+}
+
+// scoreOrderResult scores peer by the result of handling an order it sent,
+// malformed orders and invalid signatures are scored as invalid data
+func scoreOrderResult(peer string, isNew bool, err error) {
+	if err == nil {
+		if isNew {
+			gateway.peers.record(peer, peerAccepted)
+		} else {
+			gateway.peers.record(peer, peerDuplicated)
+		}
+		return
+	}
+
+	code := ErrCodeInternal
+	switch e := err.(type) {
+	case *FilterRejectedError:
+		code = e.ErrorCode()
+	case *JsonrpcError:
+		code = e.Code
+	}
+	switch code {
+	case ErrCodeInternal:
+		// 本地的错误不计入peer的分数
+	case ErrCodeInvalidOrder, ErrCodeInvalidSignature:
+		gateway.peers.record(peer, peerInvalid)
+	default:
+		gateway.peers.record(peer, peerRejected)
+	}
+}
+
+// orderTopic 设置了MarketTopic时按订单的市场和合约版本路由，否则发送到BroadcastTopics[0]
+func (g *Gateway) orderTopic(order *types.Order) (string, error) {
+	if g.marketTopic == "" {
+		if len(g.broadcastTopics) == 0 {
+			return "", errors.New("gateway,no broadcast topic")
+		}
+		return g.broadcastTopics[0], nil
+	}
+
+	market, err := util.WrapMarketByAddress(order.TokenS.Hex(), order.TokenB.Hex())
+	if err != nil {
+		return "", err
+	}
+	version := util.ContractVersion(order.Protocol)
+	if version == "" {
+		return "", fmt.Errorf("gateway,unsupported protocol %s", order.Protocol.Hex())
+	}
+	return marketTopic(g.marketTopic, version, market), nil
+}
+
+func (g *Gateway) publishOrder(order types.Order) error {
+	topic, err := g.orderTopic(&order)
+	if err != nil {
+		return err
+	}
+	orderJson, err := order.MarshalJSON()
+	if err != nil {
+		return err
+	}
+	return g.publish(topic, orderJson)
+}
+
+// StopBroadcaster releases the transport orders are published through, call it after StopRebroadcaster
+func StopBroadcaster() {
+	if gateway.broadcaster != nil {
+		gateway.broadcaster.Stop()
+	}
+}
+
+// publish wraps data in a signed envelope if RelayAccount is set
+func (g *Gateway) publish(topic string, data []byte) error {
+	if !g.signEnvelope {
+		return g.broadcaster.Publish(topic, data)
+	}
+
+	envelope, err := newRelayEnvelope(g.relayAccount, data)
+	if err != nil {
+		return err
+	}
+	envelopeJson, err := json.Marshal(envelope)
+	if err != nil {
+		return err
+	}
+	return g.broadcaster.Publish(topic, envelopeJson)
+}
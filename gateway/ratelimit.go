@@ -0,0 +1,151 @@
+/*
+
+  Copyright 2017 Loopring Project Ltd (Loopring Foundation).
+
+  Licensed under the Apache License, Version 2.0 (the "License");
+  you may not use this file except in compliance with the License.
+  You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+  Unless required by applicable law or agreed to in writing, software
+  distributed under the License is distributed on an "AS IS" BASIS,
+  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+  See the License for the specific language governing permissions and
+  limitations under the License.
+
+*/
+
+package gateway
+
+import (
+	"github.com/Loopring/relay/config"
+	"github.com/Loopring/relay/types"
+	"github.com/Loopring/relay/usermanager"
+	"net"
+	"sync"
+	"time"
+)
+
+// 限流桶数量上限，超过后清理已经装满的桶
+const maxRateLimitBuckets = 100000
+
+const (
+	ownerRateLimitName = "owner_rate_limit"
+	ipRateLimitName    = "ip_rate_limit"
+)
+
+type tokenBucket struct {
+	tokens float64
+	last   time.Time
+}
+
+// rateLimiter is a token bucket rate limiter keyed by owner or ip,
+// a nil rateLimiter allows everything.
+type rateLimiter struct {
+	rate    float64
+	burst   float64
+	buckets map[string]*tokenBucket
+	mtx     sync.Mutex
+}
+
+func newRateLimiter(rate float64, burst int) *rateLimiter {
+	if rate <= 0 {
+		return nil
+	}
+	if burst < 1 {
+		burst = 1
+	}
+	return &rateLimiter{rate: rate, burst: float64(burst), buckets: make(map[string]*tokenBucket)}
+}
+
+func (l *rateLimiter) allow(key string) bool {
+	if l == nil {
+		return true
+	}
+
+	l.mtx.Lock()
+	defer l.mtx.Unlock()
+
+	now := time.Now()
+	b, ok := l.buckets[key]
+	if !ok {
+		if len(l.buckets) >= maxRateLimitBuckets {
+			l.clean(now)
+		}
+		b = &tokenBucket{tokens: l.burst, last: now}
+		l.buckets[key] = b
+	}
+
+	b.tokens += now.Sub(b.last).Seconds() * l.rate
+	if b.tokens > l.burst {
+		b.tokens = l.burst
+	}
+	b.last = now
+
+	if b.tokens < 1 {
+		return false
+	}
+	b.tokens -= 1
+	return true
+}
+
+func (l *rateLimiter) clean(now time.Time) {
+	for k, b := range l.buckets {
+		if b.tokens+now.Sub(b.last).Seconds()*l.rate >= l.burst {
+			delete(l.buckets, k)
+		}
+	}
+}
+
+// orderRateLimiter limits order intake per owner and per remote ip,
+// owners in white list use separate limits.
+type orderRateLimiter struct {
+	owner          *rateLimiter
+	ip             *rateLimiter
+	whiteListOwner *rateLimiter
+	whiteListIp    *rateLimiter
+	um             usermanager.UserManager
+}
+
+func newOrderRateLimiter(options *config.GateWayOptions, um usermanager.UserManager) *orderRateLimiter {
+	return &orderRateLimiter{
+		owner:          newRateLimiter(options.RateLimit.OwnerRate, options.RateLimit.OwnerBurst),
+		ip:             newRateLimiter(options.RateLimit.IpRate, options.RateLimit.IpBurst),
+		whiteListOwner: newRateLimiter(options.WhiteListRateLimit.OwnerRate, options.WhiteListRateLimit.OwnerBurst),
+		whiteListIp:    newRateLimiter(options.WhiteListRateLimit.IpRate, options.WhiteListRateLimit.IpBurst),
+		um:             um,
+	}
+}
+
+func (l *orderRateLimiter) limiters(o *types.Order) (ownerLimiter, ipLimiter *rateLimiter) {
+	if l.um != nil && l.um.InWhiteList(o.Owner) {
+		return l.whiteListOwner, l.whiteListIp
+	}
+	return l.owner, l.ip
+}
+
+// checkIp reports whether the remote ip is allowed, orders received from ipfs have no remote address and are not limited by ip.
+func (l *orderRateLimiter) checkIp(o *types.Order, remoteAddr string) bool {
+	_, ipLimiter := l.limiters(o)
+	ip := remoteIp(remoteAddr)
+	return ip == "" || ipLimiter.allow(ip)
+}
+
+// checkOwner reports whether the owner is allowed, it must be called after the signature
+// is verified, otherwise anyone can spend the bucket of an owner.
+func (l *orderRateLimiter) checkOwner(o *types.Order) bool {
+	ownerLimiter, _ := l.limiters(o)
+	return ownerLimiter.allow(o.Owner.Hex())
+}
+
+func remoteIp(remoteAddr string) string {
+	if remoteAddr == "" {
+		return ""
+	}
+	host, _, err := net.SplitHostPort(remoteAddr)
+	if err != nil {
+		return remoteAddr
+	}
+	return host
+}
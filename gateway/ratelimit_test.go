@@ -0,0 +1,78 @@
+/*
+
+  Copyright 2017 Loopring Project Ltd (Loopring Foundation).
+
+  Licensed under the Apache License, Version 2.0 (the "License");
+  you may not use this file except in compliance with the License.
+  You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+  Unless required by applicable law or agreed to in writing, software
+  distributed under the License is distributed on an "AS IS" BASIS,
+  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+  See the License for the specific language governing permissions and
+  limitations under the License.
+
+*/
+
+package gateway
+
+import (
+	"testing"
+	"time"
+
+	"github.com/Loopring/relay/config"
+	"github.com/Loopring/relay/types"
+	"github.com/ethereum/go-ethereum/common"
+)
+
+func TestRateLimiter(t *testing.T) {
+	var unlimited *rateLimiter
+	if !unlimited.allow("a") || newRateLimiter(0, 10) != nil {
+		t.Fatalf("nil rate limiter should allow everything")
+	}
+
+	l := newRateLimiter(1, 3)
+	for i := 0; i < 3; i++ {
+		if !l.allow("a") {
+			t.Fatalf("request %d should be allowed by burst", i)
+		}
+	}
+	if l.allow("a") {
+		t.Fatalf("request after burst should be limited")
+	}
+	if !l.allow("b") {
+		t.Fatalf("buckets should be separated by key")
+	}
+
+	// 2秒后恢复2个token，不超过burst
+	l.buckets["a"].last = l.buckets["a"].last.Add(-2 * time.Second)
+	if !l.allow("a") || !l.allow("a") || l.allow("a") {
+		t.Fatalf("bucket should refill by rate")
+	}
+	l.buckets["a"].last = l.buckets["a"].last.Add(-time.Hour)
+	l.allow("a")
+	if l.buckets["a"].tokens != 2 {
+		t.Fatalf("bucket should be capped by burst, got %f tokens", l.buckets["a"].tokens)
+	}
+}
+
+func TestOrderRateLimiter(t *testing.T) {
+	options := &config.GateWayOptions{RateLimit: config.RateLimitOptions{OwnerRate: 1, OwnerBurst: 1, IpRate: 1, IpBurst: 2}}
+	l := newOrderRateLimiter(options, nil)
+	o := &types.Order{Owner: common.HexToAddress("0x1")}
+
+	if !l.checkIp(o, "10.0.0.1:1000") || !l.checkIp(o, "10.0.0.1:2000") || l.checkIp(o, "10.0.0.1:3000") {
+		t.Fatalf("ip should be limited regardless of port")
+	}
+	if !l.checkIp(o, "") || !l.checkIp(o, "") {
+		t.Fatalf("orders without remote address should not be limited by ip")
+	}
+	if !l.checkOwner(o) || l.checkOwner(o) {
+		t.Fatalf("owner should be limited by burst")
+	}
+	if !l.checkOwner(&types.Order{Owner: common.HexToAddress("0x2")}) {
+		t.Fatalf("other owner should not be limited")
+	}
+}
@@ -0,0 +1,125 @@
+/*
+
+  Copyright 2017 Loopring Project Ltd (Loopring Foundation).
+
+  Licensed under the Apache License, Version 2.0 (the "License");
+  you may not use this file except in compliance with the License.
+  You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+  Unless required by applicable law or agreed to in writing, software
+  distributed under the License is distributed on an "AS IS" BASIS,
+  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+  See the License for the specific language governing permissions and
+  limitations under the License.
+
+*/
+
+package gateway
+
+import (
+	"math"
+	"time"
+
+	"github.com/Loopring/relay/log"
+)
+
+const (
+	// 未配置时订单第二次广播在第一次之后1分钟，之后间隔依次翻倍
+	defaultBroadcastInterval = 60
+
+	// 每轮最多重新广播的订单数
+	rebroadcastBatchSize = 500
+)
+
+// rebroadcaster 定期重新广播未完成的订单，避免只广播一次的订单因peer离线而丢失
+type rebroadcaster struct {
+	interval int64
+	stop     chan struct{}
+}
+
+// StartRebroadcaster re-publishes NEW/PARTIAL orders submitted to this relay through json-rpc
+// and with remaining volume in background,
+// the n-th broadcast of an order is interval*(2^(n-1)-1) seconds after it's created,
+// a failed broadcast counts too, so an order that can never be published is dropped after maxBroadcastTime rounds
+func StartRebroadcaster() {
+	if !gateway.isBroadcast || gateway.maxBroadcastTime <= 0 || gateway.rebroadcaster != nil {
+		return
+	}
+	r := &rebroadcaster{interval: gateway.broadcastInterval, stop: make(chan struct{})}
+	if r.interval <= 0 {
+		r.interval = defaultBroadcastInterval
+	}
+	gateway.rebroadcaster = r
+
+	go func() {
+		for {
+			select {
+			case <-time.After(time.Duration(r.interval) * time.Second):
+				r.rebroadcast()
+			case <-r.stop:
+				return
+			}
+		}
+	}()
+}
+
+func StopRebroadcaster() {
+	if gateway.rebroadcaster != nil {
+		close(gateway.rebroadcaster.stop)
+		gateway.rebroadcaster = nil
+	}
+}
+
+func (r *rebroadcaster) rebroadcast() {
+	dueBefore := broadcastSchedule(time.Now().Unix(), r.interval, gateway.maxBroadcastTime)
+	states, err := gateway.om.GetOrdersForBroadcast(dueBefore, rebroadcastBatchSize)
+	if err != nil {
+		log.Errorf("gateway,get orders to rebroadcast error:%s", err.Error())
+		return
+	}
+
+	count := 0
+	for _, state := range states {
+		hash := state.RawOrder.Hash
+		// 剩余量不足的订单不再广播
+		if gateway.om.IsOrderFullFinished(&state) {
+			if err := gateway.om.UpdateBroadcastTimeByHash(hash, gateway.maxBroadcastTime); err != nil {
+				log.Errorf("gateway,update broadcast time of order %s error:%s", hash.Hex(), err.Error())
+			}
+			continue
+		}
+		// 发布失败也计入广播次数，在下一次到期时重试，
+		// 否则一直发布失败的订单每轮都排在最前面，占满批次使后面的订单无法广播
+		published := true
+		if err := gateway.publishOrder(state.RawOrder); err != nil {
+			log.Errorf("gateway,rebroadcast order %s failed:%s", hash.Hex(), err.Error())
+			published = false
+		}
+		if err := gateway.om.UpdateBroadcastTimeByHash(hash, state.BroadcastTime+1); err != nil {
+			log.Errorf("gateway,update broadcast time of order %s error:%s", hash.Hex(), err.Error())
+			continue
+		}
+		if published {
+			count++
+		}
+	}
+	if count > 0 {
+		log.Infof("gateway,rebroadcast %d orders", count)
+	}
+}
+
+// broadcastSchedule returns the latest create time of orders broadcast n times which are due at now,
+// an order is broadcast for the (n+1)-th time interval*(2^n-1) seconds after it's created
+func broadcastSchedule(now, interval int64, maxBroadcastTime int) []int64 {
+	dueBefore := make([]int64, 0, maxBroadcastTime)
+	for n := 0; n < maxBroadcastTime; n++ {
+		// 延迟超出int64时该次及之后的广播永远不会到期
+		if interval > math.MaxInt64>>uint(n) {
+			break
+		}
+		dueBefore = append(dueBefore, now-interval*((1<<uint(n))-1))
+	}
+	return dueBefore
+}
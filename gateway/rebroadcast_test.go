@@ -0,0 +1,92 @@
+/*
+
+  Copyright 2017 Loopring Project Ltd (Loopring Foundation).
+
+  Licensed under the Apache License, Version 2.0 (the "License");
+  you may not use this file except in compliance with the License.
+  You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+  Unless required by applicable law or agreed to in writing, software
+  distributed under the License is distributed on an "AS IS" BASIS,
+  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+  See the License for the specific language governing permissions and
+  limitations under the License.
+
+*/
+
+package gateway
+
+import (
+	"errors"
+	"math"
+	"testing"
+
+	"github.com/Loopring/relay/types"
+	"github.com/ethereum/go-ethereum/common"
+)
+
+func TestBroadcastSchedule(t *testing.T) {
+	now := int64(10000)
+	dueBefore := broadcastSchedule(now, 60, 5)
+	expected := []int64{10000, 9940, 9820, 9580, 9100}
+	if len(dueBefore) != len(expected) {
+		t.Fatalf("expected %d broadcasts, got %v", len(expected), dueBefore)
+	}
+	for n, v := range expected {
+		if dueBefore[n] != v {
+			t.Errorf("broadcast %d:expected create time before %d, got %d", n+1, v, dueBefore[n])
+		}
+	}
+
+	// 相邻两次广播的间隔依次翻倍
+	for n := 2; n < len(dueBefore); n++ {
+		if dueBefore[n-1]-dueBefore[n] != 2*(dueBefore[n-2]-dueBefore[n-1]) {
+			t.Errorf("spacing before broadcast %d should double:%v", n+1, dueBefore)
+		}
+	}
+
+	if len(broadcastSchedule(now, 60, 0)) != 0 {
+		t.Errorf("no broadcast should be due when max broadcast time is 0")
+	}
+
+	// 延迟溢出之后的广播不再到期
+	dueBefore = broadcastSchedule(now, math.MaxInt64>>2+1, 5)
+	if len(dueBefore) != 2 {
+		t.Errorf("expected broadcasts to stop before the delay overflows, got %v", dueBefore)
+	}
+}
+
+func TestRebroadcastFailedOrders(t *testing.T) {
+	om := &testOrderManager{orders: make(map[common.Hash]*types.OrderState)}
+	defer setupTestGateway(om)()
+	broadcaster := &testBroadcaster{err: errors.New("peer offline")}
+	gateway.broadcaster = broadcaster
+	gateway.broadcastTopics = []string{"orders"}
+	gateway.maxBroadcastTime = 2
+
+	owner := common.HexToAddress("0x10")
+	for salt := int64(1); salt <= 2; salt++ {
+		order := types.ToOrder(newTestOrderRequest(owner, salt))
+		order.Hash = order.GenerateHash()
+		om.orders[order.Hash] = &types.OrderState{RawOrder: *order}
+	}
+	r := &rebroadcaster{interval: defaultBroadcastInterval}
+
+	// 发布失败的订单也推进广播次数，达到上限后不再被查出
+	for round := 1; round <= gateway.maxBroadcastTime; round++ {
+		r.rebroadcast()
+		for hash, state := range om.orders {
+			if state.BroadcastTime != round {
+				t.Errorf("round %d:order %s broadcast time %d", round, hash.Hex(), state.BroadcastTime)
+			}
+		}
+	}
+	if states, _ := om.GetOrdersForBroadcast(broadcastSchedule(0, defaultBroadcastInterval, gateway.maxBroadcastTime), rebroadcastBatchSize); len(states) != 0 {
+		t.Errorf("orders failed %d times should not be due, got %d", gateway.maxBroadcastTime, len(states))
+	}
+	if broadcaster.published != 0 {
+		t.Errorf("expected no order published, got %d", broadcaster.published)
+	}
+}
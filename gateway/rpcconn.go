@@ -0,0 +1,215 @@
+/*
+
+  Copyright 2017 Loopring Project Ltd (Loopring Foundation).
+
+  Licensed under the Apache License, Version 2.0 (the "License");
+  you may not use this file except in compliance with the License.
+  You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+  Unless required by applicable law or agreed to in writing, software
+  distributed under the License is distributed on an "AS IS" BASIS,
+  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+  See the License for the specific language governing permissions and
+  limitations under the License.
+
+*/
+
+package gateway
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/Loopring/relay/log"
+	"github.com/ethereum/go-ethereum/rpc"
+	"golang.org/x/net/websocket"
+)
+
+// rpc server只把方法返回错误的message写入响应，也不向方法传递连接信息。
+// relay用rpcConn包装每个http请求和websocket连接的codec：
+//  - 方法通过ctx中的notifier找到所在的rpcConn，得到remote address
+//  - 方法返回的错误按key保存在所在的rpcConn中，message带上key，写错误响应时按key取回错误，补上code和data
+
+// dataError is an rpc.Error with data member of JSON-RPC error object
+type dataError interface {
+	rpc.Error
+	ErrorData() interface{}
+}
+
+// rpcConn is the codec of a http request or websocket connection
+type rpcConn struct {
+	rpc.ServerCodec
+	remoteAddr string
+	isHTTP     bool
+
+	errs   map[string]rpc.Error
+	errSeq uint64
+	errMtx sync.Mutex
+}
+
+var (
+	rpcConns   = make(map[<-chan interface{}]*rpcConn)
+	rpcConnMtx sync.RWMutex
+)
+
+func newRpcConn(codec rpc.ServerCodec, remoteAddr string, isHTTP bool) *rpcConn {
+	c := &rpcConn{ServerCodec: codec, remoteAddr: remoteAddr, isHTTP: isHTTP, errs: make(map[string]rpc.Error)}
+	rpcConnMtx.Lock()
+	rpcConns[codec.Closed()] = c
+	rpcConnMtx.Unlock()
+	return c
+}
+
+func (c *rpcConn) release() {
+	rpcConnMtx.Lock()
+	delete(rpcConns, c.ServerCodec.Closed())
+	rpcConnMtx.Unlock()
+}
+
+// rpcConnFromContext returns the connection of the request being served
+func rpcConnFromContext(ctx context.Context) (*rpcConn, bool) {
+	notifier, ok := rpc.NotifierFromContext(ctx)
+	if !ok {
+		return nil, false
+	}
+	rpcConnMtx.RLock()
+	defer rpcConnMtx.RUnlock()
+	c, ok := rpcConns[notifier.Closed()]
+	return c, ok
+}
+
+// rpcError is deferred by rpc methods, an error with code is kept in the connection serving ctx
+// and replaced by an error whose message ends with its key
+func rpcError(ctx context.Context, err *error) {
+	e, ok := (*err).(rpc.Error)
+	if !ok {
+		return
+	}
+	if c, ok := rpcConnFromContext(ctx); ok {
+		*err = c.keepError(e)
+	}
+}
+
+// 错误的key以\x00开头，不会出现在正常的错误信息中
+const keptErrorMark = "\x00rpc-error-"
+
+// keptError is returned to rpc server in place of the error kept in connection
+type keptError struct {
+	err rpc.Error
+	key string
+}
+
+func (e *keptError) Error() string {
+	return e.err.Error() + e.key
+}
+
+func (e *keptError) ErrorCode() int {
+	return e.err.ErrorCode()
+}
+
+func (c *rpcConn) keepError(err rpc.Error) error {
+	c.errMtx.Lock()
+	defer c.errMtx.Unlock()
+
+	c.errSeq++
+	key := keptErrorMark + strconv.FormatUint(c.errSeq, 10)
+	c.errs[key] = err
+	return &keptError{err: err, key: key}
+}
+
+// takeError returns and forgets the error kept with the key at the end of message
+func (c *rpcConn) takeError(message string) (rpc.Error, bool) {
+	idx := strings.LastIndex(message, keptErrorMark)
+	if idx < 0 {
+		return nil, false
+	}
+	key := message[idx:]
+
+	c.errMtx.Lock()
+	defer c.errMtx.Unlock()
+
+	err, ok := c.errs[key]
+	delete(c.errs, key)
+	return err, ok
+}
+
+func (c *rpcConn) CreateErrorResponse(id interface{}, err rpc.Error) interface{} {
+	if e, ok := c.takeError(err.Error()); ok {
+		if de, ok := e.(dataError); ok {
+			return c.ServerCodec.CreateErrorResponseWithInfo(id, de, de.ErrorData())
+		}
+		return c.ServerCodec.CreateErrorResponse(id, e)
+	}
+	return c.ServerCodec.CreateErrorResponse(id, err)
+}
+
+type httpReadWriteNopCloser struct {
+	io.Reader
+	io.Writer
+}
+
+func (t *httpReadWriteNopCloser) Close() error {
+	return nil
+}
+
+// rpcHTTPHandler serves a JSON-RPC request or batch per http request
+type rpcHTTPHandler struct {
+	srv *rpc.Server
+}
+
+func (h *rpcHTTPHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	mt, _, err := mime.ParseMediaType(r.Header.Get("content-type"))
+	if err != nil || mt != "application/json" {
+		http.Error(w, "invalid content type, only application/json is supported", http.StatusUnsupportedMediaType)
+		return
+	}
+	w.Header().Set("content-type", "application/json")
+
+	conn := newRpcConn(rpc.NewJSONCodec(&httpReadWriteNopCloser{r.Body, w}), r.RemoteAddr, true)
+	defer conn.release()
+	defer conn.Close()
+	// notifier只用于找到rpcConn，http上的订阅由WebsocketServiceImpl拒绝
+	h.srv.ServeSingleRequest(conn, rpc.OptionMethodInvocation|rpc.OptionSubscriptions)
+}
+
+// newWebsocketHandler serves JSON-RPC to websocket connections whose origin is allowed,
+// "*" allows any origin, localhost is allowed if allowedOrigins is empty.
+func newWebsocketHandler(srv *rpc.Server, allowedOrigins []string) http.Handler {
+	origins := make(map[string]bool)
+	for _, origin := range allowedOrigins {
+		if origin != "" {
+			origins[strings.ToLower(origin)] = true
+		}
+	}
+	if len(origins) == 0 {
+		origins["http://localhost"] = true
+		if hostname, err := os.Hostname(); err == nil {
+			origins["http://"+strings.ToLower(hostname)] = true
+		}
+	}
+
+	return websocket.Server{
+		Handshake: func(cfg *websocket.Config, r *http.Request) error {
+			origin := strings.ToLower(r.Header.Get("Origin"))
+			if origins["*"] || origins[origin] {
+				return nil
+			}
+			log.Warnf("websocket,origin %s not allowed", origin)
+			return fmt.Errorf("origin %s not allowed", origin)
+		},
+		Handler: func(ws *websocket.Conn) {
+			conn := newRpcConn(rpc.NewJSONCodec(ws), ws.Request().RemoteAddr, false)
+			defer conn.release()
+			srv.ServeCodec(conn, rpc.OptionMethodInvocation|rpc.OptionSubscriptions)
+		},
+	}
+}
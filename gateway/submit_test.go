@@ -0,0 +1,208 @@
+/*
+
+  Copyright 2017 Loopring Project Ltd (Loopring Foundation).
+
+  Licensed under the Apache License, Version 2.0 (the "License");
+  you may not use this file except in compliance with the License.
+  You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+  Unless required by applicable law or agreed to in writing, software
+  distributed under the License is distributed on an "AS IS" BASIS,
+  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+  See the License for the specific language governing permissions and
+  limitations under the License.
+
+*/
+
+package gateway
+
+import (
+	"context"
+	"errors"
+	"math/big"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/Loopring/relay/eventemiter"
+	"github.com/Loopring/relay/market/util"
+	"github.com/Loopring/relay/ordermanager"
+	"github.com/Loopring/relay/types"
+	"github.com/ethereum/go-ethereum/common"
+)
+
+// testOrderManager keeps orders stored through OrderManagerGatewayNewOrder in memory
+type testOrderManager struct {
+	ordermanager.OrderManager
+	orders map[common.Hash]*types.OrderState
+	mtx    sync.Mutex
+}
+
+func (om *testOrderManager) GetOrderByHash(hash common.Hash) (*types.OrderState, error) {
+	om.mtx.Lock()
+	defer om.mtx.Unlock()
+	if state, ok := om.orders[hash]; ok {
+		return state, nil
+	}
+	return nil, errors.New("record not found")
+}
+
+func (om *testOrderManager) GetOpenOrderCount(owner common.Address, market string) (int, error) {
+	om.mtx.Lock()
+	count := 0
+	for _, state := range om.orders {
+		if state.RawOrder.Owner == owner {
+			count++
+		}
+	}
+	om.mtx.Unlock()
+	// 放大查询和插入之间的间隔
+	time.Sleep(10 * time.Millisecond)
+	return count, nil
+}
+
+func (om *testOrderManager) handleNewOrder(input eventemitter.EventData) error {
+	state := input.(*types.OrderState)
+	om.mtx.Lock()
+	defer om.mtx.Unlock()
+	om.orders[state.RawOrder.Hash] = state
+	return nil
+}
+
+func (om *testOrderManager) GetOrdersForBroadcast(dueBefore []int64, length int) ([]types.OrderState, error) {
+	om.mtx.Lock()
+	defer om.mtx.Unlock()
+	var list []types.OrderState
+	for _, state := range om.orders {
+		if state.BroadcastTime < len(dueBefore) && len(list) < length {
+			list = append(list, *state)
+		}
+	}
+	return list, nil
+}
+
+func (om *testOrderManager) IsOrderFullFinished(state *types.OrderState) bool {
+	return false
+}
+
+func (om *testOrderManager) UpdateBroadcastTimeByHash(hash common.Hash, bt int) error {
+	om.mtx.Lock()
+	defer om.mtx.Unlock()
+	om.orders[hash].BroadcastTime = bt
+	return nil
+}
+
+type testBroadcaster struct {
+	published int
+	err       error
+}
+
+func (b *testBroadcaster) Publish(topic string, data []byte) error {
+	if b.err != nil {
+		return b.err
+	}
+	b.published++
+	return nil
+}
+
+func (b *testBroadcaster) Stop() {}
+
+var (
+	testLrc  = common.HexToAddress("0x01")
+	testWeth = common.HexToAddress("0x02")
+)
+
+// setupTestGateway replaces gateway with one using om and filters, the returned func restores it
+func setupTestGateway(om *testOrderManager, filters ...Filter) func() {
+	decimals := new(big.Int).Exp(big.NewInt(10), big.NewInt(18), nil)
+	util.AllTokens = map[string]types.Token{
+		"LRC":  {Protocol: testLrc, Symbol: "LRC", Decimals: decimals},
+		"WETH": {Protocol: testWeth, Symbol: "WETH", Decimals: decimals, IsMarket: true},
+	}
+	util.SupportTokens = map[string]types.Token{"LRC": util.AllTokens["LRC"]}
+	util.SupportMarkets = map[string]types.Token{"WETH": util.AllTokens["WETH"]}
+
+	watcher := &eventemitter.Watcher{Concurrent: false, Handle: om.handleNewOrder}
+	eventemitter.On(eventemitter.OrderManagerGatewayNewOrder, watcher)
+
+	saved := gateway
+	gateway = Gateway{counters: make(map[string]*filterCounter), om: om, rateLimiter: &orderRateLimiter{}, filters: filters}
+	for _, f := range filters {
+		gateway.addCounter(f.Name())
+	}
+	gateway.addCounter(ownerRateLimitName)
+	gateway.addCounter(ipRateLimitName)
+
+	return func() {
+		gateway = saved
+		eventemitter.Un(eventemitter.OrderManagerGatewayNewOrder, watcher)
+	}
+}
+
+func newTestOrderRequest(owner common.Address, salt int64) *types.OrderJsonRequest {
+	return &types.OrderJsonRequest{
+		TokenS:    testLrc,
+		TokenB:    testWeth,
+		AmountS:   big.NewInt(1000),
+		AmountB:   big.NewInt(1),
+		Timestamp: time.Now().Unix(),
+		Ttl:       3600,
+		Salt:      salt,
+		LrcFee:    big.NewInt(0),
+		Owner:     owner,
+	}
+}
+
+func TestSubmitOrdersQuota(t *testing.T) {
+	om := &testOrderManager{orders: make(map[common.Hash]*types.OrderState)}
+	maxOpenOrders := 3
+	defer setupTestGateway(om, &QuotaFilter{MaxOpenOrders: maxOpenOrders, om: om})()
+
+	owner := common.HexToAddress("0x10")
+	var orders []*types.OrderJsonRequest
+	for i := 0; i < 2*batchSubmitWorkers; i++ {
+		orders = append(orders, newTestOrderRequest(owner, int64(i)))
+	}
+
+	res, err := (&JsonrpcServiceImpl{}).SubmitOrders(context.Background(), orders)
+	if err != nil {
+		t.Fatal(err)
+	}
+	accepted := 0
+	for _, r := range res {
+		if r.Accepted {
+			accepted++
+		} else if r.Code != ErrCodeTooManyOpenOrders {
+			t.Errorf("order %s rejected by %s:%s", r.Hash, r.Filter, r.Reason)
+		}
+	}
+	if accepted != maxOpenOrders || len(om.orders) != maxOpenOrders {
+		t.Errorf("expect %d orders accepted, got %d, stored %d", maxOpenOrders, accepted, len(om.orders))
+	}
+}
+
+func TestProcessOrderBroadcast(t *testing.T) {
+	om := &testOrderManager{orders: make(map[common.Hash]*types.OrderState)}
+	defer setupTestGateway(om)()
+	broadcaster := &testBroadcaster{}
+	gateway.broadcaster = broadcaster
+	gateway.broadcastTopics = []string{"orders"}
+	gateway.isBroadcast = true
+	gateway.maxBroadcastTime = 3
+
+	owner := common.HexToAddress("0x10")
+	if _, err := processOrder(types.ToOrder(newTestOrderRequest(owner, 1)), orderFromSync, ""); err != nil {
+		t.Fatal(err)
+	}
+	if broadcaster.published != 0 {
+		t.Errorf("synced order should not be broadcast")
+	}
+	if _, err := processOrder(types.ToOrder(newTestOrderRequest(owner, 2)), orderFromPeer, ""); err != nil {
+		t.Fatal(err)
+	}
+	if broadcaster.published != 1 {
+		t.Errorf("order from peer should be broadcast once, got %d", broadcaster.published)
+	}
+}
@@ -0,0 +1,361 @@
+/*
+
+  Copyright 2017 Loopring Project Ltd (Loopring Foundation).
+
+  Licensed under the Apache License, Version 2.0 (the "License");
+  you may not use this file except in compliance with the License.
+  You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+  Unless required by applicable law or agreed to in writing, software
+  distributed under the License is distributed on an "AS IS" BASIS,
+  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+  See the License for the specific language governing permissions and
+  limitations under the License.
+
+*/
+
+package gateway
+
+import (
+	"crypto/rand"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/Loopring/relay/config"
+	"github.com/Loopring/relay/log"
+	"github.com/Loopring/relay/market/util"
+	"github.com/Loopring/relay/types"
+	"github.com/ethereum/go-ethereum/common"
+)
+
+// 订单簿快照同步：新启动的relay在SyncTopic上请求各市场未成交订单的hash，
+// 与本地订单表比较后，向声明拥有这些订单的relay批量获取缺失的订单
+const (
+	syncSnapshotRequest = "snapshot_request"
+	syncSnapshot        = "snapshot"
+	syncOrdersRequest   = "orders_request"
+	syncOrders          = "orders"
+)
+
+const (
+	defaultSyncTimeout   = 10
+	defaultSyncBatchSize = 100
+
+	// 每条snapshot和orders_request消息最多包含的hash数，超过的消息直接丢弃
+	syncHashBatchSize = 1000
+	// 一次同步最多接受的hash数
+	syncMaxAdvertised = 100 * syncHashBatchSize
+
+	// 每个peer的请求限流，snapshot_request每个合约版本发送一次，orders_request按批发送
+	syncSnapshotRequestRate  = 1.0 / 60
+	syncSnapshotRequestBurst = 10
+	syncOrdersRequestRate    = 10
+	syncOrdersRequestBurst   = 100
+)
+
+// syncMessage is sent on SyncTopic, Node identifies the sender and To the receiver of a response
+type syncMessage struct {
+	Type      string            `json:"type"`
+	RequestId string            `json:"requestId"`
+	Node      string            `json:"node"`
+	To        string            `json:"to,omitempty"`
+	Version   string            `json:"version,omitempty"`
+	Markets   []string          `json:"markets,omitempty"`
+	Market    string            `json:"market,omitempty"`
+	Hashes    []common.Hash     `json:"hashes,omitempty"`
+	Orders    []json.RawMessage `json:"orders,omitempty"`
+}
+
+// syncRequest collects hashes advertised by peers for a snapshot request,
+// only orders requested are accepted in responses
+type syncRequest struct {
+	version    string
+	advertised map[common.Hash]string // order hash -> first node advertising it
+	requested  map[common.Hash]bool
+}
+
+type orderBookSyncer struct {
+	node                  string
+	topic                 string
+	timeout               time.Duration
+	batchSize             int
+	markets               []string
+	seq                   uint64
+	pending               map[string]*syncRequest
+	snapshotRequestLimits *rateLimiter
+	ordersRequestLimits   *rateLimiter
+	mtx                   sync.Mutex
+}
+
+func newOrderBookSyncer(options *config.IpfsOptions) *orderBookSyncer {
+	s := &orderBookSyncer{
+		node:      newSyncNodeId(),
+		topic:     options.SyncTopic,
+		timeout:   time.Duration(options.SyncTimeout) * time.Second,
+		batchSize: options.SyncBatchSize,
+		markets:   options.Markets,
+		pending:   make(map[string]*syncRequest),
+
+		snapshotRequestLimits: newRateLimiter(syncSnapshotRequestRate, syncSnapshotRequestBurst),
+		ordersRequestLimits:   newRateLimiter(syncOrdersRequestRate, syncOrdersRequestBurst),
+	}
+	if s.timeout <= 0 {
+		s.timeout = defaultSyncTimeout * time.Second
+	}
+	if s.batchSize <= 0 {
+		s.batchSize = defaultSyncBatchSize
+	}
+	return s
+}
+
+// newSyncNodeId 随机生成本次运行的节点id，与传输层无关
+func newSyncNodeId() string {
+	b := make([]byte, 8)
+	rand.Read(b)
+	return common.Bytes2Hex(b)
+}
+
+// StartOrderBookSync requests snapshots of served markets from peers in background,
+// and fetches orders missing in local order table
+func StartOrderBookSync() {
+	if gateway.syncer == nil {
+		return
+	}
+	go func() {
+		for version := range util.ContractVersionConfig {
+			if err := gateway.syncer.sync(version); err != nil {
+				log.Errorf("gateway,sync order book of %s error:%s", version, err.Error())
+			}
+		}
+	}()
+}
+
+// servedMarkets returns markets requested by peers for sync
+func (s *orderBookSyncer) servedMarkets() []string {
+	if len(s.markets) > 0 {
+		return s.markets
+	}
+	return util.AllMarkets
+}
+
+func (s *orderBookSyncer) sync(version string) error {
+	markets := s.servedMarkets()
+	requestId := fmt.Sprintf("%s-%d", s.node, atomic.AddUint64(&s.seq, 1))
+	req := &syncRequest{version: version, advertised: make(map[common.Hash]string), requested: make(map[common.Hash]bool)}
+
+	s.mtx.Lock()
+	s.pending[requestId] = req
+	s.mtx.Unlock()
+	defer func() {
+		s.mtx.Lock()
+		delete(s.pending, requestId)
+		s.mtx.Unlock()
+	}()
+
+	if err := s.publish(&syncMessage{Type: syncSnapshotRequest, RequestId: requestId, Version: version, Markets: markets}); err != nil {
+		return err
+	}
+	time.Sleep(s.timeout)
+
+	s.mtx.Lock()
+	hashes := make([]common.Hash, 0, len(req.advertised))
+	for hash := range req.advertised {
+		hashes = append(hashes, hash)
+	}
+	s.mtx.Unlock()
+
+	knownSet := make(map[common.Hash]bool)
+	for _, batch := range hashBatches(hashes, syncHashBatchSize) {
+		known, err := gateway.om.GetOrdersByHash(batch)
+		if err != nil {
+			return err
+		}
+		for _, v := range known {
+			knownSet[v.RawOrder.Hash] = true
+		}
+	}
+
+	missing := make(map[string][]common.Hash)
+	missingCount := 0
+	s.mtx.Lock()
+	for hash, node := range req.advertised {
+		if !knownSet[hash] {
+			missing[node] = append(missing[node], hash)
+			req.requested[hash] = true
+			missingCount++
+		}
+	}
+	s.mtx.Unlock()
+	log.Infof("gateway,sync order book of %s, %d orders advertised, %d missing", version, len(hashes), missingCount)
+
+	for node, list := range missing {
+		for _, batch := range hashBatches(list, s.batchSize) {
+			if err := s.publish(&syncMessage{Type: syncOrdersRequest, RequestId: requestId, To: node, Hashes: batch}); err != nil {
+				log.Errorf("gateway,request %d orders from %s error:%s", len(batch), node, err.Error())
+			}
+		}
+	}
+
+	// 等待订单返回后才结束请求
+	if missingCount > 0 {
+		time.Sleep(s.timeout)
+	}
+	return nil
+}
+
+func (s *orderBookSyncer) publish(msg *syncMessage) error {
+	msg.Node = s.node
+	data, err := json.Marshal(msg)
+	if err != nil {
+		return err
+	}
+	return gateway.publish(s.topic, data)
+}
+
+// handle handles sync message data received from peer
+func (s *orderBookSyncer) handle(peer string, data []byte) {
+	msg := &syncMessage{}
+	if err := json.Unmarshal(data, msg); err != nil {
+		log.Errorf("gateway,failed to decode sync message from %s:%s", peer, err.Error())
+		gateway.peers.record(peer, peerInvalid)
+		return
+	}
+	if msg.Node == s.node || (msg.To != "" && msg.To != s.node) {
+		return
+	}
+
+	if len(msg.Hashes) > syncHashBatchSize {
+		log.Errorf("gateway,drop sync message from %s with %d hashes", peer, len(msg.Hashes))
+		gateway.peers.record(peer, peerInvalid)
+		return
+	}
+
+	switch msg.Type {
+	case syncSnapshotRequest:
+		if !s.snapshotRequestLimits.allow(peer) {
+			log.Debugf("gateway,too many snapshot requests from %s", peer)
+			gateway.peers.record(peer, peerRejected)
+			return
+		}
+		s.sendSnapshots(msg)
+	case syncSnapshot:
+		s.mtx.Lock()
+		if req, ok := s.pending[msg.RequestId]; ok {
+			for _, hash := range msg.Hashes {
+				if len(req.advertised) >= syncMaxAdvertised {
+					break
+				}
+				if _, exists := req.advertised[hash]; !exists {
+					req.advertised[hash] = msg.Node
+				}
+			}
+		}
+		s.mtx.Unlock()
+	case syncOrdersRequest:
+		if !s.ordersRequestLimits.allow(peer) {
+			log.Debugf("gateway,too many orders requests from %s", peer)
+			gateway.peers.record(peer, peerRejected)
+			return
+		}
+		s.sendOrders(msg)
+	case syncOrders:
+		for _, raw := range msg.Orders {
+			ord := &types.Order{}
+			if err := ord.UnmarshalJSON(raw); err != nil {
+				log.Errorf("gateway,failed to decode synced order from %s:%s", peer, err.Error())
+				gateway.peers.record(peer, peerInvalid)
+				continue
+			}
+			if !s.takeRequested(msg.RequestId, ord.GenerateHash()) {
+				log.Debugf("gateway,drop order %s not requested from %s", ord.GenerateHash().Hex(), peer)
+				continue
+			}
+			isNew, err := processOrder(ord, orderFromSync, "")
+			scoreOrderResult(peer, isNew, err)
+		}
+	}
+}
+
+// takeRequested reports whether hash is requested by the pending request, each order is accepted once
+func (s *orderBookSyncer) takeRequested(requestId string, hash common.Hash) bool {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+
+	req, ok := s.pending[requestId]
+	if !ok || !req.requested[hash] {
+		return false
+	}
+	delete(req.requested, hash)
+	return true
+}
+
+func (s *orderBookSyncer) sendSnapshots(req *syncMessage) {
+	protocol, ok := util.ContractVersionConfig[req.Version]
+	if !ok {
+		return
+	}
+	// 只响应本relay服务的市场，每个市场最多一次
+	served := make(map[string]bool)
+	for _, mkt := range s.servedMarkets() {
+		served[strings.ToUpper(mkt)] = true
+	}
+	for _, mkt := range req.Markets {
+		mkt = strings.ToUpper(mkt)
+		if !served[mkt] {
+			continue
+		}
+		delete(served, mkt)
+
+		hashes, err := gateway.om.GetOpenOrderHashes(common.HexToAddress(protocol), mkt)
+		if err != nil {
+			log.Errorf("gateway,get open orders of %s error:%s", mkt, err.Error())
+			continue
+		}
+		for _, batch := range hashBatches(hashes, syncHashBatchSize) {
+			if err := s.publish(&syncMessage{Type: syncSnapshot, RequestId: req.RequestId, To: req.Node, Version: req.Version, Market: mkt, Hashes: batch}); err != nil {
+				log.Errorf("gateway,send snapshot of %s error:%s", mkt, err.Error())
+			}
+		}
+	}
+}
+
+func (s *orderBookSyncer) sendOrders(req *syncMessage) {
+	states, err := gateway.om.GetOrdersByHash(req.Hashes)
+	if err != nil {
+		log.Errorf("gateway,get %d orders requested by %s error:%s", len(req.Hashes), req.Node, err.Error())
+		return
+	}
+	orders := make([]json.RawMessage, 0, len(states))
+	for _, state := range states {
+		if data, err := state.RawOrder.MarshalJSON(); err == nil {
+			orders = append(orders, data)
+		}
+	}
+	for start := 0; start < len(orders); start += s.batchSize {
+		end := start + s.batchSize
+		if end > len(orders) {
+			end = len(orders)
+		}
+		if err := s.publish(&syncMessage{Type: syncOrders, RequestId: req.RequestId, To: req.Node, Orders: orders[start:end]}); err != nil {
+			log.Errorf("gateway,send orders to %s error:%s", req.Node, err.Error())
+		}
+	}
+}
+
+func hashBatches(hashes []common.Hash, size int) [][]common.Hash {
+	var batches [][]common.Hash
+	for start := 0; start < len(hashes); start += size {
+		end := start + size
+		if end > len(hashes) {
+			end = len(hashes)
+		}
+		batches = append(batches, hashes[start:end])
+	}
+	return batches
+}
@@ -0,0 +1,356 @@
+/*
+
+  Copyright 2017 Loopring Project Ltd (Loopring Foundation).
+
+  Licensed under the Apache License, Version 2.0 (the "License");
+  you may not use this file except in compliance with the License.
+  You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+  Unless required by applicable law or agreed to in writing, software
+  distributed under the License is distributed on an "AS IS" BASIS,
+  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+  See the License for the specific language governing permissions and
+  limitations under the License.
+
+*/
+
+package gateway
+
+import (
+	"bytes"
+	"encoding/json"
+	"math/big"
+	"sync/atomic"
+	"time"
+
+	"github.com/Loopring/relay/dao"
+	"github.com/Loopring/relay/ethaccessor"
+	"github.com/Loopring/relay/log"
+	"github.com/Loopring/relay/types"
+	"github.com/ethereum/go-ethereum/accounts/abi"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	ethtypes "github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/rlp"
+)
+
+// 未指定时，节点上查不到且超过1小时的pending交易视为dropped
+const defaultTxDropTimeout = 60 * 60
+
+// transaction methods recorded by relay
+const (
+	txMethodApprove     = "approve"
+	txMethodCancelOrder = "cancelOrder"
+	txMethodSetCutoff   = "setCutoff"
+	txMethodDeposit     = "deposit"
+	txMethodWithdraw    = "withdraw"
+)
+
+// recordTransaction 解析通过relay提交的raw transaction并记录，失败只记录日志不影响提交结果
+func (e *EthForwarder) recordTransaction(txHash, raw string) {
+	if e.rds == nil {
+		return
+	}
+
+	model, err := decodeRawTransaction(&e.Accessor, raw)
+	if err != nil {
+		log.Errorf("eth forwarder,decode raw transaction %s error:%s", txHash, err.Error())
+		return
+	}
+	model.TxHash = txHash
+	model.Status = uint8(types.TX_STATUS_PENDING)
+	model.CreateTime = time.Now().Unix()
+	model.UpdateTime = model.CreateTime
+
+	if err := e.rds.Add(model); err != nil {
+		log.Errorf("eth forwarder,save transaction %s error:%s", txHash, err.Error())
+	}
+}
+
+func decodeRawTransaction(accessor *ethaccessor.EthNodeAccessor, raw string) (*dao.Transaction, error) {
+	data, err := hexutil.Decode(raw)
+	if err != nil {
+		return nil, err
+	}
+	tx := new(ethtypes.Transaction)
+	if err := rlp.DecodeBytes(data, tx); err != nil {
+		return nil, err
+	}
+
+	var signer ethtypes.Signer = ethtypes.HomesteadSigner{}
+	if tx.Protected() {
+		signer = ethtypes.NewEIP155Signer(tx.ChainId())
+	}
+	owner, err := ethtypes.Sender(signer, tx)
+	if err != nil {
+		return nil, err
+	}
+
+	model := &dao.Transaction{}
+	model.Owner = owner.Hex()
+	model.Value = tx.Value().String()
+	model.Nonce = int64(tx.Nonce())
+	model.GasPrice = tx.GasPrice().String()
+	model.GasLimit = tx.Gas().String()
+	if tx.To() == nil {
+		return model, nil
+	}
+	model.To = tx.To().Hex()
+
+	// 无法解析的调用仍然记录交易，只是没有method和参数
+	method, content, err := decodeTransactionInput(accessor, *tx.To(), tx.Data(), tx.Value())
+	if err != nil {
+		log.Errorf("eth forwarder,decode input of transaction %s error:%s", tx.Hash().Hex(), err.Error())
+		return model, nil
+	}
+	if content != nil {
+		bs, err := json.Marshal(content)
+		if err != nil {
+			log.Errorf("eth forwarder,marshal input of transaction %s error:%s", tx.Hash().Hex(), err.Error())
+			return model, nil
+		}
+		model.Content = string(bs)
+	}
+	model.Method = method
+
+	return model, nil
+}
+
+// decodeTransactionInput 用accessor加载的abi解析approve、cancelOrder、setCutoff和weth deposit/withdraw，其他交易只记录不解析
+func decodeTransactionInput(accessor *ethaccessor.EthNodeAccessor, to common.Address, input []byte, value *big.Int) (string, map[string]interface{}, error) {
+	if to == accessor.WethAddress && len(input) == 0 {
+		return txMethodDeposit, map[string]interface{}{"value": types.BigintToHex(value)}, nil
+	}
+	if len(input) < 4 {
+		return "", nil, nil
+	}
+	id, args := input[:4], input[4:]
+
+	if _, ok := accessor.ProtocolAddresses[to]; ok {
+		switch {
+		case isMethod(accessor.ProtocolImplAbi, txMethodCancelOrder, id):
+			cancel := &ethaccessor.CancelOrderMethod{}
+			if err := accessor.ProtocolImplAbi.UnpackMethodInput(cancel, txMethodCancelOrder, args); err != nil {
+				return "", nil, err
+			}
+			order, err := cancel.ConvertDown()
+			if err != nil {
+				return "", nil, err
+			}
+			order.Protocol = to
+			return txMethodCancelOrder, map[string]interface{}{
+				"orderHash":    order.GenerateHash().Hex(),
+				"cancelAmount": types.BigintToHex(cancel.OrderValues[6]),
+			}, nil
+		case isMethod(accessor.ProtocolImplAbi, txMethodSetCutoff, id):
+			var cutoff *big.Int
+			if err := accessor.ProtocolImplAbi.UnpackMethodInput(&cutoff, txMethodSetCutoff, args); err != nil {
+				return "", nil, err
+			}
+			return txMethodSetCutoff, map[string]interface{}{"cutoff": cutoff.Int64()}, nil
+		}
+		return "", nil, nil
+	}
+
+	if to == accessor.WethAddress {
+		switch {
+		case isMethod(accessor.WethAbi, txMethodDeposit, id):
+			return txMethodDeposit, map[string]interface{}{"value": types.BigintToHex(value)}, nil
+		case isMethod(accessor.WethAbi, txMethodWithdraw, id):
+			withdrawal := &ethaccessor.WethWithdrawalMethod{}
+			if err := accessor.WethAbi.UnpackMethodInput(&withdrawal.Value, txMethodWithdraw, args); err != nil {
+				return "", nil, err
+			}
+			return txMethodWithdraw, map[string]interface{}{"value": types.BigintToHex(withdrawal.Value)}, nil
+		}
+	}
+
+	if isMethod(accessor.Erc20Abi, txMethodApprove, id) {
+		approve := &ethaccessor.ApproveMethod{}
+		if err := accessor.Erc20Abi.UnpackMethodInput(approve, txMethodApprove, args); err != nil {
+			return "", nil, err
+		}
+		return txMethodApprove, map[string]interface{}{
+			"token":   to.Hex(),
+			"spender": approve.Spender.Hex(),
+			"value":   types.BigintToHex(approve.Value),
+		}, nil
+	}
+
+	return "", nil, nil
+}
+
+func isMethod(a *abi.ABI, name string, id []byte) bool {
+	method, ok := a.Methods[name]
+	return ok && bytes.Equal(method.Id(), id)
+}
+
+// checkPendingTransactions 每个新块检查pending交易：有receipt的置为mined/failed，
+// nonce已被占用或节点上超时查不到的置为dropped
+func (e *EthForwarder) checkPendingTransactions() {
+	if !atomic.CompareAndSwapInt32(&e.checking, 0, 1) {
+		return
+	}
+	defer atomic.StoreInt32(&e.checking, 0)
+
+	list, err := e.rds.GetPendingTransactions()
+	if err != nil {
+		log.Errorf("eth forwarder,get pending transactions error:%s", err.Error())
+		return
+	}
+
+	nonces := make(map[string]int64)
+	for _, v := range list {
+		status, blockNumber, gasUsed, err := e.transactionStatus(&v, nonces)
+		if err != nil {
+			log.Errorf("eth forwarder,check transaction %s error:%s", v.TxHash, err.Error())
+			continue
+		}
+		if status == types.TX_STATUS_PENDING {
+			continue
+		}
+		if err := e.rds.UpdateTransactionStatus(v.TxHash, status, blockNumber, gasUsed, time.Now().Unix()); err != nil {
+			log.Errorf("eth forwarder,update transaction %s error:%s", v.TxHash, err.Error())
+		}
+	}
+}
+
+func (e *EthForwarder) transactionStatus(tx *dao.Transaction, nonces map[string]int64) (types.TxStatus, int64, string, error) {
+	if status, blockNumber, gasUsed, err := e.receiptStatus(tx); err != nil || status != types.TX_STATUS_PENDING {
+		return status, blockNumber, gasUsed, err
+	}
+
+	// 同一nonce的交易已上链，可能是查询receipt之后刚打包的这笔交易，需要再查一次receipt
+	nonce, ok := nonces[tx.Owner]
+	if !ok {
+		var count string
+		if err := e.Accessor.RetryCall(2, &count, "eth_getTransactionCount", common.HexToAddress(tx.Owner), "latest"); err != nil {
+			return types.TX_STATUS_PENDING, 0, "", err
+		}
+		nonce = types.HexToBigint(count).Int64()
+		nonces[tx.Owner] = nonce
+	}
+	if nonce > tx.Nonce {
+		status, blockNumber, gasUsed, err := e.receiptStatus(tx)
+		if err != nil || status != types.TX_STATUS_PENDING {
+			return status, blockNumber, gasUsed, err
+		}
+		return types.TX_STATUS_DROPPED, 0, "", nil
+	}
+
+	if time.Now().Unix()-tx.CreateTime < e.dropTimeout {
+		return types.TX_STATUS_PENDING, 0, "", nil
+	}
+	var pending json.RawMessage
+	if err := e.Accessor.RetryCall(2, &pending, "eth_getTransactionByHash", common.HexToHash(tx.TxHash)); err != nil {
+		return types.TX_STATUS_PENDING, 0, "", err
+	}
+	if len(pending) == 0 || string(pending) == "null" {
+		return types.TX_STATUS_DROPPED, 0, "", nil
+	}
+	return types.TX_STATUS_PENDING, 0, "", nil
+}
+
+// receiptStatus 没有receipt时返回pending
+func (e *EthForwarder) receiptStatus(tx *dao.Transaction) (types.TxStatus, int64, string, error) {
+	var receipt *ethaccessor.TransactionReceipt
+	if err := e.Accessor.RetryCall(2, &receipt, "eth_getTransactionReceipt", common.HexToHash(tx.TxHash)); err != nil {
+		return types.TX_STATUS_PENDING, 0, "", err
+	}
+	if receipt == nil || receipt.BlockHash == "" {
+		return types.TX_STATUS_PENDING, 0, "", nil
+	}
+	status := types.TX_STATUS_MINED
+	if receipt.Status == "0x0" {
+		status = types.TX_STATUS_FAILED
+	}
+	return status, receipt.BlockNumber.Int64(), receipt.GasUsed.BigInt().String(), nil
+}
+
+type TransactionQuery struct {
+	Owner     string
+	Status    string
+	PageIndex int
+	PageSize  int
+}
+
+type TransactionJsonResult struct {
+	TxHash      string          `json:"txHash"`
+	Owner       string          `json:"owner"`
+	To          string          `json:"to"`
+	Method      string          `json:"method"`
+	Content     json.RawMessage `json:"content,omitempty"`
+	Value       string          `json:"value"`
+	Nonce       int64           `json:"nonce"`
+	GasPrice    string          `json:"gasPrice"`
+	GasLimit    string          `json:"gasLimit"`
+	GasUsed     string          `json:"gasUsed,omitempty"`
+	Status      string          `json:"status"`
+	BlockNumber int64           `json:"blockNumber"`
+	CreateTime  int64           `json:"createTime"`
+	UpdateTime  int64           `json:"updateTime"`
+}
+
+func (e *EthForwarder) getTransactions(owner common.Address, statusSet []types.TxStatus, pageIndex, pageSize int) (dao.PageResult, error) {
+	res, err := e.rds.TransactionPageQuery(owner, statusSet, pageIndex, pageSize)
+	if err != nil {
+		return res, err
+	}
+
+	result := dao.PageResult{PageIndex: res.PageIndex, PageSize: res.PageSize, Total: res.Total, Data: make([]interface{}, 0)}
+	for _, v := range res.Data {
+		tx := v.(dao.Transaction)
+		item := TransactionJsonResult{
+			TxHash:      tx.TxHash,
+			Owner:       tx.Owner,
+			To:          tx.To,
+			Method:      tx.Method,
+			Value:       amountToHex(tx.Value),
+			Nonce:       tx.Nonce,
+			GasPrice:    amountToHex(tx.GasPrice),
+			GasLimit:    amountToHex(tx.GasLimit),
+			Status:      getStringTxStatus(types.TxStatus(tx.Status)),
+			BlockNumber: tx.BlockNumber,
+			CreateTime:  tx.CreateTime,
+			UpdateTime:  tx.UpdateTime,
+		}
+		if tx.Content != "" {
+			item.Content = json.RawMessage(tx.Content)
+		}
+		if tx.GasUsed != "" {
+			item.GasUsed = amountToHex(tx.GasUsed)
+		}
+		result.Data = append(result.Data, item)
+	}
+	return result, nil
+}
+
+func getStringTxStatus(s types.TxStatus) string {
+	switch s {
+	case types.TX_STATUS_PENDING:
+		return "pending"
+	case types.TX_STATUS_MINED:
+		return "mined"
+	case types.TX_STATUS_FAILED:
+		return "failed"
+	case types.TX_STATUS_DROPPED:
+		return "dropped"
+	}
+	return "unknown"
+}
+
+func convertTxStatus(s string) (types.TxStatus, bool) {
+	switch s {
+	case "pending":
+		return types.TX_STATUS_PENDING, true
+	case "mined":
+		return types.TX_STATUS_MINED, true
+	case "failed":
+		return types.TX_STATUS_FAILED, true
+	case "dropped":
+		return types.TX_STATUS_DROPPED, true
+	}
+	return types.TX_STATUS_UNKNOWN, false
+}
@@ -0,0 +1,154 @@
+/*
+
+  Copyright 2017 Loopring Project Ltd (Loopring Foundation).
+
+  Licensed under the Apache License, Version 2.0 (the "License");
+  you may not use this file except in compliance with the License.
+  You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+  Unless required by applicable law or agreed to in writing, software
+  distributed under the License is distributed on an "AS IS" BASIS,
+  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+  See the License for the specific language governing permissions and
+  limitations under the License.
+
+*/
+
+package gateway
+
+import (
+	"math/big"
+	"testing"
+
+	"github.com/Loopring/relay/config"
+	"github.com/Loopring/relay/ethaccessor"
+	"github.com/Loopring/relay/types"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	ethtypes "github.com/ethereum/go-ethereum/core/types"
+	ethcrypto "github.com/ethereum/go-ethereum/crypto"
+	"github.com/ethereum/go-ethereum/rlp"
+)
+
+func newTestAccessor(t *testing.T) *ethaccessor.EthNodeAccessor {
+	c := config.LoadConfig("../config/relay.toml")
+	accessor := &ethaccessor.EthNodeAccessor{}
+	var err error
+	if accessor.Erc20Abi, err = ethaccessor.NewAbi(c.Common.Erc20Abi); err != nil {
+		t.Fatal(err)
+	}
+	if accessor.WethAbi, err = ethaccessor.NewAbi(c.Common.WethAbi); err != nil {
+		t.Fatal(err)
+	}
+	if accessor.ProtocolImplAbi, err = ethaccessor.NewAbi(c.Common.ProtocolImpl.ImplAbi); err != nil {
+		t.Fatal(err)
+	}
+	accessor.WethAddress = common.HexToAddress("0x2956356cD2a2bf3202F771F50D3D14A367b48070")
+	protocol := common.HexToAddress("0x03E0F73A93993E5101362656Af1162eD80FB54F2")
+	accessor.ProtocolAddresses = map[common.Address]*ethaccessor.ProtocolAddress{protocol: {ContractAddress: protocol}}
+	return accessor
+}
+
+func TestDecodeTransactionInput(t *testing.T) {
+	accessor := newTestAccessor(t)
+	protocol := common.HexToAddress("0x03E0F73A93993E5101362656Af1162eD80FB54F2")
+	token := common.HexToAddress("0xEF68e7C694F40c8202821eDF525dE3782458639f")
+	spender := common.HexToAddress("0x17233e07c67d086464fD408148c3ABB56245FA64")
+	amount := big.NewInt(1000)
+
+	pack := func(data []byte, err error) []byte {
+		if err != nil {
+			t.Fatal(err)
+		}
+		return data
+	}
+
+	order := &types.Order{
+		Protocol:              protocol,
+		Owner:                 common.HexToAddress("0xb94065482Ad64d4c2b9252358D746B39e820A582"),
+		TokenS:                token,
+		TokenB:                accessor.WethAddress,
+		AmountS:               big.NewInt(100),
+		AmountB:               big.NewInt(2),
+		Timestamp:             big.NewInt(1520419041),
+		Ttl:                   big.NewInt(3600),
+		Salt:                  big.NewInt(7),
+		LrcFee:                big.NewInt(10),
+		MarginSplitPercentage: 50,
+		V:                     27,
+	}
+	addresses := [3]common.Address{order.Owner, order.TokenS, order.TokenB}
+	values := [7]*big.Int{order.AmountS, order.AmountB, order.Timestamp, order.Ttl, order.Salt, order.LrcFee, big.NewInt(60)}
+
+	cases := []struct {
+		to      common.Address
+		input   []byte
+		value   *big.Int
+		method  string
+		content map[string]interface{}
+	}{
+		{accessor.WethAddress, nil, amount, txMethodDeposit, map[string]interface{}{"value": "0x3e8"}},
+		{accessor.WethAddress, pack(accessor.WethAbi.Pack(txMethodDeposit)), amount, txMethodDeposit, map[string]interface{}{"value": "0x3e8"}},
+		{accessor.WethAddress, pack(accessor.WethAbi.Pack(txMethodWithdraw, amount)), big.NewInt(0), txMethodWithdraw, map[string]interface{}{"value": "0x3e8"}},
+		{token, pack(accessor.Erc20Abi.Pack(txMethodApprove, spender, amount)), big.NewInt(0), txMethodApprove, map[string]interface{}{"token": token.Hex(), "spender": spender.Hex(), "value": "0x3e8"}},
+		{protocol, pack(accessor.ProtocolImplAbi.Pack(txMethodSetCutoff, big.NewInt(1520419041))), big.NewInt(0), txMethodSetCutoff, map[string]interface{}{"cutoff": int64(1520419041)}},
+		{protocol, pack(accessor.ProtocolImplAbi.Pack(txMethodCancelOrder, addresses, values, order.BuyNoMoreThanAmountB, order.MarginSplitPercentage, order.V, order.R, order.S)), big.NewInt(0), txMethodCancelOrder,
+			map[string]interface{}{"orderHash": order.GenerateHash().Hex(), "cancelAmount": "0x3c"}},
+		// 未知合约和方法只记录不解析
+		{token, pack(accessor.Erc20Abi.Pack("transfer", spender, amount)), big.NewInt(0), "", nil},
+		{spender, []byte{0x01}, big.NewInt(0), "", nil},
+	}
+
+	for i, c := range cases {
+		method, content, err := decodeTransactionInput(accessor, c.to, c.input, c.value)
+		if err != nil {
+			t.Errorf("case %d: %s", i, err.Error())
+			continue
+		}
+		if method != c.method {
+			t.Errorf("case %d: method %s, expected %s", i, method, c.method)
+		}
+		if len(content) != len(c.content) {
+			t.Errorf("case %d: content %v, expected %v", i, content, c.content)
+			continue
+		}
+		for k, v := range c.content {
+			if content[k] != v {
+				t.Errorf("case %d: %s is %v, expected %v", i, k, content[k], v)
+			}
+		}
+	}
+}
+
+func TestDecodeRawTransaction(t *testing.T) {
+	accessor := newTestAccessor(t)
+	protocol := common.HexToAddress("0x03E0F73A93993E5101362656Af1162eD80FB54F2")
+	key, err := ethcrypto.GenerateKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// cancelOrder的参数被截断，无法解析
+	input := append(accessor.ProtocolImplAbi.Methods[txMethodCancelOrder].Id(), 1, 2, 3)
+	tx, err := ethtypes.SignTx(ethtypes.NewTransaction(3, protocol, big.NewInt(0), big.NewInt(200000), big.NewInt(1e9), input), ethtypes.HomesteadSigner{}, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := rlp.EncodeToBytes(tx)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	model, err := decodeRawTransaction(accessor, hexutil.Encode(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if model.Owner != ethcrypto.PubkeyToAddress(key.PublicKey).Hex() || model.To != protocol.Hex() || model.Nonce != 3 {
+		t.Errorf("unexpected transaction %+v", model)
+	}
+	if model.Method != "" || model.Content != "" {
+		t.Errorf("undecodable input should be recorded without method, got %s %s", model.Method, model.Content)
+	}
+}
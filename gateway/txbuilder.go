@@ -0,0 +1,102 @@
+/*
+
+  Copyright 2017 Loopring Project Ltd (Loopring Foundation).
+
+  Licensed under the Apache License, Version 2.0 (the "License");
+  you may not use this file except in compliance with the License.
+  You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+  Unless required by applicable law or agreed to in writing, software
+  distributed under the License is distributed on an "AS IS" BASIS,
+  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+  See the License for the specific language governing permissions and
+  limitations under the License.
+
+*/
+
+package gateway
+
+import (
+	"math/big"
+	"strings"
+
+	"github.com/Loopring/relay/types"
+	"github.com/ethereum/go-ethereum/accounts/abi"
+	"github.com/ethereum/go-ethereum/common"
+)
+
+// 合约参数均为uint256，超出的数量会被abi编码截断
+var maxUint256 = new(big.Int).Sub(new(big.Int).Lsh(big.NewInt(1), 256), big.NewInt(1))
+
+// 未指定approve数量时授权最大值，避免每次下单前重复approve
+var maxApproveAmount = maxUint256
+
+type CancelOrderTxRequest struct {
+	OrderHash    string `json:"orderHash"`
+	CancelAmount string `json:"cancelAmount"`
+}
+
+type CutoffTxRequest struct {
+	Owner           string `json:"owner"`
+	ContractVersion string `json:"contractVersion"`
+	Cutoff          int64  `json:"cutoff"`
+}
+
+type ApproveTxRequest struct {
+	Owner           string `json:"owner"`
+	Token           string `json:"token"`
+	ContractVersion string `json:"contractVersion"`
+	Amount          string `json:"amount"`
+}
+
+type WethTxRequest struct {
+	Owner  string `json:"owner"`
+	Method string `json:"method"`
+	Amount string `json:"amount"`
+}
+
+// UnsignedTx 由客户端补充nonce后签名，再通过eth_sendRawTransaction提交
+type UnsignedTx struct {
+	From     string `json:"from"`
+	To       string `json:"to"`
+	Data     string `json:"data"`
+	Value    string `json:"value"`
+	Gas      string `json:"gas"`
+	GasPrice string `json:"gasPrice"`
+}
+
+// buildTransaction 编码合约调用并以from的身份估算gas，估算失败通常说明交易会被合约revert
+func (e *EthForwarder) buildTransaction(from, to common.Address, value *big.Int, a *abi.ABI, method string, args ...interface{}) (tx UnsignedTx, err error) {
+	data, err := a.Pack(method, args...)
+	if err != nil {
+		return tx, NewJsonrpcError(ErrCodeInvalidParams, "pack %s error:%s", method, err.Error()).With("method", method)
+	}
+
+	gas, gasPrice, err := e.estimateGas(from, to, value, data)
+	if err != nil {
+		return tx, NewJsonrpcError(ErrCodeGasEstimationFailed, "estimate gas of %s error:%s", method, err.Error()).With("method", method)
+	}
+
+	tx.From = from.Hex()
+	tx.To = to.Hex()
+	tx.Data = common.ToHex(data)
+	tx.Value = types.BigintToHex(value)
+	tx.Gas = types.BigintToHex(gas)
+	tx.GasPrice = types.BigintToHex(gasPrice)
+	return tx, nil
+}
+
+// parseAmount 支持十进制和0x开头的十六进制数量，不接受负数和超过uint256的值
+func parseAmount(s string) (*big.Int, bool) {
+	base := 10
+	if strings.HasPrefix(s, "0x") || strings.HasPrefix(s, "0X") {
+		s, base = s[2:], 16
+	}
+	n, ok := new(big.Int).SetString(s, base)
+	if !ok || n.Sign() < 0 || n.Cmp(maxUint256) > 0 {
+		return nil, false
+	}
+	return n, true
+}
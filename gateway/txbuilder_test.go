@@ -0,0 +1,179 @@
+/*
+
+  Copyright 2017 Loopring Project Ltd (Loopring Foundation).
+
+  Licensed under the Apache License, Version 2.0 (the "License");
+  you may not use this file except in compliance with the License.
+  You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+  Unless required by applicable law or agreed to in writing, software
+  distributed under the License is distributed on an "AS IS" BASIS,
+  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+  See the License for the specific language governing permissions and
+  limitations under the License.
+
+*/
+
+package gateway
+
+import (
+	"context"
+	"math/big"
+	"testing"
+
+	"github.com/Loopring/relay/ethaccessor"
+	"github.com/Loopring/relay/market/util"
+	"github.com/Loopring/relay/types"
+	"github.com/ethereum/go-ethereum/common"
+	ethcrypto "github.com/ethereum/go-ethereum/crypto"
+)
+
+func TestParseAmount(t *testing.T) {
+	cases := []struct {
+		input    string
+		expected string
+		ok       bool
+	}{
+		{"1000", "1000", true},
+		{"0", "0", true},
+		{"0x3e8", "1000", true},
+		{"0X3E8", "1000", true},
+		{"1000000000000000000000000", "1000000000000000000000000", true},
+		{"", "", false},
+		{"0x", "", false},
+		{"-1", "", false},
+		{"-0x1", "", false},
+		{"1.5", "", false},
+		{"1e18", "", false},
+		{"0xzz", "", false},
+		{"115792089237316195423570985008687907853269984665640564039457584007913129639935", "115792089237316195423570985008687907853269984665640564039457584007913129639935", true},
+		{"115792089237316195423570985008687907853269984665640564039457584007913129639936", "", false},
+		{"0x10000000000000000000000000000000000000000000000000000000000000000", "", false},
+	}
+	for _, c := range cases {
+		n, ok := parseAmount(c.input)
+		if ok != c.ok {
+			t.Errorf("parse %q: ok %t, expected %t", c.input, ok, c.ok)
+			continue
+		}
+		if ok && n.String() != c.expected {
+			t.Errorf("parse %q: got %s, expected %s", c.input, n.String(), c.expected)
+		}
+	}
+}
+
+// newTestTxBuilder returns a service with fixed gas estimation, calls collects the calldata passed to estimation
+func newTestTxBuilder(t *testing.T, protocol, delegate, token common.Address) (*JsonrpcServiceImpl, *[][]byte, func()) {
+	accessor := newTestAccessor(t)
+	accessor.ProtocolAddresses = map[common.Address]*ethaccessor.ProtocolAddress{protocol: {ContractAddress: protocol, DelegateAddress: delegate}}
+
+	calls := &[][]byte{}
+	e := &EthForwarder{Accessor: *accessor}
+	e.estimateGas = func(from, to common.Address, value *big.Int, callData []byte) (*big.Int, *big.Int, error) {
+		*calls = append(*calls, callData)
+		return big.NewInt(21000), big.NewInt(1000000000), nil
+	}
+
+	savedVersions, savedTokens := util.ContractVersionConfig, util.AllTokens
+	util.ContractVersionConfig = map[string]string{"v1.0": protocol.Hex()}
+	util.AllTokens = map[string]types.Token{"LRC": {Protocol: token, Symbol: "LRC"}}
+	return &JsonrpcServiceImpl{ethForwarder: e}, calls, func() {
+		util.ContractVersionConfig, util.AllTokens = savedVersions, savedTokens
+	}
+}
+
+// expectedCalldata 按abi规则拼接方法签名的前4字节和32字节对齐的参数
+func expectedCalldata(signature string, words ...[]byte) string {
+	data := ethcrypto.Keccak256([]byte(signature))[:4]
+	for _, w := range words {
+		data = append(data, common.LeftPadBytes(w, 32)...)
+	}
+	return common.ToHex(data)
+}
+
+func TestBuildApproveTx(t *testing.T) {
+	protocol := common.HexToAddress("0x03E0F73A93993E5101362656Af1162eD80FB54F2")
+	delegate := common.HexToAddress("0x17233e07c67d086464fD408148c3ABB56245FA64")
+	token := common.HexToAddress("0xEF68e7C694F40c8202821eDF525dE3782458639f")
+	owner := common.HexToAddress("0x48ff2269e58a373120FFdBBdEE3FBceA854AC30A")
+	j, calls, restore := newTestTxBuilder(t, protocol, delegate, token)
+	defer restore()
+
+	tx, err := j.BuildApproveTx(context.Background(), ApproveTxRequest{Owner: owner.Hex(), Token: "LRC", ContractVersion: "v1.0", Amount: "1000"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := expectedCalldata("approve(address,uint256)", delegate.Bytes(), big.NewInt(1000).Bytes())
+	if tx.Data != expected {
+		t.Errorf("data %s, expected %s", tx.Data, expected)
+	}
+	if len(*calls) != 1 || common.ToHex((*calls)[0]) != expected {
+		t.Errorf("estimated calldata %x, expected %s", *calls, expected)
+	}
+	if tx.To != token.Hex() || tx.From != owner.Hex() || tx.Value != "0x0" {
+		t.Errorf("from %s to %s value %s, expected %s to %s value 0x0", tx.From, tx.To, tx.Value, owner.Hex(), token.Hex())
+	}
+	if tx.Gas != "0x5208" || tx.GasPrice != "0x3b9aca00" {
+		t.Errorf("gas %s gasPrice %s", tx.Gas, tx.GasPrice)
+	}
+
+	// 未指定数量时授权uint256最大值
+	tx, err = j.BuildApproveTx(context.Background(), ApproveTxRequest{Owner: owner.Hex(), Token: "LRC", ContractVersion: "v1.0"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expected := expectedCalldata("approve(address,uint256)", delegate.Bytes(), maxUint256.Bytes()); tx.Data != expected {
+		t.Errorf("data %s, expected %s", tx.Data, expected)
+	}
+
+	overflow := new(big.Int).Lsh(big.NewInt(1), 256).String()
+	if _, err := j.BuildApproveTx(context.Background(), ApproveTxRequest{Owner: owner.Hex(), Token: "LRC", ContractVersion: "v1.0", Amount: overflow}); err == nil {
+		t.Errorf("amount %s should be rejected", overflow)
+	}
+	if len(*calls) != 2 {
+		t.Errorf("estimated %d times, expected 2", len(*calls))
+	}
+}
+
+func TestBuildCutoffTx(t *testing.T) {
+	protocol := common.HexToAddress("0x03E0F73A93993E5101362656Af1162eD80FB54F2")
+	owner := common.HexToAddress("0x48ff2269e58a373120FFdBBdEE3FBceA854AC30A")
+	j, _, restore := newTestTxBuilder(t, protocol, common.Address{}, common.Address{})
+	defer restore()
+
+	tx, err := j.BuildCutoffTx(context.Background(), CutoffTxRequest{Owner: owner.Hex(), ContractVersion: "v1.0", Cutoff: 1514764800})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expected := expectedCalldata("setCutoff(uint256)", big.NewInt(1514764800).Bytes()); tx.Data != expected {
+		t.Errorf("data %s, expected %s", tx.Data, expected)
+	}
+	if tx.To != protocol.Hex() || tx.From != owner.Hex() {
+		t.Errorf("from %s to %s, expected %s to %s", tx.From, tx.To, owner.Hex(), protocol.Hex())
+	}
+}
+
+func TestBuildWethTx(t *testing.T) {
+	owner := common.HexToAddress("0x48ff2269e58a373120FFdBBdEE3FBceA854AC30A")
+	j, _, restore := newTestTxBuilder(t, common.HexToAddress("0x03E0F73A93993E5101362656Af1162eD80FB54F2"), common.Address{}, common.Address{})
+	defer restore()
+	weth := j.ethForwarder.Accessor.WethAddress.Hex()
+
+	tx, err := j.BuildWethTx(context.Background(), WethTxRequest{Owner: owner.Hex(), Method: "deposit", Amount: "0x3e8"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expected := expectedCalldata("deposit()"); tx.Data != expected || tx.Value != "0x3e8" || tx.To != weth {
+		t.Errorf("deposit to %s data %s value %s, expected to %s data %s value 0x3e8", tx.To, tx.Data, tx.Value, weth, expected)
+	}
+
+	tx, err = j.BuildWethTx(context.Background(), WethTxRequest{Owner: owner.Hex(), Method: "withdraw", Amount: "1000"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expected := expectedCalldata("withdraw(uint256)", big.NewInt(1000).Bytes()); tx.Data != expected || tx.Value != "0x0" || tx.To != weth {
+		t.Errorf("withdraw to %s data %s value %s, expected to %s data %s value 0x0", tx.To, tx.Data, tx.Value, weth, expected)
+	}
+}
@@ -0,0 +1,328 @@
+/*
+
+  Copyright 2017 Loopring Project Ltd (Loopring Foundation).
+
+  Licensed under the Apache License, Version 2.0 (the "License");
+  you may not use this file except in compliance with the License.
+  You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+  Unless required by applicable law or agreed to in writing, software
+  distributed under the License is distributed on an "AS IS" BASIS,
+  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+  See the License for the specific language governing permissions and
+  limitations under the License.
+
+*/
+
+package gateway
+
+import (
+	"context"
+	"github.com/Loopring/relay/dao"
+	"github.com/Loopring/relay/eventemiter"
+	"github.com/Loopring/relay/log"
+	"github.com/Loopring/relay/market/util"
+	"github.com/Loopring/relay/types"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/rpc"
+	"strings"
+	"sync"
+	"time"
+)
+
+// 推送间隔，同一间隔内的多个事件合并为一次推送
+const pushInterval = time.Second
+
+type subscriptionType int
+
+const (
+	depthSubscription subscriptionType = iota
+	tickerSubscription
+	fillSubscription
+	orderSubscription
+)
+
+type subscriber struct {
+	typ             subscriptionType
+	id              rpc.ID
+	notifier        *rpc.Notifier
+	market          string
+	owner           string
+	contractVersion string
+	length          int
+	precision       int
+	initial         bool
+}
+
+type FillSubscriptionQuery struct {
+	Market string `json:"market"`
+	Owner  string `json:"owner"`
+}
+
+type OrderSubscriptionQuery struct {
+	Owner string `json:"owner"`
+}
+
+// WebsocketServiceImpl push depth, ticker, fill and order status to websocket clients,
+// clients use loopring_subscribe/loopring_unsubscribe to manage subscriptions
+type WebsocketServiceImpl struct {
+	jsonrpc     *JsonrpcServiceImpl
+	subscribers map[rpc.ID]*subscriber
+	mtx         sync.Mutex
+
+	dirtyMarkets map[string]bool
+	dirtyOrders  map[common.Hash]bool
+	dirtyTickers bool
+
+	fillWatcher     *eventemitter.Watcher
+	cancelWatcher   *eventemitter.Watcher
+	newOrderWatcher *eventemitter.Watcher
+	blockWatcher    *eventemitter.Watcher
+	stop            chan struct{}
+}
+
+func NewWebsocketService(jsonrpc *JsonrpcServiceImpl) *WebsocketServiceImpl {
+	s := &WebsocketServiceImpl{}
+	s.jsonrpc = jsonrpc
+	s.subscribers = make(map[rpc.ID]*subscriber)
+	s.dirtyMarkets = make(map[string]bool)
+	s.dirtyOrders = make(map[common.Hash]bool)
+	return s
+}
+
+func (s *WebsocketServiceImpl) Start() {
+	s.fillWatcher = &eventemitter.Watcher{Concurrent: false, Handle: s.handleOrderFilled}
+	s.cancelWatcher = &eventemitter.Watcher{Concurrent: false, Handle: s.handleOrderCancelled}
+	s.newOrderWatcher = &eventemitter.Watcher{Concurrent: false, Handle: s.handleNewOrder}
+	s.blockWatcher = &eventemitter.Watcher{Concurrent: false, Handle: s.handleNewBlock}
+
+	eventemitter.On(eventemitter.OrderManagerExtractorFill, s.fillWatcher)
+	eventemitter.On(eventemitter.OrderManagerExtractorCancel, s.cancelWatcher)
+	eventemitter.On(eventemitter.OrderManagerGatewayNewOrder, s.newOrderWatcher)
+	eventemitter.On(eventemitter.Block_New, s.blockWatcher)
+
+	s.stop = make(chan struct{})
+	go func() {
+		ticker := time.NewTicker(pushInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				s.flush()
+			case <-s.stop:
+				return
+			}
+		}
+	}()
+}
+
+func (s *WebsocketServiceImpl) Stop() {
+	eventemitter.Un(eventemitter.OrderManagerExtractorFill, s.fillWatcher)
+	eventemitter.Un(eventemitter.OrderManagerExtractorCancel, s.cancelWatcher)
+	eventemitter.Un(eventemitter.OrderManagerGatewayNewOrder, s.newOrderWatcher)
+	eventemitter.Un(eventemitter.Block_New, s.blockWatcher)
+	close(s.stop)
+}
+
+// Depth subscribe depth of market, method: loopring_subscribe, params: ["depth", DepthQuery]
+func (s *WebsocketServiceImpl) Depth(ctx context.Context, query DepthQuery) (*rpc.Subscription, error) {
+	return s.subscribe(ctx, &subscriber{
+		typ:             depthSubscription,
+		market:          strings.ToUpper(query.Market),
+		contractVersion: query.ContractVersion,
+		length:          query.Length,
+		precision:       query.Precision,
+	})
+}
+
+// Tickers subscribe tickers of all markets, method: loopring_subscribe, params: ["tickers", contractVersion]
+func (s *WebsocketServiceImpl) Tickers(ctx context.Context, contractVersion string) (*rpc.Subscription, error) {
+	return s.subscribe(ctx, &subscriber{typ: tickerSubscription, contractVersion: contractVersion})
+}
+
+// Fills subscribe fills filtered by market and/or owner, method: loopring_subscribe, params: ["fills", FillSubscriptionQuery]
+func (s *WebsocketServiceImpl) Fills(ctx context.Context, query FillSubscriptionQuery) (*rpc.Subscription, error) {
+	return s.subscribe(ctx, &subscriber{
+		typ:    fillSubscription,
+		market: strings.ToUpper(query.Market),
+		owner:  strings.ToLower(query.Owner),
+	})
+}
+
+// Orders subscribe order status changes of owner, method: loopring_subscribe, params: ["orders", OrderSubscriptionQuery]
+func (s *WebsocketServiceImpl) Orders(ctx context.Context, query OrderSubscriptionQuery) (*rpc.Subscription, error) {
+	return s.subscribe(ctx, &subscriber{typ: orderSubscription, owner: strings.ToLower(query.Owner)})
+}
+
+func (s *WebsocketServiceImpl) subscribe(ctx context.Context, sub *subscriber) (*rpc.Subscription, error) {
+	notifier, supported := rpc.NotifierFromContext(ctx)
+	if conn, ok := rpcConnFromContext(ctx); ok && conn.isHTTP {
+		supported = false
+	}
+	if !supported {
+		return &rpc.Subscription{}, rpc.ErrNotificationsUnsupported
+	}
+
+	rpcSub := notifier.CreateSubscription()
+	sub.id = rpcSub.ID
+	sub.notifier = notifier
+	sub.initial = sub.typ == depthSubscription || sub.typ == tickerSubscription
+
+	s.mtx.Lock()
+	s.subscribers[sub.id] = sub
+	s.mtx.Unlock()
+
+	go func() {
+		select {
+		case <-rpcSub.Err():
+		case <-notifier.Closed():
+		}
+		s.mtx.Lock()
+		delete(s.subscribers, sub.id)
+		s.mtx.Unlock()
+		log.Debugf("websocket,subscription %s removed", sub.id)
+	}()
+
+	return rpcSub, nil
+}
+
+func (s *WebsocketServiceImpl) handleOrderFilled(input eventemitter.EventData) error {
+	event := input.(*types.OrderFilledEvent)
+
+	fill := dao.FillEvent{}
+	if err := fill.ConvertDown(event); err != nil {
+		return err
+	}
+	if fill.Market == "" {
+		fill.Market, _ = util.WrapMarketByAddress(fill.TokenS, fill.TokenB)
+	}
+	owner := strings.ToLower(fill.Owner)
+	fill.TokenS = util.AddressToAlias(fill.TokenS)
+	fill.TokenB = util.AddressToAlias(fill.TokenB)
+
+	// 成交记录直接推送，深度、行情、订单状态等数据库更新后再推送
+	for _, sub := range s.subscribersOf(fillSubscription) {
+		if sub.market != "" && sub.market != fill.Market {
+			continue
+		}
+		if sub.owner != "" && sub.owner != owner {
+			continue
+		}
+		s.notify(sub, fill)
+	}
+
+	s.mtx.Lock()
+	s.dirtyMarkets[fill.Market] = true
+	s.dirtyOrders[event.OrderHash] = true
+	s.dirtyTickers = true
+	s.mtx.Unlock()
+	return nil
+}
+
+func (s *WebsocketServiceImpl) handleOrderCancelled(input eventemitter.EventData) error {
+	event := input.(*types.OrderCancelledEvent)
+
+	s.mtx.Lock()
+	s.dirtyOrders[event.OrderHash] = true
+	s.mtx.Unlock()
+	return nil
+}
+
+func (s *WebsocketServiceImpl) handleNewOrder(input eventemitter.EventData) error {
+	state := input.(*types.OrderState)
+
+	s.mtx.Lock()
+	s.dirtyOrders[state.RawOrder.Hash] = true
+	s.mtx.Unlock()
+	return nil
+}
+
+// 新块到达时订单可能生效或者过期，刷新所有深度及行情
+func (s *WebsocketServiceImpl) handleNewBlock(input eventemitter.EventData) error {
+	s.mtx.Lock()
+	for _, sub := range s.subscribers {
+		if sub.typ == depthSubscription {
+			s.dirtyMarkets[sub.market] = true
+		}
+	}
+	s.dirtyTickers = true
+	s.mtx.Unlock()
+	return nil
+}
+
+func (s *WebsocketServiceImpl) flush() {
+	s.mtx.Lock()
+	dirtyMarkets := s.dirtyMarkets
+	dirtyOrders := s.dirtyOrders
+	dirtyTickers := s.dirtyTickers
+	s.dirtyMarkets = make(map[string]bool)
+	s.dirtyOrders = make(map[common.Hash]bool)
+	s.dirtyTickers = false
+	s.mtx.Unlock()
+
+	orderSubs := s.subscribersOf(orderSubscription)
+	for hash := range dirtyOrders {
+		state, err := s.jsonrpc.orderManager.GetOrderByHash(hash)
+		if err != nil {
+			log.Debugf("websocket,get order %s error:%s", hash.Hex(), err.Error())
+			continue
+		}
+		if mkt, err := util.WrapMarketByAddress(state.RawOrder.TokenB.Hex(), state.RawOrder.TokenS.Hex()); err == nil {
+			dirtyMarkets[mkt] = true
+		}
+
+		owner := strings.ToLower(state.RawOrder.Owner.Hex())
+		for _, sub := range orderSubs {
+			if sub.owner == owner {
+				s.notify(sub, orderStateToJson(*state))
+			}
+		}
+	}
+
+	for _, sub := range s.subscribersOf(depthSubscription) {
+		if !sub.initial && !dirtyMarkets[sub.market] {
+			continue
+		}
+		depth, err := s.jsonrpc.GetDepth(context.Background(), DepthQuery{Length: sub.length, Precision: sub.precision, ContractVersion: sub.contractVersion, Market: sub.market})
+		if err != nil {
+			log.Debugf("websocket,get depth of %s error:%s", sub.market, err.Error())
+			continue
+		}
+		sub.initial = false
+		s.notify(sub, depth)
+	}
+
+	for _, sub := range s.subscribersOf(tickerSubscription) {
+		if !sub.initial && !dirtyTickers {
+			continue
+		}
+		tickers, err := s.jsonrpc.GetTicker(context.Background(), sub.contractVersion)
+		if err != nil {
+			log.Debugf("websocket,get tickers error:%s", err.Error())
+			continue
+		}
+		sub.initial = false
+		s.notify(sub, tickers)
+	}
+}
+
+func (s *WebsocketServiceImpl) subscribersOf(typ subscriptionType) []*subscriber {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+
+	var list []*subscriber
+	for _, sub := range s.subscribers {
+		if sub.typ == typ {
+			list = append(list, sub)
+		}
+	}
+	return list
+}
+
+func (s *WebsocketServiceImpl) notify(sub *subscriber, data interface{}) {
+	if err := sub.notifier.Notify(sub.id, data); err != nil {
+		log.Debugf("websocket,notify subscription %s error:%s", sub.id, err.Error())
+	}
+}
@@ -317,6 +317,15 @@ func (a *AccountManager) updateAllowance(event types.ApprovalEvent) error {
 	return nil
 }
 
+// GetAllowance 返回token对delegate的授权数量，未查询到时为0
+func (account *Account) GetAllowance(contractVersion, token string) *big.Int {
+	allowance, ok := account.Allowances[buildAllowanceKey(contractVersion, token)]
+	if !ok || allowance.allowance == nil {
+		return big.NewInt(0)
+	}
+	return new(big.Int).Set(allowance.allowance)
+}
+
 func (account *Account) ToJsonObject(contractVersion string) AccountJson {
 
 	var accountJson AccountJson
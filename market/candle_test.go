@@ -0,0 +1,110 @@
+/*
+
+  Copyright 2017 Loopring Project Ltd (Loopring Foundation).
+
+  Licensed under the Apache License, Version 2.0 (the "License");
+  you may not use this file except in compliance with the License.
+  You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+  Unless required by applicable law or agreed to in writing, software
+  distributed under the License is distributed on an "AS IS" BASIS,
+  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+  See the License for the specific language governing permissions and
+  limitations under the License.
+
+*/
+
+package market
+
+import (
+	"math/big"
+	"testing"
+
+	"github.com/Loopring/relay/dao"
+	"github.com/Loopring/relay/market/util"
+	"github.com/Loopring/relay/types"
+	"github.com/ethereum/go-ethereum/common"
+)
+
+func TestBucketStart(t *testing.T) {
+	// 2018-03-07 10:37:21 UTC, 周三
+	ts := int64(1520419041)
+	cases := map[string]int64{
+		OneMinute:     1520419020,
+		FiveMinute:    1520418900,
+		FifteenMinute: 1520418600,
+		OneHour:       1520416800,
+		FourHour:      1520409600,
+		OneDay:        1520380800,
+		OneWeek:       1520208000, // 2018-03-05 00:00:00 UTC, 周一
+	}
+	for interval, expected := range cases {
+		if start := bucketStart(interval, ts); start != expected {
+			t.Errorf("%s bucket of %d is %d, expected %d", interval, ts, start, expected)
+		}
+		if start := bucketStart(interval, expected); start != expected {
+			t.Errorf("%s bucket of its own start %d is %d", interval, expected, start)
+		}
+	}
+}
+
+func TestCandleBuilder(t *testing.T) {
+	lrc := common.HexToAddress("0x01")
+	weth := common.HexToAddress("0x02")
+	decimals := new(big.Int).Exp(big.NewInt(10), big.NewInt(18), nil)
+	util.AllTokens = map[string]types.Token{
+		"LRC":  {Protocol: lrc, Symbol: "LRC", Decimals: decimals},
+		"WETH": {Protocol: weth, Symbol: "WETH", Decimals: decimals, IsMarket: true},
+	}
+	util.SupportTokens = map[string]types.Token{"LRC": util.AllTokens["LRC"]}
+
+	// 卖出amountLrc个LRC换取amountWeth个WETH
+	fill := func(ts int64, amountLrc, amountWeth string) dao.FillEvent {
+		return dao.FillEvent{CreateTime: ts, TokenS: lrc.Hex(), TokenB: weth.Hex(), AmountS: amountLrc + "000000000000000000", AmountB: amountWeth + "000000000000000000"}
+	}
+
+	minute := newCandleBuilder("LRC-WETH", OneMinute)
+	for _, f := range []dao.FillEvent{
+		fill(1520419020, "100", "1"),
+		fill(1520419050, "100", "3"),
+		fill(1520419079, "100", "2"),
+		fill(1520419140, "50", "2"),
+	} {
+		minute.addFill(f)
+	}
+
+	if len(minute.candles) != 2 {
+		t.Fatalf("expected 2 minute candles, got %d", len(minute.candles))
+	}
+	c := minute.candles[0]
+	if c.Start != 1520419020 || c.End != 1520419079 {
+		t.Errorf("unexpected candle range [%d,%d]", c.Start, c.End)
+	}
+	if c.Open != 0.01 || c.High != 0.03 || c.Low != 0.01 || c.Close != 0.02 {
+		t.Errorf("unexpected candle prices %f %f %f %f", c.Open, c.High, c.Low, c.Close)
+	}
+	if c.Vol != 6 || c.Amount != 300 {
+		t.Errorf("unexpected candle vol %f amount %f", c.Vol, c.Amount)
+	}
+
+	// 5m k线由1m k线合并，结果应与直接用成交聚合相同
+	five := newCandleBuilder("LRC-WETH", FiveMinute)
+	for _, candle := range minute.candles {
+		five.addCandle(candle)
+	}
+	if len(five.candles) != 1 {
+		t.Fatalf("expected 1 five minute candle, got %d", len(five.candles))
+	}
+	c = five.candles[0]
+	if c.Start != 1520418900 || c.End != 1520419199 {
+		t.Errorf("unexpected candle range [%d,%d]", c.Start, c.End)
+	}
+	if c.Open != 0.01 || c.High != 0.04 || c.Low != 0.01 || c.Close != 0.04 {
+		t.Errorf("unexpected candle prices %f %f %f %f", c.Open, c.High, c.Low, c.Close)
+	}
+	if c.Vol != 8 || c.Amount != 350 {
+		t.Errorf("unexpected candle vol %f amount %f", c.Vol, c.Amount)
+	}
+}
@@ -25,6 +25,7 @@ import (
 	"github.com/Loopring/relay/eventemiter"
 	"github.com/Loopring/relay/market/util"
 	"github.com/Loopring/relay/types"
+	"github.com/jinzhu/gorm"
 	"github.com/patrickmn/go-cache"
 	"github.com/robfig/cron"
 	"log"
@@ -32,15 +33,70 @@ import (
 	"strconv"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 )
 
+// k线周期
 const (
-	OneHour = "1Hr"
-	//TwoHour = "2Hr"
-	//OneDay = "1Day"
+	OneMinute     = "1m"
+	FiveMinute    = "5m"
+	FifteenMinute = "15m"
+	OneHour       = "1h"
+	FourHour      = "4h"
+	OneDay        = "1d"
+	OneWeek       = "1w"
 )
 
+var trendIntervals = []string{OneMinute, FiveMinute, FifteenMinute, OneHour, FourHour, OneDay, OneWeek}
+
+var intervalSeconds = map[string]int64{
+	OneMinute:     60,
+	FiveMinute:    5 * 60,
+	FifteenMinute: 15 * 60,
+	OneHour:       60 * 60,
+	FourHour:      4 * 60 * 60,
+	OneDay:        24 * 60 * 60,
+	OneWeek:       7 * 24 * 60 * 60,
+}
+
+// 1970-01-05是周一,周线以周一0点(UTC)为起点
+const weekOffset = 4 * 24 * 60 * 60
+
+// 单次查询最多返回的k线数量
+const MaxTrendCount = 1000
+
+// 聚合k线时每次读取的成交数量
+const fillPageSize = 1000
+
+// 旧版本1小时k线的周期名
+const legacyOneHour = "1Hr"
+
+// IsValidInterval reports whether interval is a supported candle interval
+func IsValidInterval(interval string) bool {
+	_, ok := intervalSeconds[interval]
+	return ok
+}
+
+// IntervalSeconds returns the length of interval in seconds, 0 if unsupported
+func IntervalSeconds(interval string) int64 {
+	return intervalSeconds[interval]
+}
+
+// bucketStart 返回ts所在周期的起始时间
+func bucketStart(interval string, ts int64) int64 {
+	d := intervalSeconds[interval]
+	offset := int64(0)
+	if interval == OneWeek {
+		offset = weekOffset
+	}
+	mod := (ts - offset) % d
+	if mod < 0 {
+		mod += d
+	}
+	return ts - mod
+}
+
 type Ticker struct {
 	Market    string  `json:"market"`
 	Intervals string  `json:"interval"`
@@ -80,6 +136,12 @@ type TrendManager struct {
 	cacheReady bool
 	rds        dao.RdsService
 	cron       *cron.Cron
+	mtx        *sync.Mutex
+	lastFillId int
+	// 分叉后需要重算的起始时间，0表示没有
+	forkFrom *int64
+	// syncTrends正在执行时为1，cron不会并发执行，上一次未结束时跳过
+	syncing *int32
 }
 
 var once sync.Once
@@ -91,12 +153,20 @@ const tickerKey = "market_ticker_view"
 func NewTrendManager(dao dao.RdsService) TrendManager {
 
 	once.Do(func() {
-		trendManager = TrendManager{rds: dao, cron: cron.New()}
+		trendManager = TrendManager{rds: dao, cron: cron.New(), mtx: &sync.Mutex{}, forkFrom: new(int64), syncing: new(int32)}
 		trendManager.c = cache.New(cache.NoExpiration, cache.NoExpiration)
+		if err := trendManager.rds.RenameTrendIntervals(legacyOneHour, OneHour); err != nil {
+			log.Println(err)
+		}
+		if err := trendManager.backfill(); err != nil {
+			log.Println(err)
+		}
 		trendManager.refreshCache()
 		trendManager.startScheduleUpdate()
 		fillOrderWatcher := &eventemitter.Watcher{Concurrent: false, Handle: trendManager.handleOrderFilled}
 		eventemitter.On(eventemitter.OrderManagerExtractorFill, fillOrderWatcher)
+		forkWatcher := &eventemitter.Watcher{Concurrent: false, Handle: trendManager.handleFork}
+		eventemitter.On(eventemitter.ChainForkProcess, forkWatcher)
 		//trendManager.startScheduleUpdate()
 	})
 
@@ -105,8 +175,8 @@ func NewTrendManager(dao dao.RdsService) TrendManager {
 
 // ======> init cache steps
 // step.1 init all market
-// step.2 get all trend record into cache
-// step.3 get all order fillFilled into cache
+// step.2 get closed 1h trends of last 24 hours into cache
+// step.3 get all order fillFilled of current hour into cache
 // step.4 calculate 24hr ticker
 // step.5 send channel cache ready
 // step.6 start schedule update
@@ -122,33 +192,28 @@ func (t *TrendManager) refreshCache() {
 		mktCache.Trends = make([]Trend, 0)
 		mktCache.Fills = make([]dao.FillEvent, 0)
 
-		// default 100 records load first time
-		trends, err := t.rds.TrendPageQuery(dao.Trend{Market: mkt}, 1, 100)
-
+		now := time.Now().Unix()
+		thisHour := bucketStart(OneHour, now)
+		trends, err := t.rds.TrendQueryByRange(OneHour, mkt, thisHour-24*60*60, thisHour-1)
 		if err != nil {
 			log.Println(err)
 			return
 		}
 
-		for _, trend := range trends.Data {
-			mktCache.Trends = append(mktCache.Trends, ConvertUp(trend.(dao.Trend)))
+		for _, trend := range trends {
+			mktCache.Trends = append(mktCache.Trends, ConvertUp(trend))
 		}
 
-		now := time.Now()
-		firstSecondThisHour := time.Date(now.Year(), now.Month(), now.Day(), now.Hour(), 0, 1, 0, now.Location())
-		fills, err := t.rds.QueryRecentFills(mkt, "", firstSecondThisHour.Unix(), 0)
-		if err != nil {
+		if err := forEachFill(t.rds, mkt, thisHour, now, func(f dao.FillEvent) {
+			mktCache.Fills = append(mktCache.Fills, f)
+		}); err != nil {
 			log.Println(err)
 			return
 		}
 
-		for _, f := range fills {
-			mktCache.Fills = append(mktCache.Fills, f)
-		}
-
 		trendMap[mkt] = mktCache
 
-		ticker := calculateTicker(mkt, fills, mktCache.Trends, firstSecondThisHour)
+		ticker := calculateTicker(mkt, mktCache.Fills, mktCache.Trends, time.Unix(thisHour, 0))
 		tickerMap[mkt] = ticker
 	}
 	t.c.Set(trendKey, trendMap, cache.NoExpiration)
@@ -247,230 +312,317 @@ func calculateTicker(market string, fills []dao.FillEvent, trends []Trend, now t
 }
 
 func (t *TrendManager) startScheduleUpdate() {
-	t.cron.AddFunc("10 0 * * * *", t.insertTrend)
+	t.cron.AddFunc("5 * * * * *", t.syncTrends)
 	t.cron.Start()
 }
 
-func (t *TrendManager) insertTrend() {
-	// get latest 24 hour trend if not exist generate
+// backfill 启动时从各周期最新一根k线开始，用成交记录补齐缺失的k线
+func (t *TrendManager) backfill() error {
+	log.Println("start backfill trends......")
 
-	fmt.Println("start insert trend cron job")
-
-	var wg sync.WaitGroup
+	lastFillId, err := t.rds.GetMaxFillID()
+	if err != nil {
+		return err
+	}
 
+	now := time.Now().Unix()
 	for _, mkt := range util.AllMarkets {
-		now := time.Now()
-		firstSecondThisHour := time.Date(now.Year(), now.Month(), now.Day(), now.Hour(), 0, 1, 0, now.Location())
+		from := now
+		for _, interval := range trendIntervals {
+			latest, err := t.rds.GetLatestTrend(interval, mkt)
+			if err == gorm.ErrRecordNotFound {
+				// 该周期还没有k线，从第一笔成交开始
+				fills, err := t.rds.QueryFillsByTime(mkt, 0, 0, now, 1)
+				if err != nil {
+					return err
+				}
+				if len(fills) > 0 && fills[0].CreateTime < from {
+					from = fills[0].CreateTime
+				}
+				break
+			} else if err != nil {
+				return err
+			}
+			if latest.Start < from {
+				from = latest.Start
+			}
+		}
 
-		wg.Add(1)
-		go func(tmpMkt string) {
-			for i := 24; i >= 1; i-- {
+		if err := t.rebuildTrends(mkt, from, now); err != nil {
+			return err
+		}
+	}
 
-				start := firstSecondThisHour.Unix() - int64(i*60*60)
-				end := firstSecondThisHour.Unix() - int64((i-1)*60*60) - 1
+	t.lastFillId = lastFillId
+	return nil
+}
 
-				trends, _ := t.rds.TrendQueryByTime(OneHour, tmpMkt, start, end)
-				if len(trends) > 0 {
-					log.Println("current interval trend exsit")
-					wg.Done()
-					return
-				}
+// handleFork 分叉时order manager会并发回滚成交，这里只记录分叉块的时间，
+// 由下一次syncTrends在回滚完成后重算所有市场的k线和24小时ticker
+func (t *TrendManager) handleFork(input eventemitter.EventData) error {
+	event := input.(*types.ForkedEvent)
 
-				lastTrends, _ := t.rds.TrendQueryByTime(OneHour, tmpMkt, start-int64(60*60), end-int64(60*60))
-				if len(lastTrends) > 1 {
-					log.Println("found more than one last trend!")
-					wg.Done()
-					return
-				} else if len(lastTrends) == 0 {
-					log.Println("not found last trend!")
-				}
+	from := time.Now().Unix() - 24*60*60
+	if block, err := t.rds.FindBlockByHash(event.ForkHash); err != nil {
+		log.Printf("trend manager,fork block %s not found, rebuild trends of last 24 hours:%s", event.ForkHash.Hex(), err.Error())
+	} else {
+		from = block.CreateTime
+	}
 
-				if trends == nil || len(trends) == 0 {
-					fills, fillsErr := t.rds.QueryRecentFills(tmpMkt, "", start, end)
-
-					if fillsErr != nil {
-						continue
-					}
-
-					toInsert := &dao.Trend{
-						Intervals:  OneHour,
-						Market:     tmpMkt,
-						CreateTime: time.Now().Unix(),
-						Start:      start,
-						End:        end}
-
-					var (
-						vol    float64
-						amount float64
-						open   float64
-						low    float64
-					)
-
-					if len(lastTrends) == 0 {
-						toInsert.Open = 0
-						toInsert.Close = 0
-						toInsert.High = 0
-						toInsert.Low = 0
-					} else {
-						toInsert.Open = lastTrends[0].Close
-						toInsert.Close = lastTrends[0].Close
-						toInsert.High = lastTrends[0].Close
-						toInsert.Low = lastTrends[0].Close
-					}
-
-					sort.Slice(fills, func(i, j int) bool {
-						return fills[i].CreateTime < fills[j].CreateTime
-					})
-
-					for _, data := range fills {
-
-						if util.IsBuy(data.TokenS) {
-							vol += util.StringToFloat(data.AmountB)
-							amount += util.StringToFloat(data.AmountS)
-						} else {
-							vol += util.StringToFloat(data.AmountS)
-							amount += util.StringToFloat(data.AmountB)
-						}
-
-						price := util.CalculatePrice(data.AmountS, data.AmountB, data.TokenS, data.TokenB)
-
-						if open == 0 && price != 0 {
-							open = price
-						}
-
-						if toInsert.High == 0 || toInsert.High < price {
-							toInsert.High = price
-						}
-						if low == 0 || low > price {
-							low = price
-						}
-						toInsert.Close = price
-					}
-
-					if toInsert.Open != 0 && open != 0 {
-						toInsert.Open = open
-					}
-
-					toInsert.Low = low
-					toInsert.Vol = vol
-					toInsert.Amount = amount
-
-					if err := t.rds.Add(toInsert); err != nil {
-						fmt.Println(err)
-					}
-				}
-			}
-			wg.Done()
-		}(mkt)
+	t.mtx.Lock()
+	if *t.forkFrom == 0 || from < *t.forkFrom {
+		*t.forkFrom = from
 	}
-	wg.Wait()
-	t.refreshCache()
+	t.mtx.Unlock()
+
+	return nil
 }
 
-func (t *TrendManager) aggregate(fills []dao.FillEvent, trends []Trend) (trend Trend, err error) {
+// syncTrends 定时用新增的成交重算受影响的k线
+func (t *TrendManager) syncTrends() {
+	const pageSize = 1000
 
-	if len(fills) == 0 && len(trends) == 0 {
-		err = errors.New("fills can't be nil")
+	if !atomic.CompareAndSwapInt32(t.syncing, 0, 1) {
+		log.Println("trend manager,last sync of trends is not finished, skip")
 		return
 	}
+	defer atomic.StoreInt32(t.syncing, 0)
+
+	t.mtx.Lock()
+	forkFrom := *t.forkFrom
+	*t.forkFrom = 0
+	t.mtx.Unlock()
+
+	if forkFrom > 0 {
+		now := time.Now().Unix()
+		for _, mkt := range util.AllMarkets {
+			if err := t.rebuildTrends(mkt, forkFrom, now); err != nil {
+				log.Println(err)
+			}
+		}
+	}
 
-	now := time.Now()
-	firstSecondThisHour := time.Date(now.Year(), now.Month(), now.Day(), now.Hour(), 0, 1, 0, now.Location())
-	lastSecondThisHour := firstSecondThisHour.Unix() + 60*60 - 1
-
-	if len(fills) == 0 {
-		lastTrend := trends[len(trends)-1]
-		return Trend{
-			Intervals:  OneHour,
-			Market:     lastTrend.Market,
-			CreateTime: time.Now().Unix(),
-			Start:      firstSecondThisHour.Unix(),
-			End:        lastSecondThisHour,
-			High:       lastTrend.High,
-			Low:        lastTrend.Low,
-			Vol:        0,
-			Amount:     0,
-			Open:       lastTrend.Open,
-			Close:      lastTrend.Close,
-		}, nil
+	for {
+		fills, err := t.rds.QueryFillsAfterID(t.lastFillId, pageSize)
+		if err != nil {
+			log.Println(err)
+			return
+		}
+
+		ranges := make(map[string][2]int64)
+		for _, f := range fills {
+			if r, ok := ranges[f.Market]; !ok {
+				ranges[f.Market] = [2]int64{f.CreateTime, f.CreateTime}
+			} else {
+				if f.CreateTime < r[0] {
+					r[0] = f.CreateTime
+				}
+				if f.CreateTime > r[1] {
+					r[1] = f.CreateTime
+				}
+				ranges[f.Market] = r
+			}
+		}
+
+		for mkt, r := range ranges {
+			if err := t.rebuildTrends(mkt, r[0], r[1]); err != nil {
+				log.Println(err)
+				return
+			}
+		}
+
+		if len(fills) > 0 {
+			t.lastFillId = fills[len(fills)-1].ID
+		}
+		if len(fills) < pageSize {
+			break
+		}
 	}
 
-	trend = Trend{
-		Intervals:  OneHour,
-		Market:     fills[0].Market,
-		CreateTime: time.Now().Unix(),
-		Start:      firstSecondThisHour.Unix(),
-		End:        lastSecondThisHour,
+	t.refreshCache()
+}
+
+func (t *TrendManager) rebuildTrends(market string, from, to int64) error {
+	t.mtx.Lock()
+	defer t.mtx.Unlock()
+
+	return RebuildTrends(t.rds, market, from, to)
+}
+
+// RebuildTrends 用成交记录重算market在[from,to]时间内所有周期的k线，按周分段，每段在同一事务中替换。
+// 某一周失败时之前的周已经替换，返回的错误给出从该周继续的from
+func RebuildTrends(rds dao.RdsService, market string, from, to int64) error {
+	week := intervalSeconds[OneWeek]
+	for weekStart := bucketStart(OneWeek, from); weekStart <= to; weekStart += week {
+		start, end := from, to
+		if start < weekStart {
+			start = weekStart
+		}
+		if weekEnd := weekStart + week - 1; end > weekEnd {
+			end = weekEnd
+		}
+		if err := rebuildWeekTrends(rds, market, start, end); err != nil {
+			return fmt.Errorf("rebuild trends of %s from %d error:%s, trends before it are replaced, rebuild from %d to resume", market, start, err.Error(), start)
+		}
 	}
+	return nil
+}
 
+// rebuildWeekTrends 重算同一周内[from,to]的k线，1m k线由成交生成，
+// 其余周期都是上一周期的整数倍，由上一周期的k线合并，只需读取重算范围两侧少量已有的k线
+func rebuildWeekTrends(rds dao.RdsService, market string, from, to int64) error {
 	var (
-		high   float64
-		low    float64
-		vol    float64
-		amount float64
+		ranges []dao.TrendRange
+		trends []dao.Trend
+		lower  []dao.Trend
 	)
 
-	sort.Slice(fills, func(i, j int) bool {
-		return fills[i].CreateTime < fills[j].CreateTime
-	})
-
-	for _, data := range fills {
+	var lowerStart, lowerEnd int64
+	for i, interval := range trendIntervals {
+		start := bucketStart(interval, from)
+		end := bucketStart(interval, to) + intervalSeconds[interval] - 1
+		builder := newCandleBuilder(market, interval)
 
-		if util.IsBuy(data.TokenS) {
-			vol += util.StringToFloat(data.AmountB)
-			amount += util.StringToFloat(data.AmountS)
+		if i == 0 {
+			if err := forEachFill(rds, market, start, end, builder.addFill); err != nil {
+				return err
+			}
 		} else {
-			vol += util.StringToFloat(data.AmountS)
-			amount += util.StringToFloat(data.AmountB)
+			source := trendIntervals[i-1]
+			var before, after []dao.Trend
+			var err error
+			if start < lowerStart {
+				if before, err = rds.TrendQueryByRange(source, market, start, lowerStart-1); err != nil {
+					return err
+				}
+			}
+			if end > lowerEnd {
+				if after, err = rds.TrendQueryByRange(source, market, lowerEnd+1, end); err != nil {
+					return err
+				}
+			}
+			for _, list := range [][]dao.Trend{before, lower, after} {
+				for _, c := range list {
+					builder.addCandle(c)
+				}
+			}
 		}
 
-		price := util.CalculatePrice(data.AmountS, data.AmountB, data.TokenS, data.TokenB)
+		lower, lowerStart, lowerEnd = builder.candles, start, end
+		ranges = append(ranges, dao.TrendRange{Intervals: interval, Start: start, End: end})
+		trends = append(trends, lower...)
+	}
 
-		if high == 0 || high < price {
-			high = price
+	return rds.ReplaceTrends(market, ranges, trends)
+}
+
+// forEachFill 分页读取market在[start,end]内的成交，按时间升序交给handle
+func forEachFill(rds dao.RdsService, market string, start, end int64, handle func(fill dao.FillEvent)) error {
+	afterId := 0
+	for {
+		fills, err := rds.QueryFillsByTime(market, start, afterId, end, fillPageSize)
+		if err != nil {
+			return err
 		}
-		if low == 0 || low > price {
-			low = price
+		for _, f := range fills {
+			handle(f)
+		}
+		if len(fills) < fillPageSize {
+			return nil
 		}
+		last := fills[len(fills)-1]
+		start, afterId = last.CreateTime, last.ID
 	}
+}
 
-	trend.High = high
-	trend.Low = low
+// candleBuilder 将按时间升序的成交或低一级周期的k线聚合为interval周期的k线，没有成交的周期不生成k线
+type candleBuilder struct {
+	market     string
+	interval   string
+	createTime int64
+	candles    []dao.Trend
+}
 
-	openFill := fills[0]
-	trend.Open = util.CalculatePrice(openFill.AmountS, openFill.AmountB, openFill.TokenS, openFill.TokenB)
-	closeFill := fills[len(fills)-1]
-	trend.Close = util.CalculatePrice(closeFill.AmountS, closeFill.AmountB, closeFill.TokenS, closeFill.TokenB)
+func newCandleBuilder(market, interval string) *candleBuilder {
+	return &candleBuilder{market: market, interval: interval, createTime: time.Now().Unix()}
+}
 
-	trend.Vol = vol
-	trend.Amount = amount
+func (b *candleBuilder) current(ts int64) *dao.Trend {
+	bucket := bucketStart(b.interval, ts)
+	if n := len(b.candles); n == 0 || b.candles[n-1].Start != bucket {
+		b.candles = append(b.candles, dao.Trend{
+			Intervals:  b.interval,
+			Market:     b.market,
+			CreateTime: b.createTime,
+			Start:      bucket,
+			End:        bucket + intervalSeconds[b.interval] - 1,
+		})
+	}
+	return &b.candles[len(b.candles)-1]
+}
 
-	return
+func (b *candleBuilder) addFill(data dao.FillEvent) {
+	current := b.current(data.CreateTime)
+	if util.IsBuy(data.TokenS) {
+		current.Vol += util.StringToFloat(data.AmountB)
+		current.Amount += util.StringToFloat(data.AmountS)
+	} else {
+		current.Vol += util.StringToFloat(data.AmountS)
+		current.Amount += util.StringToFloat(data.AmountB)
+	}
+
+	price := util.CalculatePrice(data.AmountS, data.AmountB, data.TokenS, data.TokenB)
+	updateCandlePrice(current, price, price, price, price)
 }
 
-func (t *TrendManager) GetTrends(market string) (trends []Trend, err error) {
+func (b *candleBuilder) addCandle(src dao.Trend) {
+	current := b.current(src.Start)
+	current.Vol += src.Vol
+	current.Amount += src.Amount
+	updateCandlePrice(current, src.Open, src.High, src.Low, src.Close)
+}
 
+// updateCandlePrice 价格为0的成交只计入成交量
+func updateCandlePrice(c *dao.Trend, open, high, low, close float64) {
+	if close == 0 {
+		return
+	}
+	if c.Open == 0 {
+		c.Open = open
+	}
+	if c.High == 0 || c.High < high {
+		c.High = high
+	}
+	if c.Low == 0 || c.Low > low {
+		c.Low = low
+	}
+	c.Close = close
+}
+
+// GetTrends 返回market在interval周期下start在[start,end]之间的k线，按时间升序
+func (t *TrendManager) GetTrends(market, interval string, start, end int64) (trends []Trend, err error) {
 	market = strings.ToUpper(market)
 
-	if t.cacheReady {
-		if trendCache, ok := t.c.Get(trendKey); !ok {
-			err = errors.New("can't found trends by key : " + trendKey)
-		} else {
-			tc := trendCache.(map[string]Cache)[market]
-			trends = make([]Trend, 0)
-			trendInFills, aggErr := t.aggregate(tc.Fills, tc.Trends)
-			if aggErr == nil {
-				trends = append(trends, trendInFills)
-			}
-			for _, t := range tc.Trends {
-				trends = append(trends, t)
-			}
+	if !IsValidInterval(interval) {
+		return nil, fmt.Errorf("unsupported trend interval %s", interval)
+	}
+	if end < start {
+		return nil, fmt.Errorf("trend end %d is before start %d", end, start)
+	}
+	if (end-start)/intervalSeconds[interval] >= MaxTrendCount {
+		return nil, fmt.Errorf("trend range is too large, at most %d %s candles", MaxTrendCount, interval)
+	}
 
-		}
-	} else {
-		err = errors.New("cache is not ready , please access later")
+	list, err := t.rds.TrendQueryByRange(interval, market, bucketStart(interval, start), end)
+	if err != nil {
+		return nil, err
 	}
-	return
+
+	trends = make([]Trend, 0, len(list))
+	for _, v := range list {
+		trends = append(trends, ConvertUp(v))
+	}
+	return trends, nil
 }
 
 func (t *TrendManager) GetTicker() (tickers []Ticker, err error) {
@@ -534,9 +686,8 @@ func (t *TrendManager) handleOrderFilled(input eventemitter.EventData) (err erro
 func (t *TrendManager) reCalTicker(market string) {
 	trendInCache, _ := t.c.Get(trendKey)
 	mktCache := trendInCache.(map[string]Cache)[market]
-	now := time.Now()
-	firstSecondThisHour := time.Date(now.Year(), now.Month(), now.Day(), now.Hour(), 0, 1, 0, now.Location())
-	ticker := calculateTicker(market, mktCache.Fills, mktCache.Trends, firstSecondThisHour)
+	thisHour := bucketStart(OneHour, time.Now().Unix())
+	ticker := calculateTicker(market, mktCache.Fills, mktCache.Trends, time.Unix(thisHour, 0))
 	tickerInCache, _ := t.c.Get(tickerKey)
 	tickerMap := tickerInCache.(map[string]Ticker)
 	tickerMap[market] = ticker
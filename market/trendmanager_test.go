@@ -1,3 +1,6 @@
+//go:build integration
+// +build integration
+
 /*
 
   Copyright 2017 Loopring Project Ltd (Loopring Foundation).
@@ -49,7 +52,7 @@ func prepare() {
 func TestTrendManager_GetTicker(t *testing.T) {
 	t.Log("ZZZZZZZZZZZZZZZZZZZZZZZZZZ")
 	prepare()
-	tm.GetTrends("RDN-WETH")
+	tm.GetTrends("RDN-WETH", market.OneHour, time.Now().Unix()-24*60*60, time.Now().Unix())
 	fill := &types.OrderFilledEvent{}
 	fill.Market = "LRC-WETH"
 	fill.Time = big.NewInt(1513319197)
@@ -79,7 +82,7 @@ func TestTrendManager_GetTicker(t *testing.T) {
 	eventemitter.Emit(eventemitter.OrderManagerExtractorFill, fill)
 	time.Sleep(3 * time.Second)
 	fmt.Println("xxxxxxxxxxx")
-	fmt.Println(tm.GetTrends("RDN-WETH"))
+	fmt.Println(tm.GetTrends("RDN-WETH", market.OneHour, time.Now().Unix()-24*60*60, time.Now().Unix()))
 
 	fmt.Println(tm.GetTicker())
 	t.Error("fuckfuckfuck")
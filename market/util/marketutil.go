@@ -57,6 +57,7 @@ var (
 	SupportTokens         map[string]types.Token // token symbol to entity
 	AllTokens             map[string]types.Token
 	SupportMarkets        map[string]types.Token // token symbol to contract hex address
+	DeniedTokens          map[string]types.Token // denied tokens and markets, token symbol to entity
 	AllMarkets            []string
 	AllTokenPairs         []TokenPair
 	ContractVersionConfig = map[string]string{}
@@ -67,6 +68,7 @@ func StartRefreshCron(rds dao.RdsService) {
 	mktCron.AddFunc("1 0/10 * * * *", func() {
 		log.Info("start market util refresh.....")
 		SupportTokens, SupportMarkets, AllTokens, AllMarkets, AllTokenPairs = getTokenAndMarketFromDB(rds)
+		DeniedTokens = getDeniedTokensFromDB(rds)
 	})
 	mktCron.Start()
 }
@@ -140,6 +142,27 @@ func getTokenAndMarketFromDB(rds dao.RdsService) (
 	return
 }
 
+func getDeniedTokensFromDB(rds dao.RdsService) map[string]types.Token {
+	deniedTokens := make(map[string]types.Token)
+
+	tokens, err := rds.FindDeniedTokens()
+	if err != nil {
+		log.Errorf("market util,find denied tokens error:%s", err.Error())
+	}
+	markets, err := rds.FindDeniedMarkets()
+	if err != nil {
+		log.Errorf("market util,find denied markets error:%s", err.Error())
+	}
+
+	for _, v := range append(tokens, markets...) {
+		var token types.Token
+		v.ConvertUp(&token)
+		deniedTokens[token.Symbol] = token
+	}
+
+	return deniedTokens
+}
+
 func Initialize(rds dao.RdsService, contracts map[string]string) {
 
 	SupportTokens = make(map[string]types.Token)
@@ -147,6 +170,7 @@ func Initialize(rds dao.RdsService, contracts map[string]string) {
 	AllTokens = make(map[string]types.Token)
 
 	SupportTokens, SupportMarkets, AllTokens, AllMarkets, AllTokenPairs = getTokenAndMarketFromDB(rds)
+	DeniedTokens = getDeniedTokensFromDB(rds)
 
 	ContractVersionConfig = contracts
 
@@ -171,11 +195,17 @@ func TokenRegister(input eventemitter.EventData) error {
 	// todo: how to get source token.Source = ""
 	SupportTokens[token.Symbol] = token
 	AllTokens[token.Symbol] = token
+	delete(DeniedTokens, token.Symbol)
 
 	pairsMap := make(map[string]TokenPair, 0)
 	for _, v := range SupportMarkets {
 		pairsMap[v.Symbol+"-"+token.Symbol] = TokenPair{v.Protocol, token.Protocol}
 		pairsMap[token.Symbol+"-"+v.Symbol] = TokenPair{token.Protocol, v.Protocol}
+
+		market := token.Symbol + "-" + v.Symbol
+		if !containsMarket(AllMarkets, market) {
+			AllMarkets = append(AllMarkets, market)
+		}
 	}
 	for _, v := range pairsMap {
 		AllTokenPairs = append(AllTokenPairs, v)
@@ -198,9 +228,27 @@ func TokenUnRegister(input eventemitter.EventData) error {
 	}
 	AllTokenPairs = list
 
+	var markets []string
+	for _, v := range AllMarkets {
+		if s, _ := UnWrap(v); s == strings.ToUpper(evt.Symbol) {
+			continue
+		}
+		markets = append(markets, v)
+	}
+	AllMarkets = markets
+
 	return nil
 }
 
+func containsMarket(markets []string, market string) bool {
+	for _, v := range markets {
+		if v == market {
+			return true
+		}
+	}
+	return false
+}
+
 func WethTokenAddress() common.Address {
 	return AllTokens["WETH"].Protocol
 }
@@ -318,6 +366,11 @@ func getContractVersion(address string) string {
 	return ""
 }
 
+// ContractVersion returns the version of protocol address, empty if unsupported
+func ContractVersion(address common.Address) string {
+	return getContractVersion(address.Hex())
+}
+
 func IsSupportedContract(address string) bool {
 	return getContractVersion(address) != ""
 }
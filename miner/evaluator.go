@@ -258,6 +258,41 @@ func CVSquare(rateRatios []*big.Int, scale *big.Int) *big.Int {
 	return cvs.Mul(cvs, scale).Div(cvs, avg).Mul(cvs, scale).Div(cvs, avg).Div(cvs, length1)
 }
 
+// LrcFeeOfCost 按computeFeeOfRingAndOrder的方式反推订单需要的lrcFee：
+// 订单完全成交时，lrcFee的法币价值需覆盖环路gas成本分摊到该订单的部分
+func (e *Evaluator) LrcFeeOfCost(protocol common.Address, ringGas, gasPrice *big.Int, ringLength int) (lrcFee *big.Int, legalCost *big.Rat, err error) {
+	implAddress, exists := e.accessor.ProtocolAddresses[protocol]
+	if !exists {
+		return nil, nil, errors.New("Miner,unsupported protocol:" + protocol.Hex())
+	}
+	if ringLength <= 0 {
+		return nil, nil, errors.New("Miner,ring length must be positive")
+	}
+
+	costEth := new(big.Rat).SetInt(new(big.Int).Mul(ringGas, gasPrice))
+	ringCost, err := e.marketCapProvider.LegalCurrencyValueOfEth(costEth)
+	if nil != err {
+		return nil, nil, err
+	}
+	legalCost = new(big.Rat).Quo(ringCost, new(big.Rat).SetInt64(int64(ringLength)))
+
+	// 1个最小单位lrc的法币价值
+	unitValue, err := e.marketCapProvider.LegalCurrencyValue(implAddress.LrcTokenAddress, big.NewRat(1, 1))
+	if nil != err {
+		return nil, nil, err
+	}
+	if unitValue.Sign() <= 0 {
+		return nil, nil, errors.New("Miner,price of lrc is zero")
+	}
+
+	fee := new(big.Rat).Quo(legalCost, unitValue)
+	lrcFee = new(big.Int).Quo(fee.Num(), fee.Denom())
+	if !fee.IsInt() {
+		lrcFee.Add(lrcFee, big.NewInt(1))
+	}
+	return lrcFee, legalCost, nil
+}
+
 func (e *Evaluator) getLegalCurrency(tokenAddress common.Address, amount *big.Rat) *big.Rat {
 	c, _ := e.marketCapProvider.LegalCurrencyValue(tokenAddress, amount)
 	return c
@@ -0,0 +1,110 @@
+/*
+
+  Copyright 2017 Loopring Project Ltd (Loopring Foundation).
+
+  Licensed under the Apache License, Version 2.0 (the "License");
+  you may not use this file except in compliance with the License.
+  You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+  Unless required by applicable law or agreed to in writing, software
+  distributed under the License is distributed on an "AS IS" BASIS,
+  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+  See the License for the specific language governing permissions and
+  limitations under the License.
+
+*/
+
+package miner
+
+import (
+	"math/big"
+	"testing"
+
+	"github.com/Loopring/relay/ethaccessor"
+	"github.com/ethereum/go-ethereum/common"
+)
+
+// testCapProvider eth价格为ethPrice，lrc价格为lrcPrice，均按18位小数换算
+type testCapProvider struct {
+	ethPrice *big.Rat
+	lrcPrice *big.Rat
+}
+
+var weiPerEther = new(big.Rat).SetInt(new(big.Int).Exp(big.NewInt(10), big.NewInt(18), nil))
+
+func (p *testCapProvider) Start() {}
+func (p *testCapProvider) Stop()  {}
+
+func (p *testCapProvider) LegalCurrencyValue(tokenAddress common.Address, amount *big.Rat) (*big.Rat, error) {
+	v := new(big.Rat).Mul(amount, p.lrcPrice)
+	return v.Quo(v, weiPerEther), nil
+}
+
+func (p *testCapProvider) LegalCurrencyValueOfEth(amount *big.Rat) (*big.Rat, error) {
+	v := new(big.Rat).Mul(amount, p.ethPrice)
+	return v.Quo(v, weiPerEther), nil
+}
+
+func (p *testCapProvider) LegalCurrencyValueByCurrency(tokenAddress common.Address, amount *big.Rat, currencyStr string) (*big.Rat, error) {
+	return p.LegalCurrencyValue(tokenAddress, amount)
+}
+
+func (p *testCapProvider) GetMarketCap(tokenAddress common.Address) (*big.Rat, error) {
+	return p.lrcPrice, nil
+}
+
+func (p *testCapProvider) GetEthCap() (*big.Rat, error) {
+	return p.ethPrice, nil
+}
+
+func (p *testCapProvider) GetMarketCapByCurrency(tokenAddress common.Address, currencyStr string) (*big.Rat, error) {
+	return p.lrcPrice, nil
+}
+
+func TestLrcFeeOfCost(t *testing.T) {
+	protocol := common.HexToAddress("0x03E0F73A93993E5101362656Af1162eD80FB54F2")
+	accessor := &ethaccessor.EthNodeAccessor{ProtocolAddresses: map[common.Address]*ethaccessor.ProtocolAddress{
+		protocol: {ContractAddress: protocol, LrcTokenAddress: common.HexToAddress("0xEF68e7C694F40c8202821eDF525dE3782458639f")},
+	}}
+	provider := &testCapProvider{ethPrice: big.NewRat(1000, 1), lrcPrice: big.NewRat(1, 2)}
+	e := NewEvaluator(provider, 0, accessor)
+
+	// 400000 gas * 10 gwei = 0.004 eth = 4，两个订单各分摊2，即4个lrc
+	gasPrice := big.NewInt(10000000000)
+	lrcFee, legalCost, err := e.LrcFeeOfCost(protocol, big.NewInt(400000), gasPrice, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if legalCost.Cmp(big.NewRat(2, 1)) != 0 {
+		t.Errorf("legal cost %s, expected 2", legalCost.FloatString(6))
+	}
+	if expected, _ := new(big.Int).SetString("4000000000000000000", 10); lrcFee.Cmp(expected) != 0 {
+		t.Errorf("lrcFee %s, expected %s", lrcFee.String(), expected.String())
+	}
+
+	// 不能整除时向上取整，保证lrcFee的价值不低于成本
+	lrcFee, legalCost, err = e.LrcFeeOfCost(protocol, big.NewInt(1), big.NewInt(1), 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if legalCost.Cmp(new(big.Rat).Quo(big.NewRat(1000, 3), weiPerEther)) != 0 {
+		t.Errorf("legal cost %s", legalCost.String())
+	}
+	if lrcFee.Cmp(big.NewInt(667)) != 0 {
+		t.Errorf("lrcFee %s, expected 667", lrcFee.String())
+	}
+
+	if _, _, err := e.LrcFeeOfCost(common.HexToAddress("0x01"), big.NewInt(400000), gasPrice, 2); err == nil {
+		t.Errorf("unsupported protocol should fail")
+	}
+	if _, _, err := e.LrcFeeOfCost(protocol, big.NewInt(400000), gasPrice, 0); err == nil {
+		t.Errorf("zero ring length should fail")
+	}
+
+	provider.lrcPrice = new(big.Rat)
+	if _, _, err := e.LrcFeeOfCost(protocol, big.NewInt(400000), gasPrice, 2); err == nil {
+		t.Errorf("zero lrc price should fail")
+	}
+}
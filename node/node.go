@@ -19,7 +19,6 @@
 package node
 
 import (
-	"strconv"
 	"sync"
 
 	"github.com/Loopring/relay/config"
@@ -29,6 +28,7 @@ import (
 	"github.com/Loopring/relay/eventemiter"
 	"github.com/Loopring/relay/extractor"
 	"github.com/Loopring/relay/gateway"
+	"github.com/Loopring/relay/gateway/broadcast"
 	"github.com/Loopring/relay/log"
 	"github.com/Loopring/relay/market"
 	"github.com/Loopring/relay/market/util"
@@ -46,7 +46,7 @@ import (
 type Node struct {
 	globalConfig      *config.GlobalConfig
 	rdsService        dao.RdsService
-	ipfsSubService    gateway.IPFSSubService
+	orderSubscriber   broadcast.Subscriber
 	accessor          *ethaccessor.EthNodeAccessor
 	extractorService  extractor.ExtractorService
 	orderManager      ordermanager.OrderManager
@@ -72,7 +72,7 @@ func (n *RelayNode) Start() {
 }
 
 func (n *RelayNode) Stop() {
-	//
+	n.jsonRpcService.Stop()
 }
 
 type MineNode struct {
@@ -98,12 +98,12 @@ func NewNode(logger *zap.Logger, globalConfig *config.GlobalConfig) *Node {
 	n.registerMarketCap()
 	n.registerAccessor()
 	n.registerUserManager()
-	n.registerIPFSSubService()
+	n.registerOrderSubscriber()
 	n.registerOrderManager()
 	n.registerExtractor()
+	n.registerAccountManager()
 	n.registerGateway()
 	n.registerCrypto(nil)
-	n.registerAccountManager()
 
 	if "relay" == globalConfig.Mode {
 		n.registerRelayNode()
@@ -143,7 +143,9 @@ func (n *Node) Start() {
 }
 
 func (n *Node) startAfterExtractorSync(input eventemitter.EventData) error {
-	n.ipfsSubService.Start()
+	n.orderSubscriber.Start()
+	gateway.StartOrderBookSync()
+	gateway.StartRebroadcaster()
 	n.marketCapProvider.Start()
 
 	if "relay" == n.globalConfig.Mode {
@@ -187,7 +189,15 @@ func (n *Node) Wait() {
 
 func (n *Node) Stop() {
 	n.lock.RLock()
-	n.mineNode.Stop()
+	if nil != n.relayNode {
+		n.relayNode.Stop()
+	}
+	if nil != n.mineNode {
+		n.mineNode.Stop()
+	}
+	n.orderSubscriber.Stop()
+	gateway.StopRebroadcaster()
+	gateway.StopBroadcaster()
 	//
 	//n.p2pListener.Stop()
 	//n.chainListener.Stop()
@@ -221,8 +231,8 @@ func (n *Node) registerExtractor() {
 	n.extractorService = extractor.NewExtractorService(n.globalConfig.Common, n.accessor, n.rdsService)
 }
 
-func (n *Node) registerIPFSSubService() {
-	n.ipfsSubService = gateway.NewIPFSSubService(n.globalConfig.Ipfs)
+func (n *Node) registerOrderSubscriber() {
+	n.orderSubscriber = gateway.NewOrderSubscriber(&n.globalConfig.Ipfs)
 }
 
 func (n *Node) registerOrderManager() {
@@ -238,8 +248,9 @@ func (n *Node) registerAccountManager() {
 }
 
 func (n *Node) registerJsonRpcService() {
-	ethForwarder := gateway.EthForwarder{Accessor: *n.accessor}
-	n.relayNode.jsonRpcService = *gateway.NewJsonrpcService(strconv.Itoa(n.globalConfig.Jsonrpc.Port), n.relayNode.trendManager, n.orderManager, n.accountManager, &ethForwarder, n.marketCapProvider)
+	ethForwarder := gateway.NewEthForwarder(&n.globalConfig.Jsonrpc, *n.accessor, n.rdsService)
+	evaluator := miner.NewEvaluator(n.marketCapProvider, n.globalConfig.Miner.RateRatioCVSThreshold, n.accessor)
+	n.relayNode.jsonRpcService = *gateway.NewJsonrpcService(&n.globalConfig.Jsonrpc, n.relayNode.trendManager, n.orderManager, n.accountManager, ethForwarder, n.marketCapProvider, evaluator)
 }
 
 func (n *Node) registerMiner() {
@@ -251,7 +262,7 @@ func (n *Node) registerMiner() {
 }
 
 func (n *Node) registerGateway() {
-	gateway.Initialize(&n.globalConfig.GatewayFilters, &n.globalConfig.Gateway, &n.globalConfig.Ipfs, n.orderManager)
+	gateway.Initialize(&n.globalConfig.GatewayFilters, &n.globalConfig.Gateway, &n.globalConfig.Ipfs, n.orderManager, &n.accountManager, n.userManager, n.marketCapProvider)
 }
 
 func (n *Node) registerUserManager() {
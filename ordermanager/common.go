@@ -22,10 +22,12 @@ import (
 	"fmt"
 	"github.com/Loopring/relay/dao"
 	"github.com/Loopring/relay/ethaccessor"
+	"github.com/Loopring/relay/log"
 	"github.com/Loopring/relay/market/util"
 	"github.com/Loopring/relay/marketcap"
 	"github.com/Loopring/relay/types"
 	"math/big"
+	"time"
 )
 
 var dustOrderValue int64
@@ -67,13 +69,18 @@ func newOrderEntity(state *types.OrderState, accessor *ethaccessor.EthNodeAccess
 	return model, nil
 }
 
+// settleOrderStatus 过期的订单仍需结算链上的成交和取消，但未完成时保持ORDER_EXPIRED，不再回到订单簿
 func settleOrderStatus(state *types.OrderState, mc marketcap.MarketCapProvider) {
+	expired := state.Status == types.ORDER_EXPIRED
 	if new(big.Int).Add(state.CancelledAmountS, state.DealtAmountS).Cmp(big.NewInt(0)) <= 0 {
 		state.Status = types.ORDER_NEW
 	} else {
 		finished := isOrderFullFinished(state, mc)
 		state.SettleFinishedStatus(finished)
 	}
+	if expired && state.Status != types.ORDER_FINISHED {
+		state.Status = types.ORDER_EXPIRED
+	}
 }
 
 func isOrderFullFinished(state *types.OrderState, mc marketcap.MarketCapProvider) bool {
@@ -112,3 +119,17 @@ func blockNumberToString(blockNumber *big.Int) string {
 
 	return blockNumberStr
 }
+
+// saveOrderEvent 以订单当前快照追加一条生命周期记录,失败只记录日志不影响主流程
+func saveOrderEvent(rds dao.RdsService, event string, order *dao.Order, blockNumber int64, txHash, ringHash string) {
+	model := &dao.OrderEvent{}
+	model.ConvertDown(event, order)
+	model.BlockNumber = blockNumber
+	model.TxHash = txHash
+	model.RingHash = ringHash
+	model.CreateTime = time.Now().Unix()
+
+	if err := rds.Add(model); err != nil {
+		log.Errorf("order manager,save order %s event %s error:%s", order.OrderHash, event, err.Error())
+	}
+}
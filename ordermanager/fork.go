@@ -85,6 +85,7 @@ func (p *forkProcessor) fork(event *types.ForkedEvent) error {
 			log.Debugf("order manager fork error:%s", err.Error())
 			continue
 		}
+		saveOrderEvent(p.dao, dao.OrderEventForkRollback, model, from, "", "")
 	}
 
 	return nil
@@ -30,22 +30,28 @@ import (
 	"github.com/Loopring/relay/usermanager"
 	"github.com/ethereum/go-ethereum/common"
 	"math/big"
+	"time"
 )
 
 type OrderManager interface {
 	Start()
 	Stop()
 	MinerOrders(protocol, tokenS, tokenB common.Address, length int, startBlockNumber, endBlockNumber int64, filterOrderHashLists ...*types.OrderDelayList) []*types.OrderState
-	GetOrderBook(protocol, tokenS, tokenB common.Address, length int) ([]types.OrderState, error)
+	GetOrderBook(protocol, tokenS, tokenB common.Address, offset, length int) ([]types.OrderState, int, error)
 	GetOrders(query map[string]interface{}, pageIndex, pageSize int) (dao.PageResult, error)
 	GetOrderByHash(hash common.Hash) (*types.OrderState, error)
 	UpdateBroadcastTimeByHash(hash common.Hash, bt int) error
+	GetOrdersForBroadcast(dueBefore []int64, length int) ([]types.OrderState, error)
 	FillsPageQuery(query map[string]interface{}, pageIndex, pageSize int) (dao.PageResult, error)
 	RingMinedPageQuery(query map[string]interface{}, pageIndex, pageSize int) (dao.PageResult, error)
 	IsOrderCutoff(protocol, owner common.Address, createTime *big.Int) bool
 	IsOrderFullFinished(state *types.OrderState) bool
 	GetFrozenAmount(owner common.Address, token common.Address, statusSet []types.OrderStatus) (*big.Int, error)
 	GetFrozenLRCFee(owner common.Address, statusSet []types.OrderStatus) (*big.Int, error)
+	GetOpenOrderCount(owner common.Address, market string) (int, error)
+	GetOpenOrderHashes(protocol common.Address, market string) ([]common.Hash, error)
+	GetOrdersByHash(hashes []common.Hash) ([]types.OrderState, error)
+	GetOrderHistory(hash common.Hash) ([]dao.OrderEvent, error)
 }
 
 type OrderManagerImpl struct {
@@ -63,6 +69,7 @@ type OrderManagerImpl struct {
 	cutoffOrderWatcher *eventemitter.Watcher
 	forkWatcher        *eventemitter.Watcher
 	forkComplete       bool
+	stopExpireSweeper  chan struct{}
 }
 
 func NewOrderManager(
@@ -103,6 +110,8 @@ func (om *OrderManagerImpl) Start() {
 	eventemitter.On(eventemitter.OrderManagerExtractorCancel, om.cancelOrderWatcher)
 	eventemitter.On(eventemitter.OrderManagerExtractorCutoff, om.cutoffOrderWatcher)
 	eventemitter.On(eventemitter.ChainForkProcess, om.forkWatcher)
+
+	om.startExpireSweeper()
 }
 
 func (om *OrderManagerImpl) Stop() {
@@ -112,6 +121,51 @@ func (om *OrderManagerImpl) Stop() {
 	eventemitter.Un(eventemitter.OrderManagerExtractorCancel, om.cancelOrderWatcher)
 	eventemitter.Un(eventemitter.OrderManagerExtractorCutoff, om.cutoffOrderWatcher)
 	eventemitter.Un(eventemitter.ChainForkProcess, om.forkWatcher)
+
+	close(om.stopExpireSweeper)
+}
+
+// 定期将已过期的NEW/PARTIAL订单状态置为ORDER_EXPIRED
+func (om *OrderManagerImpl) startExpireSweeper() {
+	interval := time.Duration(om.options.ExpireSweepInterval) * time.Second
+	if interval <= 0 {
+		//default 1 min
+		interval = time.Minute
+	}
+
+	om.stopExpireSweeper = make(chan struct{})
+	go func() {
+		for {
+			select {
+			case <-time.After(interval):
+				om.sweepExpiredOrders()
+			case <-om.stopExpireSweeper:
+				return
+			}
+		}
+	}()
+}
+
+// 每次最多置expireSweepBatchSize个订单过期，直到没有过期的订单
+const expireSweepBatchSize = 1000
+
+func (om *OrderManagerImpl) sweepExpiredOrders() {
+	now := time.Now().Unix()
+	for {
+		list, err := om.rds.SetExpiredOrders(now, expireSweepBatchSize)
+		if err != nil {
+			log.Errorf("order manager,sweep expired orders error:%s", err.Error())
+			return
+		}
+
+		for _, v := range list {
+			v.Status = uint8(types.ORDER_EXPIRED)
+			saveOrderEvent(om.rds, dao.OrderEventExpired, &v, 0, "", "")
+		}
+		if len(list) < expireSweepBatchSize {
+			return
+		}
+	}
 }
 
 func (om *OrderManagerImpl) handleFork(input eventemitter.EventData) error {
@@ -134,7 +188,12 @@ func (om *OrderManagerImpl) handleGatewayOrder(input eventemitter.EventData) err
 		return err
 	}
 
-	return om.rds.Add(model)
+	if err := om.rds.Add(model); err != nil {
+		return err
+	}
+
+	saveOrderEvent(om.rds, dao.OrderEventNew, model, model.UpdatedBlock, "", "")
+	return nil
 }
 
 func (om *OrderManagerImpl) handleRingMined(input eventemitter.EventData) error {
@@ -216,6 +275,7 @@ func (om *OrderManagerImpl) handleOrderFilled(input eventemitter.EventData) erro
 	if err := om.rds.UpdateOrderWhileFill(state.RawOrder.Hash, state.Status, state.DealtAmountS, state.DealtAmountB, state.SplitAmountS, state.SplitAmountB, state.UpdatedBlock); err != nil {
 		return err
 	}
+	saveOrderEvent(om.rds, dao.OrderEventFill, model, event.Blocknumber.Int64(), event.TxHash.Hex(), event.Ringhash.Hex())
 
 	return nil
 }
@@ -267,6 +327,7 @@ func (om *OrderManagerImpl) handleOrderCancelled(input eventemitter.EventData) e
 	if err := om.rds.UpdateOrderWhileCancel(state.RawOrder.Hash, state.Status, state.CancelledAmountS, state.CancelledAmountB, state.UpdatedBlock); err != nil {
 		return err
 	}
+	saveOrderEvent(om.rds, dao.OrderEventCancel, model, event.Blocknumber.Int64(), event.TxHash.Hex(), "")
 
 	return nil
 }
@@ -293,7 +354,15 @@ func (om *OrderManagerImpl) handleOrderCutoff(input eventemitter.EventData) erro
 		return fmt.Errorf("order manager,handle cutoff error: cutoffCache add or del failed")
 	}
 
-	om.rds.SetCutOff(owner, currentCutoff)
+	list, err := om.rds.SetCutOff(owner, currentCutoff)
+	if err != nil {
+		return fmt.Errorf("order manager,handle cutoff error:%s", err.Error())
+	}
+	for _, v := range list {
+		v.Status = uint8(types.ORDER_CUTOFF)
+		saveOrderEvent(om.rds, dao.OrderEventCutoff, &v, event.Blocknumber.Int64(), event.TxHash.Hex(), "")
+	}
+
 	log.Debugf("order manager,handle cutoff event, owner:%s, cutoffTimestamp:%s", event.Owner.Hex(), event.Cutoff.String())
 	return nil
 }
@@ -307,7 +376,7 @@ func (om *OrderManagerImpl) MinerOrders(protocol, tokenS, tokenB common.Address,
 		list         []*types.OrderState
 		modelList    []*dao.Order
 		err          error
-		filterStatus = []types.OrderStatus{types.ORDER_FINISHED, types.ORDER_CUTOFF, types.ORDER_CANCEL}
+		filterStatus = []types.OrderStatus{types.ORDER_FINISHED, types.ORDER_CUTOFF, types.ORDER_CANCEL, types.ORDER_EXPIRED}
 	)
 
 	// 如果正在分叉，则不提供任何订单
@@ -343,11 +412,12 @@ func (om *OrderManagerImpl) MinerOrders(protocol, tokenS, tokenB common.Address,
 	return list
 }
 
-func (om *OrderManagerImpl) GetOrderBook(protocol, tokenS, tokenB common.Address, length int) ([]types.OrderState, error) {
+// GetOrderBook returns a page of open orders and the number of rows read, rows failing to convert are skipped
+func (om *OrderManagerImpl) GetOrderBook(protocol, tokenS, tokenB common.Address, offset, length int) ([]types.OrderState, int, error) {
 	var list []types.OrderState
-	models, err := om.rds.GetOrderBook(protocol, tokenS, tokenB, length)
+	models, err := om.rds.GetOrderBook(protocol, tokenS, tokenB, offset, length)
 	if err != nil {
-		return list, err
+		return list, 0, err
 	}
 
 	for _, v := range models {
@@ -358,7 +428,7 @@ func (om *OrderManagerImpl) GetOrderBook(protocol, tokenS, tokenB common.Address
 		list = append(list, state)
 	}
 
-	return list, nil
+	return list, len(models), nil
 }
 
 func (om *OrderManagerImpl) GetOrders(query map[string]interface{}, pageIndex, pageSize int) (dao.PageResult, error) {
@@ -400,6 +470,53 @@ func (om *OrderManagerImpl) GetOrderByHash(hash common.Hash) (orderState *types.
 	return &result, nil
 }
 
+// GetOrdersByHash returns orders stored of hashes, unknown hashes are skipped
+func (om *OrderManagerImpl) GetOrdersByHash(hashes []common.Hash) ([]types.OrderState, error) {
+	var list []types.OrderState
+	if len(hashes) == 0 {
+		return list, nil
+	}
+
+	hexes := make([]string, len(hashes))
+	for i, v := range hashes {
+		hexes[i] = v.Hex()
+	}
+	models, err := om.rds.GetOrdersByHash(hexes)
+	if err != nil {
+		return list, err
+	}
+	for _, v := range models {
+		var state types.OrderState
+		if err := v.ConvertUp(&state); err != nil {
+			continue
+		}
+		list = append(list, state)
+	}
+	return list, nil
+}
+
+func (om *OrderManagerImpl) GetOrderHistory(hash common.Hash) ([]dao.OrderEvent, error) {
+	return om.rds.GetOrderEvents(hash)
+}
+
+// GetOrdersForBroadcast returns open orders due to be broadcast again
+func (om *OrderManagerImpl) GetOrdersForBroadcast(dueBefore []int64, length int) ([]types.OrderState, error) {
+	var list []types.OrderState
+	models, err := om.rds.GetOrdersForBroadcast(dueBefore, length)
+	if err != nil {
+		return list, err
+	}
+
+	for _, v := range models {
+		var state types.OrderState
+		if err := v.ConvertUp(&state); err != nil {
+			continue
+		}
+		list = append(list, state)
+	}
+	return list, nil
+}
+
 func (om *OrderManagerImpl) UpdateBroadcastTimeByHash(hash common.Hash, bt int) error {
 	return om.rds.UpdateBroadcastTimeByHash(hash.Hex(), bt)
 }
@@ -440,6 +557,22 @@ func (om *OrderManagerImpl) GetFrozenAmount(owner common.Address, token common.A
 	return totalAmount, nil
 }
 
+func (om *OrderManagerImpl) GetOpenOrderCount(owner common.Address, market string) (int, error) {
+	return om.rds.GetOpenOrderCount(owner, market)
+}
+
+func (om *OrderManagerImpl) GetOpenOrderHashes(protocol common.Address, market string) ([]common.Hash, error) {
+	var list []common.Hash
+	hashes, err := om.rds.GetOpenOrderHashes(protocol, market)
+	if err != nil {
+		return list, err
+	}
+	for _, v := range hashes {
+		list = append(list, common.HexToHash(v))
+	}
+	return list, nil
+}
+
 func (om *OrderManagerImpl) GetFrozenLRCFee(owner common.Address, statusSet []types.OrderStatus) (*big.Int, error) {
 	orderList, err := om.rds.GetFrozenLrcFee(owner, statusSet)
 	if err != nil {
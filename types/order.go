@@ -34,6 +34,7 @@ const (
 	ORDER_FINISHED
 	ORDER_CANCEL
 	ORDER_CUTOFF
+	ORDER_EXPIRED
 )
 
 //订单原始信息
@@ -249,6 +250,7 @@ type OrderState struct {
 	CancelledAmountB *big.Int    `json:"cancelledAmountB"`
 	Status           OrderStatus `json:"status"`
 	BroadcastTime    int         `json:"broadcastTime"`
+	IsLocal          bool        `json:"isLocal"` // 通过本relay的json-rpc提交，只有这些订单由本relay重新广播
 }
 
 type OrderDelayList struct {
@@ -0,0 +1,29 @@
+/*
+
+  Copyright 2017 Loopring Project Ltd (Loopring Foundation).
+
+  Licensed under the Apache License, Version 2.0 (the "License");
+  you may not use this file except in compliance with the License.
+  You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+  Unless required by applicable law or agreed to in writing, software
+  distributed under the License is distributed on an "AS IS" BASIS,
+  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+  See the License for the specific language governing permissions and
+  limitations under the License.
+
+*/
+
+package types
+
+type TxStatus uint8
+
+const (
+	TX_STATUS_UNKNOWN TxStatus = iota
+	TX_STATUS_PENDING
+	TX_STATUS_MINED
+	TX_STATUS_FAILED
+	TX_STATUS_DROPPED
+)